@@ -0,0 +1,231 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/nav"
+)
+
+// Annotation is a highlighted passage of a chapter with an optional note.
+// Start and End are text offsets, so annotations stay in place when the
+// layout changes. End is exclusive.
+type Annotation struct {
+	Chapter int       `json:"chapter"`
+	Start   int       `json:"start"`
+	End     int       `json:"end"`
+	Text    string    `json:"text"`
+	Note    string    `json:"note,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// selection is the text selected in visual mode, between the text offsets
+// where it was started and where the cursor is now.
+type selection struct {
+	anchor, cursor int
+}
+
+// bounds returns the selected text offsets, with end exclusive.
+func (s *selection) bounds() (start, end int) {
+	if s.anchor < s.cursor {
+		return s.anchor, s.cursor + 1
+	}
+	return s.cursor, s.anchor + 1
+}
+
+// VisualMode starts selecting text at the top of the page. The selection is
+// extended with the movement keys and annotated on Enter.
+func (a *app) VisualMode() {
+	if a.doc.TextLen() == 0 {
+		return
+	}
+	cursor := a.doc.Offset(a.pager.ScrollY())
+	if cursor >= a.doc.TextLen() {
+		cursor = a.doc.TextLen() - 1
+	}
+	a.visual = &selection{anchor: cursor, cursor: cursor}
+	a.updateHighlights()
+}
+
+// handleVisualKey moves the cursor of the selection, annotating the selected
+// text on Enter and leaving visual mode on Esc or v.
+func (a *app) handleVisualKey(ev termbox.Event) {
+	switch {
+	case ev.Key == termbox.KeyArrowRight || ev.Ch == 'l':
+		a.moveCursor(a.visual.cursor + 1)
+	case ev.Key == termbox.KeyArrowLeft || ev.Ch == 'h':
+		a.moveCursor(a.visual.cursor - 1)
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		a.moveCursor(a.cursorRow(1))
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		a.moveCursor(a.cursorRow(-1))
+	case ev.Ch == '0':
+		a.moveCursor(a.doc.Offset(a.doc.Row(a.doc.TextCell(a.visual.cursor))))
+	case ev.Ch == '$':
+		row := a.doc.Row(a.doc.TextCell(a.visual.cursor))
+		a.moveCursor(a.doc.Offset(row+1) - 1)
+	case ev.Key == termbox.KeyEnter:
+		start, end := a.visual.bounds()
+		a.openPrompt(nav.NewPrompt("Note: "), func(note string) {
+			a.annotate(start, end, note)
+		})
+		a.visual = nil
+	case ev.Key == termbox.KeyEsc || ev.Ch == 'v':
+		a.visual = nil
+	}
+	a.updateHighlights()
+}
+
+// moveCursor moves the selection's cursor to a text offset, scrolling the
+// pager to keep it on screen.
+func (a *app) moveCursor(offset int) {
+	if offset < 0 || offset >= a.doc.TextLen() {
+		return
+	}
+	a.visual.cursor = offset
+
+	row := a.doc.Row(a.doc.TextCell(offset))
+	height := a.pager.PageRows()
+	if row < a.pager.ScrollY() {
+		a.pager.SetScrollY(row)
+	} else if row >= a.pager.ScrollY()+height {
+		a.pager.SetScrollY(row - height + 1)
+	}
+}
+
+// cursorRow returns the text offset in the nearest row with text above
+// (delta < 0) or below (delta > 0) the cursor, keeping the cursor's column
+// where possible.
+func (a *app) cursorRow(delta int) int {
+	doc := &a.doc
+	cell := doc.TextCell(a.visual.cursor)
+	row, col := doc.Row(cell), cell%doc.Width
+
+	var first int
+	if delta > 0 {
+		first = doc.Offset(row + 1)
+		if first >= doc.TextLen() {
+			return a.visual.cursor
+		}
+	} else {
+		last := doc.Offset(row) - 1
+		if last < 0 {
+			return a.visual.cursor
+		}
+		first = doc.Offset(doc.Row(doc.TextCell(last)))
+	}
+
+	target := doc.Row(doc.TextCell(first))
+	if offset := doc.TextOffset(target*doc.Width + col); offset > first {
+		return offset
+	}
+	return first
+}
+
+// annotate saves the text from offset start up to end in the current chapter
+// as an annotation.
+func (a *app) annotate(start, end int, note string) {
+	a.mark.Annotations = append(a.mark.Annotations, &Annotation{
+		Chapter: a.chapter,
+		Start:   start,
+		End:     end,
+		Text:    a.doc.Text(start, end),
+		Note:    note,
+		Created: time.Now(),
+	})
+	sort.SliceStable(a.mark.Annotations, func(i, j int) bool {
+		x, y := a.mark.Annotations[i], a.mark.Annotations[j]
+		if x.Chapter != y.Chapter {
+			return x.Chapter < y.Chapter
+		}
+		return x.Start < y.Start
+	})
+	a.updateHighlights()
+}
+
+// ShowAnnotations opens a menu listing the book's annotations. Selecting one
+// jumps to it, and d deletes it.
+func (a *app) ShowAnnotations() {
+	if len(a.mark.Annotations) == 0 {
+		a.pager.DrawMsg("No annotations")
+		return
+	}
+
+	items := make([]string, len(a.mark.Annotations))
+	selected := 0
+	for i, an := range a.mark.Annotations {
+		items[i] = fmt.Sprintf("%3d  %s", an.Chapter+1, truncate(an.Text, 60))
+		if an.Note != "" {
+			items[i] += " - " + an.Note
+		}
+		if an.Chapter < a.chapter || (an.Chapter == a.chapter && an.Start <= a.doc.Offset(a.pager.ScrollY())) {
+			selected = i
+		}
+	}
+
+	a.openMenu(nav.NewMenu("Annotations", items, selected), func(i int) {
+		a.gotoAnnotation(a.mark.Annotations[i])
+	})
+	a.menuDelete = func(i int) {
+		a.mark.Annotations = append(a.mark.Annotations[:i], a.mark.Annotations[i+1:]...)
+		a.updateHighlights()
+		a.menu.Items = append(a.menu.Items[:i], a.menu.Items[i+1:]...)
+		if len(a.menu.Items) == 0 {
+			a.closeMenu()
+			return
+		}
+		a.menu.Select(i)
+	}
+}
+
+// gotoAnnotation opens the chapter of an annotation and scrolls to it.
+func (a *app) gotoAnnotation(an *Annotation) {
+	if an.Chapter < 0 || an.Chapter >= len(a.book.Spine.Itemrefs) {
+		return
+	}
+	if an.Chapter != a.chapter {
+		a.chapter = an.Chapter
+		if a.err = a.openChapter(); a.err != nil {
+			return
+		}
+	}
+	a.pager.SetScrollY(a.doc.Row(a.doc.TextCell(an.Start)))
+}
+
+// updateHighlights highlights the annotations, search matches and selection
+// in the current chapter, in increasing order of precedence.
+func (a *app) updateHighlights() {
+	theme := a.theme()
+	var highlights []nav.Highlight
+	for i, l := range a.doc.Links() {
+		style := theme.Link
+		if i == a.link {
+			style = theme.Selection
+		}
+		for _, r := range a.doc.TextRegions(l.Start, l.End) {
+			highlights = append(highlights, nav.Highlight{Region: r, Style: style})
+		}
+	}
+	for _, an := range a.mark.Annotations {
+		if an.Chapter != a.chapter {
+			continue
+		}
+		for _, r := range a.doc.TextRegions(an.Start, an.End) {
+			highlights = append(highlights, nav.Highlight{Region: r, Style: theme.Annotation})
+		}
+	}
+	for _, m := range a.matches {
+		if m.Chapter == a.chapter {
+			highlights = append(highlights, nav.Highlight{Region: m.Region, Style: theme.Search})
+		}
+	}
+	if a.visual != nil {
+		start, end := a.visual.bounds()
+		for _, r := range a.doc.TextRegions(start, end) {
+			highlights = append(highlights, nav.Highlight{Region: r, Style: theme.Selection})
+		}
+	}
+	a.pager.SetHighlights(highlights)
+}
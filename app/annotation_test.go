@@ -0,0 +1,58 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/nav"
+	"github.com/wormggmm/goreader/parse"
+)
+
+func TestAnnotate(t *testing.T) {
+	const text = "<p>Alice was beginning to get very tired of sitting by her sister on the bank.</p>"
+	doc, err := parse.ParseText(strings.NewReader(text), nil, parse.Option{Width: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{pager: new(nav.Pager), doc: doc, mark: &Mark{}, opt: &Option{}}
+	a.pager.SetDoc(doc)
+
+	// Select "to get" at the end of the second row of text.
+	a.VisualMode()
+	a.handleVisualKey(termbox.Event{Ch: 'j'})
+	a.handleVisualKey(termbox.Event{Ch: '$'})
+	a.visual.anchor = a.visual.cursor
+	for i := 0; i < 4; i++ {
+		a.handleVisualKey(termbox.Event{Ch: 'h'})
+	}
+	a.handleVisualKey(termbox.Event{Key: termbox.KeyEnter})
+	if a.visual != nil || a.prompt == nil {
+		t.Fatal("Expected the note prompt to replace visual mode")
+	}
+	a.promptSubmit("bored")
+
+	if len(a.mark.Annotations) != 1 {
+		t.Fatalf("Expected: %v, but got: %v\n", 1, len(a.mark.Annotations))
+	}
+	an := a.mark.Annotations[0]
+	if an.Text != "to get" || an.Note != "bored" {
+		t.Errorf("Expected: %v, but got: %v\n", Annotation{Text: "to get", Note: "bored"}, *an)
+	}
+}
+
+func TestSelectionBounds(t *testing.T) {
+	tests := []struct {
+		s          selection
+		start, end int
+	}{
+		{selection{3, 3}, 3, 4},
+		{selection{3, 7}, 3, 8},
+		{selection{7, 3}, 3, 8},
+	}
+	for _, tt := range tests {
+		if start, end := tt.s.bounds(); start != tt.start || end != tt.end {
+			t.Errorf("Expected: %v, but got: %v\n", []int{tt.start, tt.end}, []int{start, end})
+		}
+	}
+}
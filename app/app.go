@@ -1,10 +1,12 @@
 package app
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/logger"
 	termbox "github.com/nsf/termbox-go"
@@ -19,6 +21,18 @@ type Application interface {
 	Back()
 	NextChapter()
 	PrevChapter()
+	ShowTOC()
+	SearchForward()
+	SearchBackward()
+	NextMatch()
+	PrevMatch()
+	VisualMode()
+	ShowAnnotations()
+	ShowHelp()
+	NextLink()
+	FollowLink()
+	LinkBack()
+	ShowNote()
 
 	PageNavigator() nav.PageNavigator
 	Exit()
@@ -30,18 +44,34 @@ type Option struct {
 	DebugMode  bool
 	NoBlank    bool
 	GlobalHook bool
+	MaxWidth   int  // maximum layout width, 0 to follow the terminal width
+	Justify    bool // justify text to both edges
+	Hyphenate  bool // hyphenate words at the end of rows
+	Vertical   bool // lay out books written vertically in columns
+	LibraryDB  bool // keep marks in $XDG_DATA_HOME/goreader instead of .mark files
+
+	// Image sets how images are drawn. Images in parse.ImageBlocks mode are
+	// drawn in 24-bit color if Image.TrueColor is set, which puts the
+	// terminal in termbox.OutputRGB mode.
+	Image parse.ImageOption
+
+	// Keys maps action names to the key sequences that replace their default
+	// keys.
+	Keys map[string]KeyList
+	// Theme styles the text and the page. It defaults to parse.DefaultTheme.
+	Theme *parse.Theme
 }
 
 // app is used to store the current state of the application.
 type app struct {
-	book     *epub.Rootfile
-	pager    nav.PageNavigator
-	chapter  int
-	bookPath string
-	fileName string
-	opt      *Option
+	book    *epub.Rootfile
+	pager   nav.PageNavigator
+	doc     parse.Cellbuf
+	chapter int
+	opt     *Option
 
 	eventCh chan termbox.Event
+	keys    *keyBindings
 	err     error
 
 	exitSignal   chan bool
@@ -51,7 +81,41 @@ type app struct {
 	markInput    string
 	globalSwitch bool // global hook switch
 
-	mark *Mark
+	mark  *Mark
+	store markStore
+
+	// menu is an overlay drawn on top of the pager that receives key events
+	// while it is open. menuSelect is called with the chosen entry, and
+	// menuDelete, if set, with the entry to delete.
+	menu       *nav.Menu
+	menuSelect func(int)
+	menuDelete func(int)
+
+	// prompt is a line of input drawn at the bottom of the screen that
+	// receives key events while it is open. promptSubmit is called with the
+	// input when Enter is pressed.
+	prompt       *nav.Prompt
+	promptSubmit func(string)
+
+	search         *regexp.Regexp
+	searchBackward bool
+	matches        []parse.Match
+
+	// visual is the text being selected in visual mode, which receives key
+	// events while it is set.
+	visual *selection
+
+	// link is the index of the focused link of the current chapter, or -1.
+	// history holds the positions links were followed from, the last one
+	// on top.
+	link    int
+	history []Bookmark
+
+	// notes indexes the book's documents for the notes shown in popups.
+	// popup is set while the pager shows a note, which receives key events
+	// until it is closed.
+	notes *parse.IDIndex
+	popup bool
 }
 
 // NewApp creates an App
@@ -66,18 +130,33 @@ func NewApp(b *epub.Rootfile, bookpath string, opt *Option) Application {
 	logger.Info("Identifier:", b.Identifier)
 	logger.Info("Language:", b.Language)
 	logger.Info("Metadata:", b.Metadata)
-	filename := filepath.Base(bookpath)
-	bookpath = filepath.Dir(bookpath)
+	if b.TOCErr != nil {
+		logger.Warning("Failed to read table of contents:", b.TOCErr)
+	}
 	p := new(nav.Pager)
 	p.NotBlank = opt.NoBlank
+	p.Theme = opt.Theme
+	p.Image = opt.Image
+
+	absPath, err := filepath.Abs(bookpath)
+	if err != nil {
+		absPath = bookpath
+	}
+
 	return &app{pager: p,
-		book:       b,
-		exitSignal: make(chan bool, 1),
-		bookPath:   bookpath, opt: opt,
-		fileName:     filename,
+		book:         b,
+		exitSignal:   make(chan bool, 1),
+		opt:          opt,
 		eventCh:      make(chan termbox.Event, 1),
 		globalSwitch: opt.GlobalHook,
-		mark:         &Mark{Marks: make(map[string]*Bookmark)},
+		link:         -1,
+		notes:        parse.NewIDIndex(b),
+		mark: &Mark{
+			Marks: make(map[string]*Bookmark),
+			Title: b.Title,
+			Path:  absPath,
+		},
+		store: openMarkStore(b, bookpath, opt),
 	}
 }
 func (a *app) GlobalSwitch() bool {
@@ -91,47 +170,102 @@ func (a *app) Run() {
 		return
 	}
 	termbox.SetInputMode(termbox.InputEsc)
+	switch {
+	case a.opt.Image.TrueColor && a.opt.Image.Mode == parse.ImageBlocks:
+		termbox.SetOutputMode(termbox.OutputRGB)
+	case a.theme().Colors256(), a.opt.Image.Mode == parse.ImageBlocks:
+		termbox.SetOutputMode(termbox.Output256)
+	}
 	defer termbox.Flush()
 	defer termbox.Close()
-	keymap, chmap := initNavigationKeys(a)
-	hookCh := initKeyHook(a)
-	defer close(hookCh)
-	go func() {
-		for {
-			ev := termbox.PollEvent()
-			if !a.GlobalSwitch() {
-				a.eventCh <- ev
-			}
-		}
-	}()
+	if a.keys, a.err = newKeyBindings(a.opt.Keys); a.err != nil {
+		return
+	}
+	initKeyHook(a)
+	defer hook.End()
+	events := pollEvents()
 	if a.err = a.openChapter(); a.err != nil {
 		return
 	}
 	a.restore("")
+	a.mark.Stats.Opened++
+	a.mark.Stats.LastRead = time.Now()
+	a.record("")
 MainLoop:
 	for {
+		a.pager.SuppressImages(a.menu != nil || a.prompt != nil)
 		if a.err = a.pager.Draw(); a.err != nil {
 			return
 		}
+		if a.menu != nil {
+			if a.err = a.menu.Draw(); a.err != nil {
+				return
+			}
+		}
+		if a.prompt != nil {
+			if a.err = a.prompt.Draw(); a.err != nil {
+				return
+			}
+		}
 		logger.Info("draw")
 		select {
 		case <-a.exitSignal:
 			break MainLoop
 		case ev := <-a.eventCh:
-			switch ev.Type {
-			case termbox.EventKey:
-				logger.Info("action ch:", ev.Ch, " key:", ev.Key)
-				if action, ok := keymap[ev.Key]; ok {
-					action()
-				} else if action, ok := chmap[ev.Ch]; ok {
-					action()
-				}
-				a.record("")
+			a.handleEvent(ev)
+		case ev := <-events:
+			// Keys arrive through the global hook while it is switched on.
+			if ev.Type == termbox.EventKey && a.GlobalSwitch() {
+				break
 			}
+			a.handleEvent(ev)
 		}
 	}
 }
 
+// handleEvent dispatches a terminal event to the open prompt or menu, or to
+// the action bound to the key.
+func (a *app) handleEvent(ev termbox.Event) {
+	switch ev.Type {
+	case termbox.EventKey:
+		logger.Info("action ch:", ev.Ch, " key:", ev.Key)
+		if a.popup {
+			a.handlePopupKey(ev)
+		} else if a.prompt != nil {
+			a.handlePromptKey(ev)
+		} else if a.menu != nil {
+			a.handleMenuKey(ev)
+		} else if a.visual != nil {
+			a.handleVisualKey(ev)
+		} else {
+			a.keys.handle(a, ev)
+		}
+		a.record("")
+	case termbox.EventResize:
+		a.relayout()
+	}
+}
+
+var (
+	pollOnce   sync.Once
+	termEvents = make(chan termbox.Event)
+)
+
+// pollEvents returns a channel of terminal events. A single goroutine polls
+// for the whole process, since PollEvent cannot be stopped once termbox is
+// closed and would otherwise steal events from the next session when the
+// library reopens the terminal for another book.
+func pollEvents() <-chan termbox.Event {
+	pollOnce.Do(func() {
+		go func() {
+			for {
+				termEvents <- termbox.PollEvent()
+			}
+		}()
+	})
+	return termEvents
+}
+
 func (a *app) Err() error {
 	return a.err
 }
@@ -140,7 +274,8 @@ func (a *app) PageNavigator() nav.PageNavigator {
 	return a.pager
 }
 
-func initKeyHook(a *app) chan hook.Event {
+// initKeyHook starts the global key hook. It is stopped with hook.End.
+func initKeyHook(a *app) {
 	evChan := hook.Start()
 	go func() {
 		for hookEv := range evChan {
@@ -230,137 +365,379 @@ func initKeyHook(a *app) chan hook.Event {
 		}
 		logger.Info("hook exit")
 	}()
-	return evChan
 }
-func initNavigationKeys(a Application) (map[termbox.Key]func(), map[rune]func()) {
-	keymap := map[termbox.Key]func(){
-		// Pager
-		termbox.KeyArrowDown:  a.PageNavigator().ScrollDown,
-		termbox.KeyArrowUp:    a.PageNavigator().ScrollUp,
-		termbox.KeyArrowRight: a.PageNavigator().ScrollRight,
-		termbox.KeyArrowLeft:  a.PageNavigator().ScrollLeft,
 
-		termbox.MouseWheelUp:   a.PageNavigator().ScrollUp,
-		termbox.MouseWheelDown: a.PageNavigator().ScrollDown,
+// Exit requests app termination.
+func (a *app) Exit() {
+
+	a.exitSignal <- true
+}
+
+// Bookmark is a saved reading position. Offset is the text offset of the top
+// of the page within the chapter, which stays valid when the layout changes.
+// ScrollY is only read from mark files written by older versions.
+type Bookmark struct {
+	Chapter int `json:"chapter"`
+	Offset  int `json:"offset"`
+	ScrollY int `json:"scroll_y,omitempty"`
+}
+type Mark struct {
+	Bookmark
+	Marks       map[string]*Bookmark `json:"marks"`
+	Annotations []*Annotation        `json:"annotations,omitempty"`
+
+	Title string       `json:"title,omitempty"`
+	Path  string       `json:"path,omitempty"`
+	Stats ReadingStats `json:"stats"`
+}
+
+// idleTimeout is the longest pause between two actions that is still counted
+// as reading time.
+const idleTimeout = 5 * time.Minute
 
-		// Navigation
-		termbox.KeyEsc: a.Exit,
+// ReadingStats records how much a book has been read.
+type ReadingStats struct {
+	Opened      int       `json:"opened"`
+	LastRead    time.Time `json:"last_read"`
+	ReadSeconds int       `json:"read_seconds"`
+}
+
+// update adds the time since the last action to the reading time, unless the
+// reader was idle.
+func (s *ReadingStats) update(now time.Time) {
+	if elapsed := now.Sub(s.LastRead); elapsed > 0 && elapsed < idleTimeout {
+		s.ReadSeconds += int(elapsed.Seconds())
 	}
+	s.LastRead = now
+}
 
-	chmap := map[rune]func(){
-		// PageNavigator
-		'j': a.PageNavigator().ScrollDown,
-		'k': a.PageNavigator().ScrollUp,
-		'h': a.PageNavigator().ScrollLeft,
-		'l': a.PageNavigator().ScrollRight,
-		'g': a.PageNavigator().ToTop,
-		'G': a.PageNavigator().ToBottom,
+// row returns the row of doc the bookmark points to.
+func (b *Bookmark) row(doc *parse.Cellbuf) int {
+	if b.ScrollY > 0 {
+		return b.ScrollY
+	}
+	return doc.RowAt(b.Offset)
+}
 
-		// Navigation
-		'q': a.Exit,
-		'f': a.Forward,
-		'b': a.Back,
-		'F': a.NextChapter,
-		'B': a.PrevChapter,
+// bookmark returns a Bookmark for the top of the current page.
+func (a *app) bookmark() Bookmark {
+	return Bookmark{
+		Chapter: a.chapter,
+		Offset:  a.doc.Offset(a.pager.ScrollY()),
 	}
+}
 
-	return keymap, chmap
+// restore loads the saved marks and opens the position of the named bookmark,
+// or the last read position if markKey is empty.
+func (a *app) restore(markKey string) {
+	if err := a.store.load(a.mark); err != nil {
+		logger.Warning("Failed to load marks:", err)
+		return
+	}
+	bookmark := a.mark.Bookmark
+	if markKey != "" && a.mark.Marks[markKey] != nil {
+		bookmark = *a.mark.Marks[markKey]
+	}
+	if bookmark.Chapter < 0 || bookmark.Chapter >= len(a.book.Spine.Itemrefs) {
+		logger.Warning("Bookmark chapter out of range:", bookmark.Chapter)
+		return
+	}
+	logger.Info("restore chapter:", bookmark.Chapter, " offset:", bookmark.Offset)
+	a.chapter = bookmark.Chapter
+	a.openChapter()
+	a.pager.SetScrollY(bookmark.row(&a.doc))
 }
 
-// Exit requests app termination.
-func (a *app) Exit() {
+// record saves the current position as the last read position, and as a
+// named bookmark if markKey is not empty.
+func (a *app) record(markKey string) {
+	a.mark.Bookmark = a.bookmark()
+	if markKey != "" {
+		bookmark := a.bookmark()
+		a.mark.Marks[markKey] = &bookmark
+	}
+	a.mark.Stats.update(time.Now())
+	if err := a.store.save(a.mark); err != nil {
+		logger.Warning("Failed to save marks:", err)
+	}
+}
 
-	a.exitSignal <- true
+// handleMenuKey navigates the open menu, selecting an entry on Enter,
+// deleting it on d if the menu allows it, and closing it on Esc or q.
+func (a *app) handleMenuKey(ev termbox.Event) {
+	switch {
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		a.menu.Down()
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		a.menu.Up()
+	case ev.Key == termbox.KeyPgdn || ev.Ch == 'f':
+		a.menu.PageDown()
+	case ev.Key == termbox.KeyPgup || ev.Ch == 'b':
+		a.menu.PageUp()
+	case ev.Ch == 'g':
+		a.menu.ToTop()
+	case ev.Ch == 'G':
+		a.menu.ToBottom()
+	case ev.Key == termbox.KeyEnter:
+		selected, onSelect := a.menu.Selected, a.menuSelect
+		a.closeMenu()
+		onSelect(selected)
+	case ev.Ch == 'd' && a.menuDelete != nil:
+		a.menuDelete(a.menu.Selected)
+	case ev.Key == termbox.KeyEsc || ev.Ch == 'q':
+		a.closeMenu()
+	}
 }
 
-type Bookmark struct {
-	Chapter int `json:"chapter"`
-	ScrollY int `json:"scroll_y"`
+// openMenu shows a menu overlay that calls onSelect with the index of the
+// chosen entry.
+func (a *app) openMenu(m *nav.Menu, onSelect func(int)) {
+	a.menu = m
+	a.menuSelect = onSelect
 }
-type Mark struct {
-	Chapter int                  `json:"chapter"`
-	ScrollY int                  `json:"scroll_y"`
-	Marks   map[string]*Bookmark `json:"marks"`
+
+func (a *app) closeMenu() {
+	a.menu = nil
+	a.menuSelect = nil
+	a.menuDelete = nil
 }
 
-func (a *app) markFilePath() string {
-	markFilePath := filepath.Join(a.bookPath, "."+a.fileName+".mark")
-	return markFilePath
+// handlePromptKey edits the open prompt, submitting it on Enter and closing it
+// on Esc or when backspacing past the start of the input.
+func (a *app) handlePromptKey(ev termbox.Event) {
+	switch {
+	case ev.Key == termbox.KeyEnter:
+		input, onSubmit := a.prompt.String(), a.promptSubmit
+		a.closePrompt()
+		onSubmit(input)
+	case ev.Key == termbox.KeyEsc:
+		a.closePrompt()
+	case ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2:
+		if a.prompt.Empty() {
+			a.closePrompt()
+		} else {
+			a.prompt.Backspace()
+		}
+	case ev.Key == termbox.KeySpace:
+		a.prompt.Insert(' ')
+	case ev.Ch != 0:
+		a.prompt.Insert(ev.Ch)
+	}
 }
-func (a *app) unmarshalMark(bytes []byte) error {
-	err := json.Unmarshal(bytes, a.mark)
+
+// openPrompt shows a prompt that calls onSubmit with the entered text.
+func (a *app) openPrompt(p *nav.Prompt, onSubmit func(string)) {
+	a.prompt = p
+	a.promptSubmit = onSubmit
+}
+
+func (a *app) closePrompt() {
+	a.prompt = nil
+	a.promptSubmit = nil
+	termbox.HideCursor()
+}
+
+// SearchForward prompts for a pattern and jumps to its next match in the book.
+func (a *app) SearchForward() {
+	a.promptSearch(false)
+}
+
+// SearchBackward prompts for a pattern and jumps to its previous match in the
+// book.
+func (a *app) SearchBackward() {
+	a.promptSearch(true)
+}
+
+// promptSearch opens a search prompt. Like less, submitting an empty pattern
+// repeats the previous search in the new direction.
+func (a *app) promptSearch(backward bool) {
+	prefix := "/"
+	if backward {
+		prefix = "?"
+	}
+	a.openPrompt(nav.NewPrompt(prefix), func(pattern string) {
+		if pattern != "" {
+			if err := a.setSearch(pattern); err != nil {
+				logger.Warning("Failed to search:", err)
+				a.pager.DrawMsg(fmt.Sprintf("Invalid pattern: %s", err))
+				return
+			}
+		}
+		a.searchBackward = backward
+		a.jumpToMatch(backward)
+	})
+}
+
+// setSearch finds every match of pattern across the book and highlights those
+// in the current chapter.
+func (a *app) setSearch(pattern string) error {
+	re, err := parse.CompileSearch(pattern, false)
 	if err != nil {
-		logger.Error("Failed to unmarshal mark file:", err)
 		return err
 	}
-	if a.mark.Marks == nil {
-		a.mark.Marks = make(map[string]*Bookmark)
+	matches, err := parse.Search(a.book, re, a.parseOption())
+	if err != nil {
+		return err
 	}
+	a.search = re
+	a.matches = matches
+	a.updateHighlights()
 	return nil
 }
-func (a *app) marshalMark() ([]byte, error) {
-	return json.Marshal(a.mark)
+
+// NextMatch jumps to the next match in the direction of the last search.
+func (a *app) NextMatch() {
+	a.jumpToMatch(a.searchBackward)
+}
+
+// PrevMatch jumps to the next match in the opposite direction of the last
+// search.
+func (a *app) PrevMatch() {
+	a.jumpToMatch(!a.searchBackward)
 }
-func (a *app) restore(markKey string) {
-	markFilePath := a.markFilePath()
-	markFile, err := os.OpenFile(markFilePath, os.O_RDONLY, 0644)
-	if err != nil {
-		logger.Warning("Failed to open mark file:", err)
+
+// jumpToMatch scrolls to the first match after (or before) the top line of
+// the page, wrapping around the ends of the book.
+func (a *app) jumpToMatch(backward bool) {
+	if a.search == nil {
 		return
 	}
-	defer markFile.Close()
-	b := make([]byte, 256)
-	count, err := markFile.Read(b)
-	if err != nil {
-		logger.Error("Failed to read mark file:", err)
+	if len(a.matches) == 0 {
+		a.pager.DrawMsg("Pattern not found")
 		return
 	}
-	err = a.unmarshalMark(b[:count])
-	if err != nil {
-		logger.Error("Failed to unmarshal mark file:", err)
-		return
+
+	row := a.pager.ScrollY()
+	target := a.matches[0]
+	if backward {
+		target = a.matches[len(a.matches)-1]
+		for i := len(a.matches) - 1; i >= 0; i-- {
+			m := a.matches[i]
+			if m.Chapter < a.chapter || (m.Chapter == a.chapter && m.Row < row) {
+				target = m
+				break
+			}
+		}
+	} else {
+		for _, m := range a.matches {
+			if m.Chapter > a.chapter || (m.Chapter == a.chapter && m.Row > row) {
+				target = m
+				break
+			}
+		}
 	}
-	chapter := a.mark.Chapter
-	scrollY := a.mark.ScrollY
-	if markKey != "" && a.mark.Marks[markKey] != nil {
-		chapter = a.mark.Marks[markKey].Chapter
-		scrollY = a.mark.Marks[markKey].ScrollY
+
+	if target.Chapter != a.chapter {
+		a.chapter = target.Chapter
+		if a.err = a.openChapter(); a.err != nil {
+			return
+		}
 	}
-	logger.Info("restore chapter:", chapter, " scrollY:", scrollY)
-	a.chapter = chapter
-	a.openChapter()
-	a.pager.SetScrollY(scrollY)
+	a.pager.SetScrollY(target.Row)
 }
-func (a *app) record(markKey string) {
-	markFilePath := a.markFilePath()
-	markFile, err := os.OpenFile(markFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	// logger.Infof("path: %s,record: chapter=%d, page=%d", markFilePath, a.chapter, a.pager.ScrollY())
-	if err != nil {
-		logger.Warning("Failed to open mark file:", err)
+
+// ShowHelp opens a menu listing the key bindings. Selecting an entry runs its
+// action.
+func (a *app) ShowHelp() {
+	a.openMenu(nav.NewMenu("Keys", a.keys.helpLines(), 0), func(i int) {
+		actions[i].run(a)
+	})
+}
+
+// ShowTOC opens a menu listing the book's table of contents. Selecting an
+// entry jumps to its chapter and anchor.
+func (a *app) ShowTOC() {
+	points, depths := a.book.TOC.Flatten()
+	if len(points) == 0 {
+		a.pager.DrawMsg("No table of contents")
 		return
 	}
-	defer markFile.Close()
-	if a.mark == nil {
-		a.unmarshalMark([]byte("{}"))
+
+	items := make([]string, len(points))
+	selected := 0
+	for i, np := range points {
+		items[i] = strings.Repeat("  ", depths[i]) + np.Title
+		if idx := a.book.Spine.SpineIndex(np.HREF); idx >= 0 && idx <= a.chapter {
+			selected = i
+		}
 	}
-	a.mark.Chapter = a.chapter
-	a.mark.ScrollY = a.pager.ScrollY()
-	if markKey != "" {
-		a.mark.Marks[markKey] = &Bookmark{
-			Chapter: a.chapter,
-			ScrollY: a.pager.ScrollY(),
+
+	a.openMenu(nav.NewMenu("Contents", items, selected), func(i int) {
+		// Entries without a link of their own (e.g. part headings) jump to
+		// their first linked descendant.
+		for j := i; j < len(points) && (j == i || depths[j] > depths[i]); j++ {
+			if a.book.Spine.SpineIndex(points[j].HREF) >= 0 {
+				a.gotoNavPoint(points[j])
+				return
+			}
 		}
+	})
+}
+
+// gotoNavPoint opens the chapter a table of contents entry points to and
+// scrolls to its fragment anchor.
+func (a *app) gotoNavPoint(np epub.NavPoint) {
+	chapter := a.book.Spine.SpineIndex(np.HREF)
+	if chapter < 0 {
+		return
 	}
-	b, err := a.marshalMark()
-	if err != nil {
-		logger.Error("Failed to marshal file:", err)
+
+	a.chapter = chapter
+	if a.err = a.openChapter(); a.err != nil {
 		return
 	}
-	_, err = markFile.Write(b)
-	if err != nil {
-		logger.Error("Failed to write mark file:", err)
+	a.pager.ToTop()
+	if row, ok := a.doc.Anchor(np.Fragment); ok {
+		a.pager.SetScrollY(row)
+	}
+}
+
+// parseOption returns the layout options for the current terminal size.
+func (a *app) parseOption() parse.Option {
+	width, height := termbox.Size()
+	if a.opt.MaxWidth > 0 && width > a.opt.MaxWidth {
+		width = a.opt.MaxWidth
+	}
+	return parse.Option{
+		Width:     width,
+		Theme:     a.opt.Theme,
+		Justify:   a.opt.Justify,
+		Hyphenate: a.opt.Hyphenate,
+		Language:  a.book.Language,
+		Direction: a.book.Spine.PageProgressionDirection,
+		Vertical:  a.opt.Vertical,
+		Height:    height,
+		Image:     a.opt.Image,
+	}
+}
+
+// theme returns the theme the book is shown with.
+func (a *app) theme() *parse.Theme {
+	if a.opt.Theme == nil {
+		return parse.DefaultTheme
+	}
+	return a.opt.Theme
+}
+
+// relayout renders the current chapter again after the terminal is resized,
+// keeping the same text at the top of the page.
+func (a *app) relayout() {
+	// Clearing updates the back buffer, and so termbox.Size, to the new
+	// terminal size.
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+
+	offset := a.doc.Offset(a.pager.ScrollY())
+	if a.search != nil {
+		matches, err := parse.Search(a.book, a.search, a.parseOption())
+		if err != nil {
+			logger.Warning("Failed to search:", err)
+		}
+		a.matches = matches
+	}
+	if a.err = a.openChapter(); a.err != nil {
 		return
 	}
+	a.pager.SetScrollY(a.doc.RowAt(offset))
 }
 
 // openChapter opens the current chapter and renders it within the pager.
@@ -369,11 +746,14 @@ func (a *app) openChapter() error {
 	if err != nil {
 		return err
 	}
-	doc, err := parse.ParseText(f, a.book.Manifest.Items)
+	doc, err := parse.ParseText(f, a.book.Manifest.Items, a.parseOption())
 	if err != nil {
 		return err
 	}
+	a.doc = doc
+	a.link = -1
 	a.pager.SetDoc(doc)
+	a.updateHighlights()
 
 	return nil
 }
@@ -1,11 +1,13 @@
 package app
 
 import (
+	"strings"
 	"testing"
 
 	termbox "github.com/nsf/termbox-go"
 	"github.com/stretchr/testify/mock"
 	localMock "github.com/wormggmm/goreader/mock"
+	"github.com/wormggmm/goreader/parse"
 )
 
 func TestInitNavigationKeys(t *testing.T) {
@@ -14,22 +16,21 @@ func TestInitNavigationKeys(t *testing.T) {
 
 	a.On("PageNavigator").Return(p)
 
-	keymap, chmap := initNavigationKeys(a)
+	keys, err := newKeyBindings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	verifyMethodCall := func(receiver *mock.Mock, methodName string, input any) {
 		receiver.On(methodName).Return()
 
 		switch v := input.(type) {
 		case rune:
-			if fn, ok := chmap[v]; ok {
-				fn()
-			} else {
+			if !keys.handle(a, termbox.Event{Type: termbox.EventKey, Ch: v}) {
 				t.Errorf("unhandled input character: %c", v)
 			}
 		case termbox.Key:
-			if fn, ok := keymap[v]; ok {
-				fn()
-			} else {
+			if !keys.handle(a, termbox.Event{Type: termbox.EventKey, Key: v}) {
 				t.Errorf("unhandled input key: %#x", input)
 			}
 		default:
@@ -47,6 +48,8 @@ func TestInitNavigationKeys(t *testing.T) {
 	verifyMethodCall(&p.Mock, "ScrollLeft", 'h')
 	verifyMethodCall(&p.Mock, "ScrollRight", termbox.KeyArrowRight)
 	verifyMethodCall(&p.Mock, "ScrollRight", 'l')
+	verifyMethodCall(&p.Mock, "ScrollBlocksLeft", '<')
+	verifyMethodCall(&p.Mock, "ScrollBlocksRight", '>')
 	verifyMethodCall(&p.Mock, "ToTop", 'g')
 	verifyMethodCall(&p.Mock, "ToBottom", 'G')
 
@@ -56,4 +59,37 @@ func TestInitNavigationKeys(t *testing.T) {
 	verifyMethodCall(&a.Mock, "Back", 'b')
 	verifyMethodCall(&a.Mock, "NextChapter", 'L')
 	verifyMethodCall(&a.Mock, "PrevChapter", 'H')
+	verifyMethodCall(&a.Mock, "ShowTOC", 't')
+	verifyMethodCall(&a.Mock, "SearchForward", '/')
+	verifyMethodCall(&a.Mock, "SearchBackward", '?')
+	verifyMethodCall(&a.Mock, "NextMatch", 'n')
+	verifyMethodCall(&a.Mock, "PrevMatch", 'N')
+	verifyMethodCall(&a.Mock, "VisualMode", 'v')
+	verifyMethodCall(&a.Mock, "ShowAnnotations", 'a')
+	verifyMethodCall(&a.Mock, "ShowHelp", termbox.KeyF1)
+	verifyMethodCall(&a.Mock, "NextLink", termbox.KeyTab)
+	verifyMethodCall(&a.Mock, "FollowLink", termbox.KeyEnter)
+	verifyMethodCall(&a.Mock, "LinkBack", termbox.KeyBackspace2)
+	verifyMethodCall(&a.Mock, "ShowNote", 'o')
+	verifyMethodCall(&a.Mock, "NextChapter", 'F')
+	verifyMethodCall(&a.Mock, "PrevChapter", 'B')
+}
+
+func TestBookmarkRow(t *testing.T) {
+	const text = "<p>Alice was beginning to get very tired of sitting by her sister on the bank, and of having nothing to do.</p>"
+	doc, err := parse.ParseText(strings.NewReader(text), nil, parse.Option{Width: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := Bookmark{Offset: doc.Offset(4)}
+	if row := b.row(&doc); row != 4 {
+		t.Errorf("Expected: %v, but got: %v\n", 4, row)
+	}
+
+	// Mark files written by older versions only have a scroll position.
+	b = Bookmark{ScrollY: 3}
+	if row := b.row(&doc); row != 3 {
+		t.Errorf("Expected: %v, but got: %v\n", 3, row)
+	}
 }
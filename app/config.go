@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/wormggmm/goreader/parse"
+)
+
+// Config is the user configuration, read from config.toml or config.json in
+// $XDG_CONFIG_HOME/goreader.
+type Config struct {
+	// Keys maps action names to the key sequences bound to them, replacing
+	// the action's default keys.
+	Keys map[string]KeyList `json:"keys"`
+
+	// Theme is the name of the theme to use, built-in or user-defined.
+	Theme string `json:"theme"`
+	// Themes are user-defined themes, by name. Each maps "text", "search",
+	// "annotation", "selection", "link" or an element name to a style (see
+	// parse.ParseStyle), and "inherit" to the theme it is based on, which
+	// defaults to "default".
+	Themes map[string]map[string]string `json:"themes"`
+}
+
+// LoadTheme returns the theme with the given name, or the theme named in the
+// config if name is empty. User-defined themes take precedence over built-in
+// themes of the same name.
+func (c *Config) LoadTheme(name string) (*parse.Theme, error) {
+	if name == "" {
+		name = c.Theme
+	}
+	if name == "" {
+		return parse.DefaultTheme, nil
+	}
+	return c.loadTheme(name, make(map[string]bool))
+}
+
+// loadTheme returns the named theme. seen holds the user themes being loaded,
+// to detect themes that inherit from themselves.
+func (c *Config) loadTheme(name string, seen map[string]bool) (t *parse.Theme, err error) {
+	spec, ok := c.Themes[name]
+	if !ok {
+		if builtin, ok := parse.LookupTheme(name); ok {
+			return builtin, nil
+		}
+		return nil, fmt.Errorf("unknown theme %q (built-in themes: %s)", name, strings.Join(parse.ThemeNames(), ", "))
+	}
+	if seen[name] {
+		return nil, fmt.Errorf("theme %q inherits from itself", name)
+	}
+	seen[name] = true
+
+	parent := spec["inherit"]
+	if parent == "" {
+		parent = "default"
+	}
+	var base *parse.Theme
+	if parent == name {
+		// The theme refines the built-in theme it replaces.
+		if base, ok = parse.LookupTheme(name); !ok {
+			return nil, fmt.Errorf("theme %q inherits from itself", name)
+		}
+	} else if base, err = c.loadTheme(parent, seen); err != nil {
+		return nil, err
+	}
+
+	styles := make(map[string]string, len(spec))
+	for key, value := range spec {
+		if key != "inherit" {
+			styles[key] = value
+		}
+	}
+	if t, err = parse.NewTheme(name, base, styles); err != nil {
+		return nil, fmt.Errorf("theme %q: %w", name, err)
+	}
+	return t, nil
+}
+
+// configDir returns the directory of the configuration files.
+func configDir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "goreader"), nil
+}
+
+// LoadConfig reads the user configuration. A missing configuration file is
+// not an error and gives an empty Config.
+func LoadConfig() (*Config, error) {
+	cfg := &Config{}
+	dir, err := configDir()
+	if err != nil {
+		return cfg, nil
+	}
+
+	path := filepath.Join(dir, "config.toml")
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if b, err = tomlToJSON(b); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		path = filepath.Join(dir, "config.json")
+		b, err = os.ReadFile(path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err = newKeyBindings(cfg.Keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name := range cfg.Themes {
+		if _, err = cfg.LoadTheme(name); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// tomlToJSON converts a TOML document to JSON, so that it can be decoded into
+// the same structs as a JSON config.
+func tomlToJSON(b []byte) ([]byte, error) {
+	var v map[string]any
+	if err := toml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/parse"
+	"golang.org/x/net/html/atom"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "goreader"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil || len(cfg.Keys) != 0 {
+		t.Fatalf("Expected an empty config, but got: %v, %v\n", cfg, err)
+	}
+
+	const toml = `
+themes.mine.text = "red" # a dotted key
+
+# Vim-like chapter keys
+[keys]
+next-chapter = ["]]", "<C-n>"] # comment
+"prev-chapter" = '[['
+search-forward = "#"
+top = "g=#" # '=' and '#' in strings
+`
+	if err = os.WriteFile(filepath.Join(dir, "goreader", "config.toml"), []byte(toml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]KeyList{
+		"next-chapter":   {"]]", "<C-n>"},
+		"prev-chapter":   {"[["},
+		"search-forward": {"#"},
+		"top":            {"g=#"},
+	}
+	if !reflect.DeepEqual(cfg.Keys, exp) {
+		t.Errorf("Expected: %v, but got: %v\n", exp, cfg.Keys)
+	}
+	if got := cfg.Themes["mine"]["text"]; got != "red" {
+		t.Errorf("Expected: %v, but got: %v\n", "red", got)
+	}
+
+	// config.toml takes precedence over config.json.
+	const json = `{"keys": {"quit": "Q"}}`
+	if err = os.WriteFile(filepath.Join(dir, "goreader", "config.json"), []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = LoadConfig(); err != nil || cfg.Keys["quit"] != nil {
+		t.Errorf("Expected the TOML config, but got: %v, %v\n", cfg, err)
+	}
+	if err = os.Remove(filepath.Join(dir, "goreader", "config.toml")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Keys["quit"], KeyList{"Q"}) {
+		t.Errorf("Expected: %v, but got: %v\n", KeyList{"Q"}, cfg.Keys["quit"])
+	}
+}
+
+func TestLoadTheme(t *testing.T) {
+	cfg := &Config{
+		Theme: "sepia",
+		Themes: map[string]map[string]string{
+			"sepia": {
+				"inherit": "light",
+				"text":    "#5b4636 on #f4ecd8",
+				"h1":      "red bold",
+			},
+			"light": {
+				"inherit": "light",
+				"search":  "reverse",
+			},
+			"loop": {
+				"inherit": "loop2",
+			},
+			"loop2": {
+				"inherit": "loop",
+			},
+		},
+	}
+
+	theme, err := cfg.LoadTheme("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theme.Name != "sepia" || theme.Elements[atom.H1] != (parse.Style{Fg: termbox.ColorRed | termbox.AttrBold}) {
+		t.Errorf("Expected: %v, but got: %v\n", "sepia with a red h1", theme)
+	}
+	if !theme.Colors256() {
+		t.Errorf("Expected %v to need 256 colors\n", theme.Text)
+	}
+	// Inherited from the user-defined light theme, which refines the built-in
+	// one.
+	if theme.Search != (parse.Style{Fg: termbox.AttrReverse}) {
+		t.Errorf("Expected: %v, but got: %v\n", parse.Style{Fg: termbox.AttrReverse}, theme.Search)
+	}
+	builtin, _ := parse.LookupTheme("light")
+	if theme.Elements[atom.I] != builtin.Elements[atom.I] {
+		t.Errorf("Expected: %v, but got: %v\n", builtin.Elements[atom.I], theme.Elements[atom.I])
+	}
+
+	if theme, err = cfg.LoadTheme("monochrome"); err != nil || theme.Name != "monochrome" {
+		t.Errorf("Expected: %v, but got: %v, %v\n", "monochrome", theme, err)
+	}
+	for _, name := range []string{"loop", "nope"} {
+		if _, err = cfg.LoadTheme(name); err == nil {
+			t.Errorf("Expected an error for theme %q\n", name)
+		}
+	}
+}
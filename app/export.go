@@ -0,0 +1,230 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/google/logger"
+	"github.com/wormggmm/goreader/epub"
+	"github.com/wormggmm/goreader/parse"
+)
+
+// contextLines is the number of lines of text quoted before and after an
+// exported bookmark or annotation.
+const contextLines = 2
+
+// Export holds a book's named bookmarks and annotations with the text around
+// them, for use outside of the reader.
+type Export struct {
+	Title       string               `json:"title"`
+	Path        string               `json:"path"`
+	Marks       []ExportedMark       `json:"marks"`
+	Annotations []ExportedAnnotation `json:"annotations"`
+}
+
+// ExportedMark is a named bookmark. Context holds the line at the bookmark and
+// the lines around it.
+type ExportedMark struct {
+	Name         string   `json:"name"`
+	Chapter      int      `json:"chapter"`
+	ChapterTitle string   `json:"chapter_title,omitempty"`
+	Offset       int      `json:"offset"`
+	Context      []string `json:"context"`
+}
+
+// ExportedAnnotation is an annotation. Context holds the lines of the
+// annotated text and the lines around them.
+type ExportedAnnotation struct {
+	Chapter      int       `json:"chapter"`
+	ChapterTitle string    `json:"chapter_title,omitempty"`
+	Start        int       `json:"start"`
+	End          int       `json:"end"`
+	Text         string    `json:"text"`
+	Note         string    `json:"note,omitempty"`
+	Created      time.Time `json:"created"`
+	Context      []string  `json:"context"`
+}
+
+// NewExport reads the saved marks of the book at bookPath and renders the
+// chapters they point to, to quote the text around them. Marks pointing past
+// the end of the book or of their chapter are skipped.
+func NewExport(book *epub.Rootfile, bookPath string, opt *Option) (*Export, error) {
+	m := &Mark{}
+	if err := openMarkStore(book, bookPath, opt).load(m); err != nil {
+		return nil, err
+	}
+
+	e := &Export{
+		Title:       book.Title,
+		Path:        m.Path,
+		Marks:       []ExportedMark{},
+		Annotations: []ExportedAnnotation{},
+	}
+	if e.Path == "" {
+		e.Path = bookPath
+	}
+
+	docs := make(map[int]*parse.Cellbuf)
+	chapter := func(i int) (*parse.Cellbuf, error) {
+		if doc, ok := docs[i]; ok {
+			return doc, nil
+		}
+		if i < 0 || i >= len(book.Spine.Itemrefs) {
+			return nil, fmt.Errorf("chapter %d out of range", i)
+		}
+		f, err := book.Spine.Itemrefs[i].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		doc, err := parse.ParseText(f, book.Manifest.Items, parse.Option{})
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = &doc
+		return &doc, nil
+	}
+
+	names := make([]string, 0, len(m.Marks))
+	for name := range m.Marks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b := m.Marks[name]
+		doc, err := chapter(b.Chapter)
+		if err != nil {
+			logger.Warning("Skipping bookmark:", name, err)
+			continue
+		}
+		row := b.row(doc)
+		if b.Offset < 0 || b.Offset > doc.TextLen() || row >= doc.Rows() {
+			logger.Warning("Skipping bookmark with offset out of range:", name)
+			continue
+		}
+		e.Marks = append(e.Marks, ExportedMark{
+			Name:         name,
+			Chapter:      b.Chapter,
+			ChapterTitle: book.ChapterTitle(b.Chapter),
+			Offset:       doc.Offset(row),
+			Context:      surroundingLines(doc, row, row),
+		})
+	}
+
+	for _, an := range m.Annotations {
+		doc, err := chapter(an.Chapter)
+		if err != nil {
+			logger.Warning("Skipping annotation:", an.Text, err)
+			continue
+		}
+		if an.Start < 0 || an.End <= an.Start || an.End > doc.TextLen() {
+			logger.Warning("Skipping annotation with offsets out of range:", an.Text)
+			continue
+		}
+		e.Annotations = append(e.Annotations, ExportedAnnotation{
+			Chapter:      an.Chapter,
+			ChapterTitle: book.ChapterTitle(an.Chapter),
+			Start:        an.Start,
+			End:          an.End,
+			Text:         an.Text,
+			Note:         an.Note,
+			Created:      an.Created,
+			Context:      surroundingLines(doc, doc.RowAt(an.Start), doc.RowAt(an.End-1)),
+		})
+	}
+
+	return e, nil
+}
+
+// surroundingLines returns the lines of text from first to last row of doc,
+// with up to contextLines lines of text before and after them. Blank rows are
+// skipped.
+func surroundingLines(doc *parse.Cellbuf, first, last int) []string {
+	for n := 0; n < contextLines && first > 0; {
+		if first--; doc.Line(first) != "" {
+			n++
+		}
+	}
+	for n := 0; n < contextLines && last < doc.Rows()-1; {
+		if last++; doc.Line(last) != "" {
+			n++
+		}
+	}
+
+	lines := []string{}
+	for row := first; row <= last; row++ {
+		if line := doc.Line(row); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// WriteJSON writes the export as indented JSON.
+func (e *Export) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+// WriteMarkdown writes the export as a Markdown document, with the text
+// around each mark as a block quote, followed for annotations by the text
+// annotated and the note.
+func (e *Export) WriteMarkdown(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("# %s\n", e.Title)
+
+	if len(e.Marks) > 0 {
+		ew.printf("\n## Bookmarks\n")
+	}
+	for _, m := range e.Marks {
+		ew.printf("\n### %s\n\n", m.Name)
+		ew.printf("%s\n", chapterHeading(m.Chapter, m.ChapterTitle))
+		ew.quote(m.Context)
+	}
+
+	if len(e.Annotations) > 0 {
+		ew.printf("\n## Annotations\n")
+	}
+	for _, an := range e.Annotations {
+		ew.printf("\n### %s\n", chapterHeading(an.Chapter, an.ChapterTitle))
+		ew.quote(an.Context)
+		ew.printf("\nAnnotated: %s\n", an.Text)
+		if an.Note != "" {
+			ew.printf("\n%s\n", an.Note)
+		}
+	}
+	return ew.err
+}
+
+// chapterHeading describes a chapter by its number, counted from 1 as in the
+// annotations menu, and, if known, its title.
+func chapterHeading(chapter int, title string) string {
+	if title == "" {
+		return fmt.Sprintf("Chapter %d", chapter+1)
+	}
+	return fmt.Sprintf("Chapter %d: %s", chapter+1, title)
+}
+
+// errWriter writes formatted text until the first error, which it keeps.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, a ...any) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, a...)
+	}
+}
+
+// quote writes lines as a Markdown block quote.
+func (ew *errWriter) quote(lines []string) {
+	ew.printf("\n")
+	for _, line := range lines {
+		ew.printf("> %s\n", line)
+	}
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wormggmm/goreader/epub"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	b, err := os.ReadFile("../epub/_test_files/alice.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookPath := filepath.Join(dir, "alice.epub")
+	if err = os.WriteFile(bookPath, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Marks out of the book or their chapter are skipped.
+	mark := `{"marks":{"1":{"chapter":3,"offset":500},"2":{"chapter":99},"3":{"chapter":3,"offset":999999}},` +
+		`"annotations":[{"chapter":3,"start":0,"end":5,"text":"CHAPTER I","note":"start"},{"chapter":3,"start":999990,"end":999999}]}`
+	if err = os.WriteFile(filepath.Join(dir, ".alice.epub.mark"), []byte(mark), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := epub.OpenReader(bookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	e, err := NewExport(rc.Rootfiles[0], bookPath, &Option{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Marks) != 1 || len(e.Annotations) != 1 {
+		t.Fatalf("Expected: %v, but got: %v\n", "1 mark and 1 annotation", e)
+	}
+	m := e.Marks[0]
+	if m.ChapterTitle != "ALICE'S ADVENTURES IN WONDERLAND" {
+		t.Errorf("Expected: %v, but got: %v\n", "ALICE'S ADVENTURES IN WONDERLAND", m.ChapterTitle)
+	}
+	if len(m.Context) != 2*contextLines+1 {
+		t.Errorf("Expected: %v, but got: %v\n", 2*contextLines+1, len(m.Context))
+	}
+
+	var md bytes.Buffer
+	if err = e.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	an := e.Annotations[0]
+	for _, exp := range []string{"## Bookmarks", "### 1\n", "Chapter 4: ", "> " + m.Context[0],
+		"> " + an.Context[len(an.Context)-1], "Annotated: CHAPTER I", "start"} {
+		if !strings.Contains(md.String(), exp) {
+			t.Errorf("Expected: %q in\n%s", exp, md.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = e.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got Export
+	if err = json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Marks[0].Offset != m.Offset || got.Annotations[0].Note != "start" {
+		t.Errorf("Expected: %v, but got: %v\n", e, got)
+	}
+}
@@ -0,0 +1,361 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Action is a named command that can be bound to key sequences.
+type Action struct {
+	Name string
+	Help string
+	Keys []string // default key sequences
+	run  func(a Application)
+}
+
+// actions is the registry of every action that can be bound to keys, in the
+// order they are listed in the help.
+var actions = []*Action{
+	{Name: "quit", Help: "Quit", Keys: []string{"q", "<Esc>"},
+		run: func(a Application) { a.Exit() }},
+	{Name: "help", Help: "Show key bindings", Keys: []string{"<F1>"},
+		run: func(a Application) { a.ShowHelp() }},
+	{Name: "scroll-up", Help: "Scroll up", Keys: []string{"k", "<Up>", "<WheelUp>"},
+		run: func(a Application) { a.PageNavigator().ScrollUp() }},
+	{Name: "scroll-down", Help: "Scroll down", Keys: []string{"j", "<Down>", "<WheelDown>"},
+		run: func(a Application) { a.PageNavigator().ScrollDown() }},
+	{Name: "scroll-left", Help: "Scroll left", Keys: []string{"h", "<Left>"},
+		run: func(a Application) { a.PageNavigator().ScrollLeft() }},
+	{Name: "scroll-right", Help: "Scroll right", Keys: []string{"l", "<Right>"},
+		run: func(a Application) { a.PageNavigator().ScrollRight() }},
+	{Name: "scroll-code-left", Help: "Scroll code blocks left", Keys: []string{"<lt>"},
+		run: func(a Application) { a.PageNavigator().ScrollBlocksLeft() }},
+	{Name: "scroll-code-right", Help: "Scroll code blocks right", Keys: []string{">"},
+		run: func(a Application) { a.PageNavigator().ScrollBlocksRight() }},
+	{Name: "page-up", Help: "Previous page", Keys: []string{"b"},
+		run: func(a Application) { a.Back() }},
+	{Name: "page-down", Help: "Next page", Keys: []string{"f"},
+		run: func(a Application) { a.Forward() }},
+	{Name: "prev-chapter", Help: "Previous chapter", Keys: []string{"B", "H"},
+		run: func(a Application) { a.PrevChapter() }},
+	{Name: "next-chapter", Help: "Next chapter", Keys: []string{"F", "L"},
+		run: func(a Application) { a.NextChapter() }},
+	{Name: "top", Help: "Top of chapter", Keys: []string{"g"},
+		run: func(a Application) { a.PageNavigator().ToTop() }},
+	{Name: "bottom", Help: "Bottom of chapter", Keys: []string{"G"},
+		run: func(a Application) { a.PageNavigator().ToBottom() }},
+	{Name: "toc", Help: "Table of contents", Keys: []string{"t"},
+		run: func(a Application) { a.ShowTOC() }},
+	{Name: "search-forward", Help: "Search forward", Keys: []string{"/"},
+		run: func(a Application) { a.SearchForward() }},
+	{Name: "search-backward", Help: "Search backward", Keys: []string{"?"},
+		run: func(a Application) { a.SearchBackward() }},
+	{Name: "next-match", Help: "Next match", Keys: []string{"n"},
+		run: func(a Application) { a.NextMatch() }},
+	{Name: "prev-match", Help: "Previous match", Keys: []string{"N"},
+		run: func(a Application) { a.PrevMatch() }},
+	{Name: "visual", Help: "Select text to annotate (hjkl/0/$ to extend, Enter to add a note)", Keys: []string{"v"},
+		run: func(a Application) { a.VisualMode() }},
+	{Name: "annotations", Help: "List annotations (Enter to jump, d to delete)", Keys: []string{"a"},
+		run: func(a Application) { a.ShowAnnotations() }},
+	{Name: "next-link", Help: "Focus the next link on the page", Keys: []string{"<Tab>"},
+		run: func(a Application) { a.NextLink() }},
+	{Name: "follow-link", Help: "Follow the focused link", Keys: []string{"<Enter>"},
+		run: func(a Application) { a.FollowLink() }},
+	{Name: "link-back", Help: "Go back to where the last link was followed from", Keys: []string{"<BS>"},
+		run: func(a Application) { a.LinkBack() }},
+	{Name: "show-note", Help: "Show the note of the nearest note reference (Esc to close)", Keys: []string{"o"},
+		run: func(a Application) { a.ShowNote() }},
+}
+
+// lookupAction returns the registered action with the given name.
+func lookupAction(name string) (*Action, bool) {
+	for _, action := range actions {
+		if action.Name == name {
+			return action, true
+		}
+	}
+	return nil, false
+}
+
+// KeyList is a list of key sequences. In config files it can also be given as
+// a single string.
+type KeyList []string
+
+func (l *KeyList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = KeyList{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(l))
+}
+
+// keyNames names the keys that are written as <Name> in key sequences.
+var keyNames = map[termbox.Key]string{
+	termbox.KeyArrowUp:     "Up",
+	termbox.KeyArrowDown:   "Down",
+	termbox.KeyArrowLeft:   "Left",
+	termbox.KeyArrowRight:  "Right",
+	termbox.KeyPgup:        "PgUp",
+	termbox.KeyPgdn:        "PgDn",
+	termbox.KeyHome:        "Home",
+	termbox.KeyEnd:         "End",
+	termbox.KeyInsert:      "Insert",
+	termbox.KeyDelete:      "Del",
+	termbox.KeyEsc:         "Esc",
+	termbox.KeyEnter:       "Enter",
+	termbox.KeyTab:         "Tab",
+	termbox.KeySpace:       "Space",
+	termbox.KeyBackspace:   "BS",
+	termbox.KeyBackspace2:  "BS",
+	termbox.KeyF1:          "F1",
+	termbox.KeyF2:          "F2",
+	termbox.KeyF3:          "F3",
+	termbox.KeyF4:          "F4",
+	termbox.KeyF5:          "F5",
+	termbox.KeyF6:          "F6",
+	termbox.KeyF7:          "F7",
+	termbox.KeyF8:          "F8",
+	termbox.KeyF9:          "F9",
+	termbox.KeyF10:         "F10",
+	termbox.KeyF11:         "F11",
+	termbox.KeyF12:         "F12",
+	termbox.MouseWheelUp:   "WheelUp",
+	termbox.MouseWheelDown: "WheelDown",
+}
+
+// keyToken returns the name of the key pressed in ev, as written in key
+// sequences: the character itself, or <Name> for special keys and <C-x> for
+// control characters.
+func keyToken(ev termbox.Event) (string, bool) {
+	if ev.Ch != 0 {
+		return string(ev.Ch), true
+	}
+	if name, ok := keyNames[ev.Key]; ok {
+		return "<" + name + ">", true
+	}
+	if ev.Key >= termbox.KeyCtrlA && ev.Key <= termbox.KeyCtrlZ {
+		return fmt.Sprintf("<C-%c>", 'a'+rune(ev.Key-termbox.KeyCtrlA)), true
+	}
+	return "", false
+}
+
+// ctrlAliases maps the control keys that terminals send as the same code as
+// another key to that key, which is the one termbox reports.
+var ctrlAliases = map[string]string{
+	"<C-h>": "<BS>",
+	"<C-i>": "<Tab>",
+	"<C-m>": "<Enter>",
+}
+
+// parseKeys splits a key sequence such as "gg" or "<C-f>" into the tokens
+// returned by keyToken. A '<' that does not start a key name stands for
+// itself. Control keys that cannot be told apart from other keys are
+// rejected.
+func parseKeys(seq string) ([]string, error) {
+	var tokens []string
+	for len(seq) > 0 {
+		if seq[0] == '<' {
+			if end := strings.IndexByte(seq, '>'); end > 1 {
+				token, ok := canonicalKey(seq[1:end])
+				if alias, aliased := ctrlAliases[token]; aliased {
+					return nil, fmt.Errorf("%q is sent as %s by terminals; bind %s instead", seq[:end+1], alias, alias)
+				}
+				if !ok {
+					return nil, fmt.Errorf("unknown key %q", seq[:end+1])
+				}
+				tokens = append(tokens, token)
+				seq = seq[end+1:]
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(seq)
+		if r == ' ' {
+			tokens = append(tokens, "<Space>")
+		} else {
+			tokens = append(tokens, string(r))
+		}
+		seq = seq[size:]
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty key sequence")
+	}
+	return tokens, nil
+}
+
+// canonicalKey returns the token for a key name, ignoring case.
+func canonicalKey(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "c-") && len(lower) == 3 && lower[2] >= 'a' && lower[2] <= 'z' {
+		return "<C-" + lower[2:] + ">", true
+	}
+	switch lower {
+	case "lt":
+		return "<", true
+	case "return", "cr":
+		return "<Enter>", true
+	case "pageup":
+		return "<PgUp>", true
+	case "pagedown":
+		return "<PgDn>", true
+	case "delete":
+		return "<Del>", true
+	case "backspace":
+		return "<BS>", true
+	}
+	for _, n := range keyNames {
+		if strings.ToLower(n) == lower {
+			return "<" + n + ">", true
+		}
+	}
+	return "", false
+}
+
+// keyBindings maps key sequences to actions, collecting the keys of a
+// sequence as they are pressed.
+type keyBindings struct {
+	bindings map[string]*Action // by the tokens of the sequence, space separated
+	prefixes map[string]bool
+	pending  []string
+}
+
+// newKeyBindings binds each action to its default keys, or to the keys given
+// for it in keys. Configured keys take precedence over default keys they
+// conflict with.
+func newKeyBindings(keys map[string]KeyList) (*keyBindings, error) {
+	k := &keyBindings{bindings: make(map[string]*Action)}
+	for name := range keys {
+		if _, ok := lookupAction(name); !ok {
+			return nil, fmt.Errorf("unknown action %q", name)
+		}
+	}
+
+	for _, action := range actions {
+		if _, ok := keys[action.Name]; ok {
+			continue
+		}
+		for _, seq := range action.Keys {
+			tokens, err := parseKeys(seq)
+			if err != nil {
+				return nil, err
+			}
+			k.bind(tokens, action)
+		}
+	}
+	for _, action := range actions {
+		seqs, ok := keys[action.Name]
+		if !ok {
+			continue
+		}
+		for _, seq := range seqs {
+			tokens, err := parseKeys(seq)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", action.Name, err)
+			}
+			k.bind(tokens, action)
+		}
+	}
+
+	k.prefixes = make(map[string]bool)
+	for seq := range k.bindings {
+		tokens := strings.Split(seq, " ")
+		for i := 1; i < len(tokens); i++ {
+			k.prefixes[strings.Join(tokens[:i], " ")] = true
+		}
+	}
+	return k, nil
+}
+
+// bind binds a key sequence to an action, unbinding any sequences that it is a
+// prefix of or that are a prefix of it, since they could never be completed.
+func (k *keyBindings) bind(tokens []string, action *Action) {
+	seq := strings.Join(tokens, " ")
+	for other := range k.bindings {
+		if strings.HasPrefix(other, seq+" ") || strings.HasPrefix(seq, other+" ") {
+			delete(k.bindings, other)
+		}
+	}
+	k.bindings[seq] = action
+}
+
+// handle runs the action bound to the key sequence completed by ev. It reports
+// whether ev was part of a bound sequence.
+func (k *keyBindings) handle(a Application, ev termbox.Event) bool {
+	token, ok := keyToken(ev)
+	if !ok {
+		k.pending = nil
+		return false
+	}
+
+	seq := strings.Join(append(k.pending, token), " ")
+	if !k.prefixes[seq] && k.bindings[seq] == nil && len(k.pending) > 0 {
+		// Start over with this key if it does not continue the sequence.
+		k.pending = nil
+		seq = token
+	}
+	if k.prefixes[seq] {
+		k.pending = append(k.pending, token)
+		return true
+	}
+	k.pending = nil
+	if action, ok := k.bindings[seq]; ok {
+		action.run(a)
+		return true
+	}
+	return false
+}
+
+// keysOf returns the key sequences bound to each action, in the order they
+// were configured.
+func (k *keyBindings) keysOf() map[*Action][]string {
+	keys := make(map[*Action][]string)
+	for seq, action := range k.bindings {
+		keys[action] = append(keys[action], strings.ReplaceAll(seq, " ", ""))
+	}
+	for _, seqs := range keys {
+		sort.Slice(seqs, func(i, j int) bool {
+			// Single characters before named keys.
+			if len(seqs[i]) != len(seqs[j]) {
+				return len(seqs[i]) < len(seqs[j])
+			}
+			return seqs[i] < seqs[j]
+		})
+	}
+	return keys
+}
+
+// helpLines returns a line for each action listing its keys, description and
+// name.
+func (k *keyBindings) helpLines() []string {
+	keys := k.keysOf()
+	lines := make([]string, 0, len(actions))
+	for _, action := range actions {
+		bound := strings.Join(keys[action], " / ")
+		if bound == "" {
+			bound = "(unbound)"
+		}
+		lines = append(lines, fmt.Sprintf("%-24s %s [%s]", bound, action.Help, action.Name))
+	}
+	return lines
+}
+
+// WriteHelp writes the key bindings, with the keys configured in keys in place
+// of the defaults.
+func WriteHelp(w io.Writer, keys map[string]KeyList) error {
+	k, err := newKeyBindings(keys)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\t%-24s %s\n\n", "Key", "Action")
+	for _, line := range k.helpLines() {
+		fmt.Fprintf(w, "\t%s\n", line)
+	}
+	return nil
+}
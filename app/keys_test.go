@@ -0,0 +1,97 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	termbox "github.com/nsf/termbox-go"
+	localMock "github.com/wormggmm/goreader/mock"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		seq string
+		exp []string
+	}{
+		{"j", []string{"j"}},
+		{"gg", []string{"g", "g"}},
+		{"<C-F>", []string{"<C-f>"}},
+		{"<pagedown>", []string{"<PgDn>"}},
+		{"<lt>a", []string{"<", "a"}},
+		{"<", []string{"<"}},
+		{" ", []string{"<Space>"}},
+	}
+	for _, tt := range tests {
+		got, err := parseKeys(tt.seq)
+		if err != nil {
+			t.Errorf("%q: %v", tt.seq, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Errorf("Expected: %v, but got: %v\n", tt.exp, got)
+		}
+	}
+
+	for _, seq := range []string{"", "<Nope>", "<C-h>", "x<C-I>", "<C-m>"} {
+		if _, err := parseKeys(seq); err == nil {
+			t.Errorf("Expected an error for %q\n", seq)
+		}
+	}
+}
+
+func TestKeySequences(t *testing.T) {
+	p := &localMock.MockPageNavigator{}
+	a := localMock.NewMockApplication(p)
+	a.On("PageNavigator").Return(p)
+
+	keys, err := newKeyBindings(map[string]KeyList{
+		"top":          {"gg"},
+		"next-chapter": {"<C-n>", "]]"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	press := func(seq string) bool {
+		tokens, _ := parseKeys(seq)
+		handled := false
+		for _, token := range tokens {
+			ev := termbox.Event{Type: termbox.EventKey}
+			if token == "<C-n>" {
+				ev.Key = termbox.KeyCtrlN
+			} else {
+				ev.Ch = []rune(token)[0]
+			}
+			handled = keys.handle(a, ev)
+		}
+		return handled
+	}
+
+	p.On("ToTop").Return()
+	a.On("NextChapter").Return()
+	p.On("ScrollDown").Return()
+
+	// "g" alone only starts the sequence.
+	press("g")
+	p.AssertNotCalled(t, "ToTop")
+	press("g")
+	p.AssertNumberOfCalls(t, "ToTop", 1)
+
+	// A key that does not continue the sequence is handled on its own.
+	press("]j")
+	a.AssertNotCalled(t, "NextChapter")
+	p.AssertNumberOfCalls(t, "ScrollDown", 1)
+
+	press("]]")
+	press("<C-n>")
+	a.AssertNumberOfCalls(t, "NextChapter", 2)
+
+	// The defaults of rebound actions are unbound.
+	if press("F") {
+		t.Errorf("Expected F to be unbound\n")
+	}
+
+	if _, err = newKeyBindings(map[string]KeyList{"fly": {"x"}}); err == nil {
+		t.Errorf("Expected an error for an unknown action\n")
+	}
+}
@@ -0,0 +1,350 @@
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/logger"
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/epub"
+	"github.com/wormggmm/goreader/nav"
+)
+
+// librarySort is an order the library's book list can be shown in.
+type librarySort int
+
+const (
+	sortByTitle librarySort = iota
+	sortByCreator
+	sortByProgress
+	sortByLastRead
+	librarySorts
+)
+
+func (s librarySort) String() string {
+	return [...]string{"title", "author", "progress", "last read"}[s]
+}
+
+// libraryBook is an epub file found in the library.
+type libraryBook struct {
+	path     string
+	title    string
+	creator  string
+	language string
+	chapters int
+	mark     *Mark // nil if the book has never been opened
+}
+
+// progress returns the percentage of chapters read, or -1 if the book has
+// never been opened.
+func (b *libraryBook) progress() int {
+	if b.mark == nil || b.chapters == 0 {
+		return -1
+	}
+	return (b.mark.Chapter + 1) * 100 / b.chapters
+}
+
+// lastRead returns when the book was last read, or the zero time if it has
+// never been opened.
+func lastRead(b *libraryBook) time.Time {
+	if b.mark == nil {
+		return time.Time{}
+	}
+	return b.mark.Stats.LastRead
+}
+
+// Library lists the epub files in a directory tree and opens the chosen book,
+// returning to the list when the reader quits.
+type Library struct {
+	root   string
+	opt    *Option
+	books  []*libraryBook
+	shown  []*libraryBook // books matching the filter, in sort order
+	sortBy librarySort
+	filter string
+	status string
+	err    error
+
+	menu   *nav.Menu
+	prompt *nav.Prompt
+}
+
+// NewLibrary creates a Library of the books under root.
+func NewLibrary(root string, opt *Option) *Library {
+	return &Library{root: root, opt: opt}
+}
+
+// Err returns the error that stopped the library, if any.
+func (l *Library) Err() error {
+	return l.err
+}
+
+// Run scans the library and shows the book list until the user quits.
+func (l *Library) Run() {
+	if l.err = l.scan(); l.err != nil {
+		return
+	}
+	for {
+		book := l.choose()
+		if book == nil || l.err != nil {
+			return
+		}
+		l.read(book)
+	}
+}
+
+// scan finds the epub files under the library root and reads their metadata.
+// Files that cannot be read as epubs and directories that cannot be read are
+// skipped. Only a root that cannot be read is an error.
+func (l *Library) scan() error {
+	l.books = nil
+	err := filepath.WalkDir(l.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == l.root {
+				return err
+			}
+			logger.Warning("Failed to read library entry:", path, err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() && path != l.root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".epub") {
+			return nil
+		}
+
+		rc, err := epub.OpenReader(path)
+		if err != nil {
+			logger.Warning("Failed to open epub:", path, err)
+			return nil
+		}
+		defer rc.Close()
+		rf := rc.Rootfiles[0]
+
+		b := &libraryBook{
+			path:     path,
+			title:    rf.Title,
+			creator:  rf.Creator,
+			language: rf.Language,
+			chapters: len(rf.Spine.Itemrefs),
+		}
+		if b.title == "" {
+			b.title = filepath.Base(path)
+		}
+		m := &Mark{}
+		if err := openMarkStore(rf, path, l.opt).load(m); err == nil {
+			b.mark = m
+		}
+		l.books = append(l.books, b)
+		return nil
+	})
+	l.arrange()
+	return err
+}
+
+// arrange filters and sorts the books to be shown.
+func (l *Library) arrange() {
+	filter := strings.ToLower(l.filter)
+	l.shown = l.shown[:0]
+	for _, b := range l.books {
+		text := strings.ToLower(b.title + " " + b.creator + " " + filepath.Base(b.path))
+		if strings.Contains(text, filter) {
+			l.shown = append(l.shown, b)
+		}
+	}
+
+	sort.SliceStable(l.shown, func(i, j int) bool {
+		a, b := l.shown[i], l.shown[j]
+		switch l.sortBy {
+		case sortByCreator:
+			return strings.ToLower(a.creator) < strings.ToLower(b.creator)
+		case sortByProgress:
+			return a.progress() > b.progress()
+		case sortByLastRead:
+			return lastRead(a).After(lastRead(b))
+		}
+		return strings.ToLower(a.title) < strings.ToLower(b.title)
+	})
+}
+
+// choose shows the book list and returns the book the user opens, or nil if
+// they quit.
+func (l *Library) choose() *libraryBook {
+	if l.err = termbox.Init(); l.err != nil {
+		return nil
+	}
+	defer termbox.Close()
+	termbox.SetInputMode(termbox.InputEsc)
+	events := pollEvents()
+
+	// Keep the selection from before the last book was opened.
+	if l.menu == nil {
+		l.menu = nav.NewMenu("", nil, 0)
+		l.menu.Empty = "No books"
+	}
+	l.updateMenu()
+	for {
+		termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+		if l.err = l.menu.Draw(); l.err != nil {
+			return nil
+		}
+		if l.prompt != nil {
+			if l.err = l.prompt.Draw(); l.err != nil {
+				return nil
+			}
+		}
+
+		ev := <-events
+		if ev.Type == termbox.EventResize {
+			l.updateMenu()
+			continue
+		}
+		if ev.Type != termbox.EventKey {
+			continue
+		}
+		if l.prompt != nil {
+			l.handlePromptKey(ev)
+			continue
+		}
+		switch {
+		case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+			l.menu.Down()
+		case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+			l.menu.Up()
+		case ev.Key == termbox.KeyPgdn || ev.Ch == 'f':
+			l.menu.PageDown()
+		case ev.Key == termbox.KeyPgup || ev.Ch == 'b':
+			l.menu.PageUp()
+		case ev.Ch == 'g':
+			l.menu.ToTop()
+		case ev.Ch == 'G':
+			l.menu.ToBottom()
+		case ev.Ch == 's':
+			l.sortBy = (l.sortBy + 1) % librarySorts
+			l.arrange()
+			l.updateMenu()
+		case ev.Ch == '/':
+			l.prompt = nav.NewPrompt("Filter: ")
+		case ev.Key == termbox.KeyEnter:
+			if len(l.shown) > 0 {
+				return l.shown[l.menu.Selected]
+			}
+		case ev.Key == termbox.KeyEsc || ev.Ch == 'q':
+			return nil
+		}
+	}
+}
+
+// handlePromptKey edits the filter prompt, applying the filter on Enter.
+func (l *Library) handlePromptKey(ev termbox.Event) {
+	switch {
+	case ev.Key == termbox.KeyEnter:
+		l.filter = l.prompt.String()
+		l.closePrompt()
+		l.arrange()
+		l.updateMenu()
+	case ev.Key == termbox.KeyEsc:
+		l.closePrompt()
+	case ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2:
+		if l.prompt.Empty() {
+			l.closePrompt()
+		} else {
+			l.prompt.Backspace()
+		}
+	case ev.Key == termbox.KeySpace:
+		l.prompt.Insert(' ')
+	case ev.Ch != 0:
+		l.prompt.Insert(ev.Ch)
+	}
+}
+
+func (l *Library) closePrompt() {
+	l.prompt = nil
+	termbox.HideCursor()
+}
+
+// updateMenu lays out the shown books as the menu's entries.
+func (l *Library) updateMenu() {
+	width, _ := termbox.Size()
+	// Leave room for the menu's border and padding.
+	width -= 8
+
+	title := fmt.Sprintf("Library: %d books, sorted by %s", len(l.shown), l.sortBy)
+	if l.filter != "" {
+		title += fmt.Sprintf(", matching %q", l.filter)
+	}
+	if l.status != "" {
+		title += " - " + l.status
+	}
+	l.menu.Title = title
+
+	l.menu.Items = make([]string, len(l.shown))
+	for i, b := range l.shown {
+		l.menu.Items[i] = formatBook(b, width)
+	}
+	l.menu.Select(l.menu.Selected)
+}
+
+// formatBook formats a book as a row of columns fitting width.
+func formatBook(b *libraryBook, width int) string {
+	progress := "   -"
+	if p := b.progress(); p >= 0 {
+		progress = fmt.Sprintf("%3d%%", p)
+	}
+	lang := fmt.Sprintf("%-5s", truncate(b.language, 5))
+
+	rest := width - len(progress) - len(lang) - 2
+	titleWidth := rest * 3 / 5
+	creatorWidth := rest - titleWidth - 1
+	if titleWidth < 1 || creatorWidth < 1 {
+		return truncate(b.title, width)
+	}
+
+	return fmt.Sprintf("%-*s %-*s %s %s",
+		titleWidth, truncate(b.title, titleWidth),
+		creatorWidth, truncate(b.creator, creatorWidth),
+		lang, progress)
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	if n <= 1 {
+		return string(runes[:n])
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// read opens a book in the reader and reloads its progress once it is closed.
+func (l *Library) read(b *libraryBook) {
+	l.status = ""
+	rc, err := epub.OpenReader(b.path)
+	if err != nil {
+		l.status = fmt.Sprintf("unable to open %s: %s", filepath.Base(b.path), err)
+		return
+	}
+	defer rc.Close()
+	rf := rc.Rootfiles[0]
+
+	a := NewApp(rf, b.path, l.opt)
+	a.Run()
+	if a.Err() != nil {
+		l.status = fmt.Sprintf("%s: %s", filepath.Base(b.path), a.Err())
+	}
+
+	m := &Mark{}
+	if err = openMarkStore(rf, b.path, l.opt).load(m); err == nil {
+		b.mark = m
+	}
+	l.arrange()
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLibraryArrange(t *testing.T) {
+	now := time.Now()
+	books := []*libraryBook{
+		{path: "/books/b.epub", title: "Bleak House", creator: "Dickens", chapters: 10,
+			mark: &Mark{Bookmark: Bookmark{Chapter: 4}, Stats: ReadingStats{LastRead: now.Add(-time.Hour)}}},
+		{path: "/books/a.epub", title: "Alice in Wonderland", creator: "Carroll", chapters: 12},
+		{path: "/books/emma.epub", title: "Emma", creator: "Austen", chapters: 5,
+			mark: &Mark{Bookmark: Bookmark{Chapter: 4}, Stats: ReadingStats{LastRead: now}}},
+	}
+
+	tests := []struct {
+		sortBy librarySort
+		filter string
+		exp    []string
+	}{
+		{sortByTitle, "", []string{"Alice in Wonderland", "Bleak House", "Emma"}},
+		{sortByCreator, "", []string{"Emma", "Alice in Wonderland", "Bleak House"}},
+		{sortByProgress, "", []string{"Emma", "Bleak House", "Alice in Wonderland"}},
+		{sortByLastRead, "", []string{"Emma", "Bleak House", "Alice in Wonderland"}},
+		{sortByTitle, "DICKENS", []string{"Bleak House"}},
+		{sortByTitle, "emma.epub", []string{"Emma"}},
+		{sortByTitle, "nothing", nil},
+	}
+
+	for _, tt := range tests {
+		l := &Library{books: books, sortBy: tt.sortBy, filter: tt.filter}
+		l.arrange()
+
+		var got []string
+		for _, b := range l.shown {
+			got = append(got, b.title)
+		}
+		if len(got) != len(tt.exp) {
+			t.Errorf("Expected: %v, but got: %v\n", tt.exp, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.exp[i] {
+				t.Errorf("Expected: %v, but got: %v\n", tt.exp, got)
+				break
+			}
+		}
+	}
+}
+
+func TestBookProgress(t *testing.T) {
+	b := &libraryBook{chapters: 4}
+	if p := b.progress(); p != -1 {
+		t.Errorf("Expected: %v, but got: %v\n", -1, p)
+	}
+	b.mark = &Mark{Bookmark: Bookmark{Chapter: 1}}
+	if p := b.progress(); p != 50 {
+		t.Errorf("Expected: %v, but got: %v\n", 50, p)
+	}
+}
+
+func TestLibraryScan(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("directory permissions do not apply to root")
+	}
+	root := t.TempDir()
+	b, err := os.ReadFile("../epub/_test_files/alice.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(root, "alice.epub"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// An unreadable directory is skipped.
+	locked := filepath.Join(root, "locked")
+	if err = os.Mkdir(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	l := NewLibrary(root, &Option{})
+	if err = l.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.books) != 1 {
+		t.Errorf("Expected: %v, but got: %v\n", 1, len(l.books))
+	}
+
+	// A missing root is an error.
+	l = NewLibrary(filepath.Join(root, "missing"), &Option{})
+	if err = l.scan(); err == nil {
+		t.Errorf("Expected an error for a missing library\n")
+	}
+}
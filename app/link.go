@@ -0,0 +1,156 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// NextLink moves the focus to the next link on the page, or to the first one
+// if the focused link is not on the page, wrapping around to the first link
+// after the last.
+func (a *app) NextLink() {
+	visible := a.visibleLinks()
+	if len(visible) == 0 {
+		a.pager.DrawMsg("No links on this page")
+		return
+	}
+	next := visible[0]
+	for i, l := range visible {
+		if l == a.link && i+1 < len(visible) {
+			next = visible[i+1]
+		}
+	}
+	a.link = next
+	a.updateHighlights()
+}
+
+// visibleLinks returns the indexes of the links of the current chapter that
+// start on the page.
+func (a *app) visibleLinks() []int {
+	height := a.pager.PageRows()
+	top := a.pager.ScrollY()
+	var visible []int
+	for i, l := range a.doc.Links() {
+		row := a.doc.Row(a.doc.TextCell(l.Start))
+		if row >= top && row < top+height {
+			visible = append(visible, i)
+		}
+	}
+	return visible
+}
+
+// FollowLink opens the target of the focused link, remembering the current
+// position so that LinkBack can return to it.
+func (a *app) FollowLink() {
+	links := a.doc.Links()
+	if a.link < 0 || a.link >= len(links) {
+		a.pager.DrawMsg("No link selected")
+		return
+	}
+	href := links[a.link].HREF
+	target, fragment, ok := resolveHREF(a.book.Spine.Itemrefs[a.chapter].HREF, href)
+	if !ok {
+		a.pager.DrawMsg(fmt.Sprintf("External link: %s", href))
+		return
+	}
+	chapter := a.chapter
+	if target != "" {
+		if chapter = a.book.Spine.SpineIndex(target); chapter < 0 {
+			a.pager.DrawMsg(fmt.Sprintf("Not in the book: %s", href))
+			return
+		}
+	}
+
+	a.history = append(a.history, a.bookmark())
+	if chapter != a.chapter {
+		a.chapter = chapter
+		if a.err = a.openChapter(); a.err != nil {
+			return
+		}
+	}
+	a.pager.ToTop()
+	if row, ok := a.doc.Anchor(fragment); ok && fragment != "" {
+		a.pager.SetScrollY(row)
+	}
+}
+
+// LinkBack returns to the position the last followed link was followed from.
+func (a *app) LinkBack() {
+	if len(a.history) == 0 {
+		a.pager.DrawMsg("No previous position")
+		return
+	}
+	b := a.history[len(a.history)-1]
+	a.history = a.history[:len(a.history)-1]
+	if b.Chapter != a.chapter {
+		a.chapter = b.Chapter
+		if a.err = a.openChapter(); a.err != nil {
+			return
+		}
+	}
+	a.pager.SetScrollY(b.row(&a.doc))
+}
+
+// resolveHREF resolves a link in the document at base, a path relative to
+// the package document, into the path of its target relative to the package
+// document and a fragment. The path is empty for links within the document.
+// Links with a scheme, which point outside of the book, are not resolved.
+// The path is decoded, so it is compared with manifest HREFs by
+// epub.SameHREF.
+func resolveHREF(base, href string) (target, fragment string, ok bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "", "", false
+	}
+	if u.Path != "" {
+		target = path.Join(path.Dir(base), u.Path)
+	}
+	return target, u.Fragment, true
+}
+
+// ShowNote shows the text of the note the focused link refers to, or the
+// first note reference on the page, in a box over the page.
+func (a *app) ShowNote() {
+	links := a.doc.Links()
+	ref := -1
+	if a.link >= 0 && a.link < len(links) && links[a.link].NoteRef {
+		ref = a.link
+	} else {
+		for _, i := range a.visibleLinks() {
+			if links[i].NoteRef {
+				ref = i
+				break
+			}
+		}
+	}
+	if ref < 0 {
+		a.pager.DrawMsg("No note references on this page")
+		return
+	}
+
+	base := a.book.Spine.Itemrefs[a.chapter].HREF
+	target, fragment, ok := resolveHREF(base, links[ref].HREF)
+	if target == "" {
+		target = base
+	}
+	text, found := a.notes.Text(target, fragment)
+	if !ok || !found || text == "" {
+		a.pager.DrawMsg(fmt.Sprintf("Note not found: %s", links[ref].HREF))
+		return
+	}
+	a.link = ref
+	a.updateHighlights()
+	a.pager.ShowPopup("Note", text)
+	a.popup = true
+}
+
+// handlePopupKey closes the note shown by ShowNote on Esc or q.
+func (a *app) handlePopupKey(ev termbox.Event) {
+	if ev.Key == termbox.KeyEsc || ev.Ch == 'q' {
+		a.pager.ClosePopup()
+		a.popup = false
+	}
+}
@@ -0,0 +1,27 @@
+package app
+
+import "testing"
+
+func TestResolveHREF(t *testing.T) {
+	tests := []struct {
+		base, href       string
+		target, fragment string
+		ok               bool
+	}{
+		{"text/ch1.html", "ch2.html", "text/ch2.html", "", true},
+		{"text/ch1.html", "ch2.html#sec", "text/ch2.html", "sec", true},
+		{"text/ch1.html", "#note1", "", "note1", true},
+		{"text/ch1.html", "../notes.html#n", "notes.html", "n", true},
+		{"ch1.html", "ch%202.html", "ch 2.html", "", true},
+		{"text/ch%201.xhtml", "caf%C3%A9.xhtml#n1", "text/café.xhtml", "n1", true},
+		{"ch1.html", "https://example.com/", "", "", false},
+		{"ch1.html", "mailto:a@example.com", "", "", false},
+	}
+	for _, test := range tests {
+		target, fragment, ok := resolveHREF(test.base, test.href)
+		if target != test.target || fragment != test.fragment || ok != test.ok {
+			t.Errorf("Expected: %q %q %v, but got: %q %q %v\n",
+				test.target, test.fragment, test.ok, target, fragment, ok)
+		}
+	}
+}
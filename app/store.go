@@ -0,0 +1,163 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/logger"
+	"github.com/wormggmm/goreader/epub"
+)
+
+// markStore persists a book's Mark between sessions.
+type markStore interface {
+	// load reads the saved Mark into m. It returns an error satisfying
+	// errors.Is(err, fs.ErrNotExist) if nothing has been saved yet.
+	load(m *Mark) error
+	save(m *Mark) error
+}
+
+// openMarkStore returns the store holding the marks of the book at bookPath,
+// falling back to its .mark file if the library database is unavailable.
+func openMarkStore(book *epub.Rootfile, bookPath string, opt *Option) markStore {
+	if opt.LibraryDB {
+		s, err := newLibraryStore(book, bookPath)
+		if err == nil {
+			return s
+		}
+		logger.Warning("Failed to open library database:", err)
+	}
+	return newMarkFile(bookPath)
+}
+
+// markFile stores a Mark in a hidden .mark file next to the book.
+type markFile string
+
+func newMarkFile(bookPath string) markFile {
+	dir, name := filepath.Split(bookPath)
+	return markFile(filepath.Join(dir, "."+name+".mark"))
+}
+
+func (f markFile) load(m *Mark) error {
+	return readMark(string(f), m)
+}
+
+func (f markFile) save(m *Mark) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(string(f), b, 0644)
+}
+
+// libraryStore stores Marks in a reading state database under
+// $XDG_DATA_HOME/goreader, with one file per book. This works for books in
+// read-only directories and keeps shared folders free of .mark files. Marks
+// found in a book's .mark file are imported the first time it is opened.
+type libraryStore struct {
+	path   string
+	legacy markFile
+}
+
+// newLibraryStore returns the store for a book, keyed by its identifier or,
+// for books without one, a hash of the file.
+func newLibraryStore(book *epub.Rootfile, bookPath string) (*libraryStore, error) {
+	dir, err := libraryDir()
+	if err != nil {
+		return nil, err
+	}
+	key, err := bookKey(book, bookPath)
+	if err != nil {
+		return nil, err
+	}
+	return &libraryStore{
+		path:   filepath.Join(dir, key+".json"),
+		legacy: newMarkFile(bookPath),
+	}, nil
+}
+
+func (s *libraryStore) load(m *Mark) error {
+	err := readMark(s.path, m)
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err = s.legacy.load(m); err != nil {
+		return err
+	}
+	logger.Info("import mark file:", s.legacy)
+	return s.save(m)
+}
+
+// save writes the Mark to a temporary file first, so that the existing state
+// is not lost if writing fails.
+func (s *libraryStore) save(m *Mark) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// readMark unmarshals the Mark stored at path into m.
+func readMark(path string, m *Mark) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, m); err != nil {
+		return err
+	}
+	if m.Marks == nil {
+		m.Marks = make(map[string]*Bookmark)
+	}
+	return nil
+}
+
+// libraryDir returns the directory of the reading state database.
+func libraryDir() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "goreader", "books"), nil
+}
+
+// bookKey identifies a book in the reading state database.
+func bookKey(book *epub.Rootfile, bookPath string) (string, error) {
+	h := sha256.New()
+	if book.Identifier != "" {
+		h.Write([]byte(book.Identifier))
+	} else {
+		f, err := os.Open(bookPath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err = io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wormggmm/goreader/epub"
+)
+
+func TestLibraryStoreImportsMarkFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+
+	bookPath := filepath.Join(dir, "book.epub")
+	legacy := `{"chapter":3,"scroll_y":42,"marks":{"1":{"chapter":5,"scroll_y":7}}}`
+	if err := os.WriteFile(filepath.Join(dir, ".book.epub.mark"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	book := &epub.Rootfile{}
+	book.Identifier = "urn:uuid:1234"
+	s, err := newLibraryStore(book, bookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Mark{}
+	if err = s.load(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Chapter != 3 || m.ScrollY != 42 {
+		t.Errorf("Expected: %v, but got: %v\n", Bookmark{Chapter: 3, ScrollY: 42}, m.Bookmark)
+	}
+	if b := m.Marks["1"]; b == nil || b.Chapter != 5 {
+		t.Errorf("Expected: %v, but got: %v\n", Bookmark{Chapter: 5, ScrollY: 7}, b)
+	}
+
+	// The imported marks are now read from the library, not the .mark file.
+	if err = os.Remove(filepath.Join(dir, ".book.epub.mark")); err != nil {
+		t.Fatal(err)
+	}
+	m.Chapter = 4
+	if err = s.save(m); err != nil {
+		t.Fatal(err)
+	}
+	m = &Mark{}
+	if err = s.load(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Chapter != 4 {
+		t.Errorf("Expected: %v, but got: %v\n", 4, m.Chapter)
+	}
+}
@@ -0,0 +1,540 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultSpineStep is the CFI step of the spine element in a package document
+// laid out as metadata, manifest, spine.
+const defaultSpineStep = 6
+
+var (
+	// ErrBadCFI occurs when a CFI string cannot be parsed.
+	ErrBadCFI = errors.New("epub: malformed cfi")
+
+	// ErrCFINotFound occurs when a CFI refers to a spine item or document
+	// node that does not exist.
+	ErrCFINotFound = errors.New("epub: cfi references non-existent location")
+)
+
+// CFILocation is a position in a book resolved from an EPUB Canonical
+// Fragment Identifier.
+type CFILocation struct {
+	// Spine is the index of the referenced item in Spine.Itemrefs.
+	Spine   int
+	Itemref *Itemref
+
+	// ElementID is the id of the innermost element on the CFI's path that
+	// has one, if any.
+	ElementID string
+
+	// TextOffset is the number of non-whitespace characters of text, outside
+	// of <style> elements, that precede the location in the document.
+	TextOffset int
+}
+
+// CFI returns the Canonical Fragment Identifier of the position in spine item
+// i that is preceded by textOffset non-whitespace characters of text (see
+// CFILocation.TextOffset). Offsets past the end of the text refer to the end
+// of the last text in the document.
+func (p *Package) CFI(i int, textOffset int) (string, error) {
+	if i < 0 || i >= len(p.Spine.Itemrefs) {
+		return "", ErrCFINotFound
+	}
+	root, err := p.Spine.Itemrefs[i].cfiTree()
+	if err != nil {
+		return "", err
+	}
+
+	path, ok := root.find(textOffset)
+	if !ok {
+		return "", ErrCFINotFound
+	}
+	return fmt.Sprintf("epubcfi(/%d/%d!%s)", p.Spine.cfiStep(), (i+1)*2, path), nil
+}
+
+// ResolveCFI finds the spine item and position referred to by a Canonical
+// Fragment Identifier. Only the start of range CFIs is resolved, and spatial
+// and temporal offsets are ignored.
+func (p *Package) ResolveCFI(cfi string) (CFILocation, error) {
+	var loc CFILocation
+
+	steps, err := parseCFI(cfi)
+	if err != nil {
+		return loc, err
+	}
+
+	// The first two steps lead to the spine and the itemref, the rest into
+	// the item's content document.
+	indirect := -1
+	for j, s := range steps {
+		if s.indirect {
+			indirect = j
+			break
+		}
+	}
+	if indirect != 1 {
+		return loc, ErrBadCFI
+	}
+	if steps[1].index%2 != 0 || steps[1].index < 2 {
+		return loc, ErrCFINotFound
+	}
+	loc.Spine = steps[1].index/2 - 1
+	if loc.Spine >= len(p.Spine.Itemrefs) {
+		return loc, ErrCFINotFound
+	}
+	loc.Itemref = &p.Spine.Itemrefs[loc.Spine]
+
+	root, err := loc.Itemref.cfiTree()
+	if err != nil {
+		return loc, err
+	}
+	loc.ElementID, loc.TextOffset, err = root.resolve(steps[2:])
+	return loc, err
+}
+
+// cfiStep is a single step of a CFI path.
+type cfiStep struct {
+	index    int
+	id       string // id assertion, if any
+	offset   int    // character offset, or -1
+	indirect bool   // the step is followed by '!'
+}
+
+// parseCFI splits a CFI string into its steps. For range CFIs the common
+// parent path and the start path are joined.
+func parseCFI(cfi string) ([]cfiStep, error) {
+	if !strings.HasPrefix(cfi, "epubcfi(") || !strings.HasSuffix(cfi, ")") {
+		return nil, ErrBadCFI
+	}
+	cfi = cfi[len("epubcfi(") : len(cfi)-1]
+	if parts := splitRange(cfi); len(parts) == 3 {
+		cfi = parts[0] + parts[1]
+	}
+
+	var steps []cfiStep
+	for len(cfi) > 0 {
+		switch cfi[0] {
+		case '!':
+			if len(steps) == 0 {
+				return nil, ErrBadCFI
+			}
+			steps[len(steps)-1].indirect = true
+			cfi = cfi[1:]
+			continue
+		case '/':
+		default:
+			return nil, ErrBadCFI
+		}
+
+		step := cfiStep{offset: -1}
+		n := 1
+		for n < len(cfi) && cfi[n] >= '0' && cfi[n] <= '9' {
+			n++
+		}
+		index, err := strconv.Atoi(cfi[1:n])
+		if err != nil {
+			return nil, ErrBadCFI
+		}
+		step.index = index
+		cfi = cfi[n:]
+
+		if strings.HasPrefix(cfi, "[") {
+			end := strings.IndexByte(cfi, ']')
+			if end < 0 {
+				return nil, ErrBadCFI
+			}
+			step.id = cfi[1:end]
+			cfi = cfi[end+1:]
+		}
+
+		if strings.HasPrefix(cfi, ":") {
+			n = 1
+			for n < len(cfi) && cfi[n] >= '0' && cfi[n] <= '9' {
+				n++
+			}
+			if step.offset, err = strconv.Atoi(cfi[1:n]); err != nil {
+				return nil, ErrBadCFI
+			}
+			// Anything after a character offset (text location assertions,
+			// side bias) does not affect the position.
+			cfi = ""
+		} else if strings.HasPrefix(cfi, "~") || strings.HasPrefix(cfi, "@") {
+			// Temporal and spatial offsets are not supported.
+			cfi = ""
+		}
+
+		steps = append(steps, step)
+	}
+
+	if len(steps) == 0 {
+		return nil, ErrBadCFI
+	}
+	return steps, nil
+}
+
+// splitRange splits a range CFI into its parent, start and end paths. Commas
+// that are escaped or part of an assertion do not separate paths.
+func splitRange(cfi string) []string {
+	var parts []string
+	start, inAssertion := 0, false
+	for i := 0; i < len(cfi); i++ {
+		switch cfi[i] {
+		case '^':
+			i++
+		case '[':
+			inAssertion = true
+		case ']':
+			inAssertion = false
+		case ',':
+			if !inAssertion {
+				parts = append(parts, cfi[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, cfi[start:])
+}
+
+// cfiNode is an element or a text chunk in a content document. Text separated
+// only by comments or processing instructions forms a single chunk.
+type cfiNode struct {
+	name     string // local element name, empty for text
+	id       string
+	text     string
+	children []*cfiNode
+
+	// start is the text offset of the node, and length the number of
+	// non-whitespace characters it contains.
+	start, length int
+}
+
+// cfiTree reads the item's content document into a tree.
+func (itemref *Itemref) cfiTree() (*cfiNode, error) {
+	if itemref.Item == nil {
+		return nil, ErrBadItemref
+	}
+	f, err := itemref.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := xml.NewDecoder(f)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var root *cfiNode
+	var stack []*cfiNode
+Tokens:
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &cfiNode{name: t.Name.Local}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "id" && attr.Name.Space == "" {
+					n.id = attr.Value
+				}
+			}
+			if len(stack) == 0 {
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 && root != nil {
+				break Tokens
+			}
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			parent := stack[len(stack)-1]
+			if last := len(parent.children) - 1; last >= 0 && parent.children[last].name == "" {
+				parent.children[last].text += string(t)
+			} else {
+				parent.children = append(parent.children, &cfiNode{text: string(t)})
+			}
+		}
+	}
+	if root == nil {
+		return nil, ErrCFINotFound
+	}
+
+	root.measure(0, false)
+	return root, nil
+}
+
+// measure sets the text offsets of n and its descendants, starting at start.
+// Text within <style> elements is not counted, matching what is displayed.
+func (n *cfiNode) measure(start int, hidden bool) int {
+	n.start = start
+	if n.name == "" {
+		if !hidden {
+			n.length = countText([]rune(n.text))
+		}
+		return start + n.length
+	}
+
+	hidden = hidden || n.name == "style"
+	offset := start
+	for _, c := range n.children {
+		offset = c.measure(offset, hidden)
+	}
+	n.length = offset - start
+	return offset
+}
+
+// find returns the CFI path, relative to n, of the text position preceded by
+// textOffset characters. The second result is false when n has no text.
+func (n *cfiNode) find(textOffset int) (string, bool) {
+	var last *cfiNode
+	var lastPath string
+	var walk func(n *cfiNode, path string) (string, bool)
+	walk = func(n *cfiNode, path string) (string, bool) {
+		elems := 0
+		for _, c := range n.children {
+			if c.name != "" {
+				elems++
+				if p, ok := walk(c, path+stepString(elems*2, c.id)); ok {
+					return p, true
+				}
+				continue
+			}
+			if c.length == 0 {
+				continue
+			}
+			step := path + stepString(elems*2+1, "")
+			if textOffset < c.start+c.length {
+				return fmt.Sprintf("%s:%d", step, c.charOffset(textOffset-c.start)), true
+			}
+			last, lastPath = c, step
+		}
+		return "", false
+	}
+
+	if p, ok := walk(n, ""); ok {
+		return p, true
+	}
+	if last == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%d", lastPath, utf16Len([]rune(last.text))), true
+}
+
+// resolve follows a CFI path relative to n and returns the id of the
+// innermost element with one and the text offset of the position.
+func (n *cfiNode) resolve(steps []cfiStep) (string, int, error) {
+	doc := n
+	id := n.id
+	for _, s := range steps {
+		if s.index < 1 {
+			return "", 0, ErrCFINotFound
+		}
+
+		// Even steps are the element children, odd steps are the (possibly
+		// empty) text chunks before, between and after them.
+		var target *cfiNode
+		elems := 0
+		for _, c := range n.children {
+			if c.name != "" {
+				elems++
+				if elems*2 == s.index {
+					target = c
+					break
+				}
+			} else if elems*2+1 == s.index {
+				target = c
+				break
+			}
+		}
+
+		if target == nil && s.index%2 == 1 && s.index <= elems*2+1 {
+			// An empty chunk before the element at the next step, or at
+			// the end of n.
+			return id, n.childOffset(s.index + 1), nil
+		}
+		if target == nil {
+			return "", 0, ErrCFINotFound
+		}
+
+		if s.id != "" && s.id != target.id {
+			// The id assertion takes precedence over the path.
+			if byID := doc.byID(s.id); byID != nil {
+				target = byID
+			}
+		}
+		n = target
+		if n.id != "" {
+			id = n.id
+		}
+		if n.name == "" {
+			offset := 0
+			if s.offset > 0 {
+				offset = countText(prefixUTF16([]rune(n.text), s.offset))
+			}
+			return id, n.start + offset, nil
+		}
+	}
+	return id, n.start, nil
+}
+
+// childOffset returns the text offset of the element child at the given even
+// step, or of the end of n if there is none.
+func (n *cfiNode) childOffset(index int) int {
+	elems := 0
+	for _, c := range n.children {
+		if c.name != "" {
+			elems++
+			if elems*2 == index {
+				return c.start
+			}
+		}
+	}
+	return n.start + n.length
+}
+
+// byID returns the element with the given id within n.
+func (n *cfiNode) byID(id string) *cfiNode {
+	if n.id == id {
+		return n
+	}
+	for _, c := range n.children {
+		if found := c.byID(id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// charOffset returns the CFI character offset, in UTF-16 code units, of the
+// non-whitespace character at index i of a text chunk.
+func (n *cfiNode) charOffset(i int) int {
+	runes := []rune(n.text)
+	count := 0
+	for j, r := range runes {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		if count == i {
+			return utf16Len(runes[:j])
+		}
+		count++
+	}
+	return utf16Len(runes)
+}
+
+// stepString formats a single CFI step with an optional id assertion.
+func stepString(index int, id string) string {
+	if id == "" {
+		return "/" + strconv.Itoa(index)
+	}
+	return fmt.Sprintf("/%d[%s]", index, escapeCFI(id))
+}
+
+// escapeCFI escapes the characters that have a special meaning in CFIs.
+func escapeCFI(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("^[](),;=", r) {
+			b.WriteRune('^')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// countText returns the number of non-whitespace characters in runes.
+func countText(runes []rune) int {
+	count := 0
+	for _, r := range runes {
+		if !unicode.IsSpace(r) {
+			count++
+		}
+	}
+	return count
+}
+
+// utf16Len returns the number of UTF-16 code units needed to encode runes.
+func utf16Len(runes []rune) int {
+	n := 0
+	for _, r := range runes {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// prefixUTF16 returns the longest prefix of runes that is at most n UTF-16
+// code units long.
+func prefixUTF16(runes []rune, n int) []rune {
+	units := 0
+	for i, r := range runes {
+		w := 1
+		if r >= 0x10000 {
+			w = 2
+		}
+		if units+w > n {
+			return runes[:i]
+		}
+		units += w
+	}
+	return runes
+}
+
+// cfiStep returns the CFI step of the spine element within the package
+// document.
+func (s *Spine) cfiStep() int {
+	if s.step == 0 {
+		return defaultSpineStep
+	}
+	return s.step
+}
+
+// spineStep finds the CFI step of the spine element among the children of a
+// package document's root element.
+func spineStep(b []byte) int {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	depth, elems := 0, 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return defaultSpineStep
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 {
+				elems++
+				if t.Name.Local == "spine" {
+					return elems * 2
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package epub
+
+import (
+	"testing"
+)
+
+func TestCFI(t *testing.T) {
+	r, err := OpenReader("_test_files/alice.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	book := r.Rootfiles[0]
+
+	testCases := []struct {
+		spine      int
+		textOffset int
+		expCFI     string
+	}{
+		// The <title> in <head> is the first text of the document.
+		{2, 0, "epubcfi(/6/6!/2/8/1:0)"},
+		// Offsets skip whitespace: the 11th character is the "G" of
+		// "The Project Gutenberg".
+		{2, 10, "epubcfi(/6/6!/2/8/1:12)"},
+		// The "I" of "LICE was beginning", after the chapter heading and the
+		// drop cap image.
+		{2, 100, "epubcfi(/6/6!/4/8/1:1)"},
+		// Elements with an id get an id assertion.
+		{1, 767, "epubcfi(/6/4!/4/10[pgepubid00000]/1:0)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run("Generate", func(t *testing.T) {
+			cfi, err := book.CFI(tc.spine, tc.textOffset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfi != tc.expCFI {
+				t.Errorf(expFormat, tc.expCFI, cfi)
+			}
+		})
+		t.Run("Resolve", func(t *testing.T) {
+			loc, err := book.ResolveCFI(tc.expCFI)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loc.Spine != tc.spine {
+				t.Errorf(expFormat, tc.spine, loc.Spine)
+			}
+			if loc.Itemref != &book.Spine.Itemrefs[tc.spine] {
+				t.Errorf(expFormat, &book.Spine.Itemrefs[tc.spine], loc.Itemref)
+			}
+			if loc.TextOffset != tc.textOffset {
+				t.Errorf(expFormat, tc.textOffset, loc.TextOffset)
+			}
+		})
+	}
+
+	// Every offset of a chapter survives a round trip.
+	for offset := 0; offset < 2000; offset += 37 {
+		cfi, err := book.CFI(3, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loc, err := book.ResolveCFI(cfi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loc.Spine != 3 || loc.TextOffset != offset {
+			t.Errorf(expFormat, offset, loc.TextOffset)
+		}
+	}
+}
+
+func TestResolveCFI(t *testing.T) {
+	r, err := OpenReader("_test_files/alice.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	book := r.Rootfiles[0]
+
+	testCases := []struct {
+		cfi           string
+		expTextOffset int
+		expElementID  string
+	}{
+		// An element without a character offset resolves to its start.
+		{"epubcfi(/6/6!/4/8)", 99, ""},
+		// Range CFIs resolve to their start.
+		{"epubcfi(/6/6!/4/8,/1:1,/1:5)", 100, ""},
+		// Text location assertions and side bias are ignored.
+		{"epubcfi(/6/6!/4/8/1:1[LI^,CE];s=b)", 100, ""},
+		{"epubcfi(/6/6!/4/8,/1:1[LI,CE],/1:5)", 100, ""},
+		// The id of the innermost element with one is reported.
+		{"epubcfi(/6/4!/4/10[pgepubid00000]/1:6)", 773, "pgepubid00000"},
+		// An id assertion takes precedence over a wrong path.
+		{"epubcfi(/6/4!/4/12[pgepubid00000])", 767, "pgepubid00000"},
+		// An empty text chunk resolves to the element that follows it.
+		{"epubcfi(/6/6!/4/7)", 99, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.cfi, func(t *testing.T) {
+			loc, err := book.ResolveCFI(tc.cfi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loc.TextOffset != tc.expTextOffset {
+				t.Errorf(expFormat, tc.expTextOffset, loc.TextOffset)
+			}
+			if loc.ElementID != tc.expElementID {
+				t.Errorf(expFormat, tc.expElementID, loc.ElementID)
+			}
+		})
+	}
+
+	for _, cfi := range []string{
+		"",
+		"/6/6!/4/8",
+		"epubcfi(/6/6/4/8)",
+		"epubcfi(/6/x!/4)",
+	} {
+		if _, err := book.ResolveCFI(cfi); err != ErrBadCFI {
+			t.Errorf(expFormat, ErrBadCFI, err)
+		}
+	}
+	for _, cfi := range []string{
+		"epubcfi(/6/100!/4)",
+		"epubcfi(/6/6!/4/200)",
+	} {
+		if _, err := book.ResolveCFI(cfi); err != ErrCFINotFound {
+			t.Errorf(expFormat, ErrCFINotFound, err)
+		}
+	}
+}
@@ -35,6 +35,10 @@ var (
 	// ErrBadManifest occurs when a manifest in content.opf references an item
 	// that does not exist in the zip.
 	ErrBadManifest = errors.New("epub: manifest references non-existent item")
+
+	// ErrBadTOC occurs when the spine's toc attribute references an item that
+	// does not exist in the manifest.
+	ErrBadTOC = errors.New("epub: spine references non-existent toc item")
 )
 
 // Reader represents a readable epub file.
@@ -53,6 +57,10 @@ type ReadCloser struct {
 type Rootfile struct {
 	FullPath string `xml:"full-path,attr"`
 	Package
+
+	// TOCErr is the error that kept the table of contents from being read,
+	// in which case TOC is empty.
+	TOCErr error `xml:"-"`
 }
 
 // Container serves as a directory of Rootfiles.
@@ -65,13 +73,14 @@ type Package struct {
 	Metadata
 	Manifest
 	Spine
+	TOC TOC `xml:"-"`
 }
 
 // Metadata contains publishing information about the epub.
 type Metadata struct {
 	Title       string `xml:"metadata>title"`
 	Language    string `xml:"metadata>language"`
-	Identifier  string `xml:"metadata>idenifier"`
+	Identifier  string `xml:"metadata>identifier"`
 	Creator     string `xml:"metadata>creator"`
 	Contributor string `xml:"metadata>contributor"`
 	Publisher   string `xml:"metadata>publisher"`
@@ -96,15 +105,31 @@ type Manifest struct {
 
 // Item represents a file stored in the epub.
 type Item struct {
-	ID        string `xml:"id,attr"`
-	HREF      string `xml:"href,attr"`
-	MediaType string `xml:"media-type,attr"`
-	f         *zip.File
+	ID         string `xml:"id,attr"`
+	HREF       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+	f          *zip.File
 }
 
 // Spine defines the reading order of the epub documents.
 type Spine struct {
 	Itemrefs []Itemref `xml:"spine>itemref"`
+	// TOCIDREF is the ID of the NCX item, from the spine's toc attribute.
+	TOCIDREF string `xml:"-"`
+	// PageProgressionDirection is "rtl" for books read from right to left,
+	// such as books in Arabic or Hebrew.
+	PageProgressionDirection string `xml:"-"`
+	step                     int    // CFI step of the spine within the package document
+}
+
+// spineAttrs holds the attributes of a package document's spine, which
+// can't be unmarshaled into the Spine embedded in Package.
+type spineAttrs struct {
+	Spine struct {
+		TOC                      string `xml:"toc,attr"`
+		PageProgressionDirection string `xml:"page-progression-direction,attr"`
+	} `xml:"spine"`
 }
 
 // Itemref points to an Item.
@@ -177,6 +202,7 @@ func (r *Reader) init(z *zip.Reader) error {
 	if err != nil {
 		return err
 	}
+	r.setTOCs()
 
 	return nil
 }
@@ -228,6 +254,14 @@ func (r *Reader) setPackages() error {
 		if err != nil {
 			return err
 		}
+		var attrs spineAttrs
+		err = xml.Unmarshal(b.Bytes(), &attrs)
+		if err != nil {
+			return err
+		}
+		rf.Spine.TOCIDREF = attrs.Spine.TOC
+		rf.Spine.PageProgressionDirection = attrs.Spine.PageProgressionDirection
+		rf.Spine.step = spineStep(b.Bytes())
 	}
 
 	return nil
@@ -244,7 +278,10 @@ func (r *Reader) setItems() error {
 			itemMap[item.ID] = item
 
 			abs := path.Join(path.Dir(rf.FullPath), item.HREF)
-			item.f = r.files[abs]
+			if item.f = r.files[abs]; item.f == nil {
+				// Zip entries are named without percent-encoding.
+				item.f = r.files[unescapeHREF(abs)]
+			}
 		}
 
 		for i := range rf.Spine.Itemrefs {
@@ -52,6 +52,7 @@ func (ct *containerTest) TestContainer() {
 		tt.TestMetadata()
 		tt.TestSpine()
 		tt.TestManifest()
+		tt.TestTOC()
 	})
 }
 
@@ -67,6 +68,11 @@ func (ct *containerTest) TestMetadata() {
 	if meta.Creator != exp {
 		ct.Errorf(expFormat, exp, meta.Creator)
 	}
+
+	exp = "http://www.gutenberg.org/ebooks/28885"
+	if meta.Identifier != exp {
+		ct.Errorf(expFormat, exp, meta.Identifier)
+	}
 }
 
 func (ct *containerTest) TestSpine() {
@@ -128,3 +134,83 @@ func (ct *containerTest) TestManifest() {
 		})
 	}
 }
+
+func (ct *containerTest) TestTOC() {
+	toc := ct.c.Rootfiles[0].TOC
+
+	if len(toc.NavPoints) != 3 {
+		ct.Fatalf(expFormat, 3, len(toc.NavPoints))
+	}
+
+	testCases := []struct {
+		np           NavPoint
+		expTitle     string
+		expHREF      string
+		expFragment  string
+		expPlayOrder int
+		expChildren  int
+	}{
+		{
+			toc.NavPoints[0],
+			"ALICE'S ADVENTURES IN WONDERLAND",
+			"@public@vhost@g@gutenberg@html@files@28885@28885-h@28885-h-0.htm.html",
+			"pgepubid00000",
+			2,
+			0,
+		},
+		{
+			toc.NavPoints[2],
+			"LIST OF THE PLATES",
+			"@public@vhost@g@gutenberg@html@files@28885@28885-h@28885-h-0.htm.html",
+			"pgepubid00002",
+			12,
+			2,
+		},
+		{
+			toc.NavPoints[2].Children[1],
+			"Transcriber's Note:",
+			"@public@vhost@g@gutenberg@html@files@28885@28885-h@28885-h-12.htm.html",
+			"pgepubid00004",
+			173,
+			0,
+		},
+	}
+
+	for _, tc := range testCases {
+		ct.Run("NavPoint", func(t *testing.T) {
+			if tc.np.Title != tc.expTitle {
+				t.Errorf(expFormat, tc.expTitle, tc.np.Title)
+			}
+			if tc.np.HREF != tc.expHREF {
+				t.Errorf(expFormat, tc.expHREF, tc.np.HREF)
+			}
+			if tc.np.Fragment != tc.expFragment {
+				t.Errorf(expFormat, tc.expFragment, tc.np.Fragment)
+			}
+			if tc.np.PlayOrder != tc.expPlayOrder {
+				t.Errorf(expFormat, tc.expPlayOrder, tc.np.PlayOrder)
+			}
+			if len(tc.np.Children) != tc.expChildren {
+				t.Errorf(expFormat, tc.expChildren, len(tc.np.Children))
+			}
+		})
+	}
+	chapterTitles := []struct {
+		spine    int
+		expTitle string
+	}{
+		{0, ""},
+		{1, "ALICE'S ADVENTURES IN WONDERLAND"},
+		{5, "ALICE'S ADVENTURES IN WONDERLAND"},
+		{13, "THE END"},
+	}
+
+	for _, tc := range chapterTitles {
+		ct.Run("ChapterTitle", func(t *testing.T) {
+			title := ct.c.Rootfiles[0].ChapterTitle(tc.spine)
+			if title != tc.expTitle {
+				t.Errorf(expFormat, tc.expTitle, title)
+			}
+		})
+	}
+}
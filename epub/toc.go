@@ -0,0 +1,354 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// opsNamespace is the namespace of the epub:type attribute used by EPUB3
+// navigation documents.
+const opsNamespace = "http://www.idpf.org/2007/ops"
+
+// TOC is a book's table of contents, read from either the EPUB3 navigation
+// document or the EPUB2 NCX file.
+type TOC struct {
+	Title     string
+	NavPoints []NavPoint
+}
+
+// NavPoint is a single, possibly nested, table of contents entry.
+type NavPoint struct {
+	Title string
+
+	// HREF is the path of the target document relative to the package
+	// document, matching the HREF of an Item in the Manifest.
+	HREF string
+
+	// Fragment is the anchor within the target document, without the leading
+	// '#'. It is empty when the entry points at the start of the document.
+	Fragment string
+
+	// PlayOrder is the reading order of the entry. It is taken from the NCX
+	// when present and is otherwise the entry's position in the document.
+	PlayOrder int
+
+	Children []NavPoint
+}
+
+// SpineIndex returns the index of the first Itemref whose Item matches href, or
+// -1 if href is not part of the spine.
+func (s *Spine) SpineIndex(href string) int {
+	for i, itemref := range s.Itemrefs {
+		if itemref.Item != nil && SameHREF(itemref.HREF, href) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SameHREF reports whether two paths relative to the package document name
+// the same file, whether or not their characters are percent-encoded, as
+// manifests and links may write them either way.
+func SameHREF(a, b string) bool {
+	return a == b || unescapeHREF(a) == unescapeHREF(b)
+}
+
+// unescapeHREF decodes the percent-encoded characters of a path, which is
+// kept as it is if it is not validly encoded.
+func unescapeHREF(href string) string {
+	if s, err := url.PathUnescape(href); err == nil {
+		return s
+	}
+	return href
+}
+
+// ChapterTitle returns the title of the first table of contents entry pointing
+// at the spine item at index i. Items without an entry of their own take the
+// title of the closest entry before them, and items before the first entry
+// have no title.
+func (p *Package) ChapterTitle(i int) string {
+	points, _ := p.TOC.Flatten()
+	title, best := "", -1
+	for _, np := range points {
+		if idx := p.Spine.SpineIndex(np.HREF); idx <= i && idx > best {
+			title, best = np.Title, idx
+		}
+	}
+	return title
+}
+
+// Flatten returns every entry in the table of contents in document order,
+// paired with its nesting depth.
+func (t *TOC) Flatten() ([]NavPoint, []int) {
+	var points []NavPoint
+	var depths []int
+	var walk func(nps []NavPoint, depth int)
+	walk = func(nps []NavPoint, depth int) {
+		for _, np := range nps {
+			points = append(points, np)
+			depths = append(depths, depth)
+			walk(np.Children, depth+1)
+		}
+	}
+	walk(t.NavPoints, 0)
+	return points, depths
+}
+
+// setTOCs reads the table of contents of each rootfile. EPUB3 navigation
+// documents take precedence over the NCX referenced by the spine. Books
+// without either, or whose table of contents can't be read, are left with an
+// empty TOC and the error in TOCErr, since they can be read without one.
+func (r *Reader) setTOCs() {
+	for _, rf := range r.Container.Rootfiles {
+		if err := rf.setTOC(); err != nil {
+			rf.TOC, rf.TOCErr = TOC{}, err
+		}
+	}
+}
+
+// setTOC reads the table of contents of a package.
+func (p *Package) setTOC() error {
+	item, nav, err := p.tocItem()
+	if err != nil {
+		return err
+	}
+	if item == nil || item.f == nil {
+		return nil
+	}
+
+	f, err := item.Open()
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	_, err = io.Copy(&b, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if nav {
+		err = p.TOC.unmarshalNav(b.Bytes())
+	} else {
+		err = p.TOC.unmarshalNCX(b.Bytes())
+	}
+	if err != nil {
+		return err
+	}
+	p.TOC.resolve(path.Dir(item.HREF))
+	return nil
+}
+
+// tocItem returns the manifest item holding the table of contents, and
+// whether it is an EPUB3 navigation document rather than an NCX.
+func (p *Package) tocItem() (*Item, bool, error) {
+	for i := range p.Manifest.Items {
+		item := &p.Manifest.Items[i]
+		for _, prop := range strings.Fields(item.Properties) {
+			if prop == "nav" {
+				return item, true, nil
+			}
+		}
+	}
+
+	if p.Spine.TOCIDREF == "" {
+		return nil, false, nil
+	}
+	for i := range p.Manifest.Items {
+		item := &p.Manifest.Items[i]
+		if item.ID == p.Spine.TOCIDREF {
+			return item, false, nil
+		}
+	}
+
+	return nil, false, ErrBadTOC
+}
+
+// resolve rewrites each entry's link, which is relative to the table of
+// contents document in dir, into an HREF relative to the package document and
+// a fragment.
+func (t *TOC) resolve(dir string) {
+	var walk func(nps []NavPoint)
+	walk = func(nps []NavPoint) {
+		for i := range nps {
+			np := &nps[i]
+			href, frag, _ := strings.Cut(np.HREF, "#")
+			if href != "" {
+				href = path.Join(dir, href)
+			}
+			np.HREF, np.Fragment = href, frag
+			walk(np.Children)
+		}
+	}
+	walk(t.NavPoints)
+}
+
+// ncx represents an EPUB2 toc.ncx file.
+type ncx struct {
+	Title     string        `xml:"docTitle>text"`
+	NavPoints []ncxNavPoint `xml:"navMap>navPoint"`
+}
+
+type ncxNavPoint struct {
+	PlayOrder int           `xml:"playOrder,attr"`
+	Label     string        `xml:"navLabel>text"`
+	Content   ncxContent    `xml:"content"`
+	NavPoints []ncxNavPoint `xml:"navPoint"`
+}
+
+type ncxContent struct {
+	Src string `xml:"src,attr"`
+}
+
+func (t *TOC) unmarshalNCX(b []byte) error {
+	var n ncx
+	if err := xml.Unmarshal(b, &n); err != nil {
+		return err
+	}
+
+	var convert func(points []ncxNavPoint) []NavPoint
+	convert = func(points []ncxNavPoint) []NavPoint {
+		var nps []NavPoint
+		for _, p := range points {
+			nps = append(nps, NavPoint{
+				Title:     collapseSpace(p.Label),
+				HREF:      p.Content.Src,
+				PlayOrder: p.PlayOrder,
+				Children:  convert(p.NavPoints),
+			})
+		}
+		return nps
+	}
+
+	t.Title = collapseSpace(n.Title)
+	t.NavPoints = convert(n.NavPoints)
+	return nil
+}
+
+// navList represents an <ol> within an EPUB3 navigation document.
+type navList struct {
+	Items []navListItem `xml:"li"`
+}
+
+type navListItem struct {
+	Anchor navLabel `xml:"a"`
+	Span   navLabel `xml:"span"`
+	List   navList  `xml:"ol"`
+}
+
+type navLabel struct {
+	HREF string
+	Text string
+}
+
+// UnmarshalXML collects the href attribute and all nested character data of a
+// label element, since titles may be wrapped in further inline markup.
+func (l *navLabel) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "href" {
+			l.HREF = attr.Value
+		}
+	}
+
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if t.Name == start.Name {
+				l.Text = collapseSpace(text.String())
+				return nil
+			}
+		}
+	}
+}
+
+type navElement struct {
+	H1   string  `xml:"h1"`
+	H2   string  `xml:"h2"`
+	List navList `xml:"ol"`
+}
+
+func (t *TOC) unmarshalNav(b []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "nav" || !isTOCNav(start) {
+			continue
+		}
+
+		var nav navElement
+		if err = d.DecodeElement(&nav, &start); err != nil {
+			return err
+		}
+
+		t.Title = collapseSpace(nav.H1 + nav.H2)
+		order := 0
+		var convert func(list navList) []NavPoint
+		convert = func(list navList) []NavPoint {
+			var nps []NavPoint
+			for _, li := range list.Items {
+				label := li.Anchor
+				if label.Text == "" && label.HREF == "" {
+					label = li.Span
+				}
+				order++
+				np := NavPoint{
+					Title:     label.Text,
+					HREF:      label.HREF,
+					PlayOrder: order,
+				}
+				np.Children = convert(li.List)
+				nps = append(nps, np)
+			}
+			return nps
+		}
+		t.NavPoints = convert(nav.List)
+		return nil
+	}
+}
+
+// isTOCNav reports whether a <nav> element is the book's table of contents
+// rather than e.g. its landmarks or page list.
+func isTOCNav(start xml.StartElement) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Local != "type" {
+			continue
+		}
+		if attr.Name.Space != opsNamespace && attr.Name.Space != "epub" {
+			continue
+		}
+		for _, typ := range strings.Fields(attr.Value) {
+			if typ == "toc" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collapseSpace trims s and replaces runs of whitespace with a single space.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,199 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// newTestReader builds an in-memory epub from a map of file names to their
+// contents.
+func newTestReader(t *testing.T, files map[string]string) *Reader {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+const testContainer = `<?xml version="1.0"?>
+<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container" version="1.0">
+  <rootfiles>
+    <rootfile media-type="application/oebps-package+xml" full-path="OPS/content.opf"/>
+  </rootfiles>
+</container>`
+
+const testPackage = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Test</dc:title>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav/toc.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="c1" href="text/c1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c2" href="text/c2.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="c1"/>
+    <itemref idref="c2"/>
+  </spine>
+</package>`
+
+const testNav = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<body>
+  <nav epub:type="landmarks">
+    <ol><li><a href="../text/c2.xhtml">Not the TOC</a></li></ol>
+  </nav>
+  <nav epub:type="toc">
+    <h1>Contents</h1>
+    <ol>
+      <li><a href="../text/c1.xhtml">Chapter <em>One</em></a></li>
+      <li>
+        <span>Part Two</span>
+        <ol>
+          <li><a href="../text/c2.xhtml#s1">Section&nbsp;1</a></li>
+        </ol>
+      </li>
+    </ol>
+  </nav>
+</body>
+</html>`
+
+func TestNavTOC(t *testing.T) {
+	r := newTestReader(t, map[string]string{
+		containerPath:       testContainer,
+		"OPS/content.opf":   testPackage,
+		"OPS/nav/toc.xhtml": testNav,
+		"OPS/text/c1.xhtml": "<html/>",
+		"OPS/text/c2.xhtml": "<html/>",
+	})
+	rf := r.Rootfiles[0]
+	toc := rf.TOC
+	if rf.TOCErr != nil {
+		t.Fatal(rf.TOCErr)
+	}
+
+	if toc.Title != "Contents" {
+		t.Errorf(expFormat, "Contents", toc.Title)
+	}
+
+	points, depths := toc.Flatten()
+	testCases := []struct {
+		expTitle     string
+		expHREF      string
+		expFragment  string
+		expPlayOrder int
+		expDepth     int
+		expSpine     int
+	}{
+		{"Chapter One", "text/c1.xhtml", "", 1, 0, 0},
+		{"Part Two", "", "", 2, 0, -1},
+		{"Section 1", "text/c2.xhtml", "s1", 3, 1, 1},
+	}
+
+	if len(points) != len(testCases) {
+		t.Fatalf(expFormat, len(testCases), len(points))
+	}
+	for i, tc := range testCases {
+		np := points[i]
+		if np.Title != tc.expTitle {
+			t.Errorf(expFormat, tc.expTitle, np.Title)
+		}
+		if np.HREF != tc.expHREF {
+			t.Errorf(expFormat, tc.expHREF, np.HREF)
+		}
+		if np.Fragment != tc.expFragment {
+			t.Errorf(expFormat, tc.expFragment, np.Fragment)
+		}
+		if np.PlayOrder != tc.expPlayOrder {
+			t.Errorf(expFormat, tc.expPlayOrder, np.PlayOrder)
+		}
+		if depths[i] != tc.expDepth {
+			t.Errorf(expFormat, tc.expDepth, depths[i])
+		}
+		if idx := rf.Spine.SpineIndex(np.HREF); idx != tc.expSpine {
+			t.Errorf(expFormat, tc.expSpine, idx)
+		}
+	}
+}
+
+func TestBadTOC(t *testing.T) {
+	badNCX := strings.Replace(testPackage, `<item id="nav" href="nav/toc.xhtml" media-type="application/xhtml+xml" properties="nav"/>`, "", 1)
+	badNCX = strings.Replace(badNCX, "<spine>", `<spine toc="ncx" page-progression-direction="rtl">`, 1)
+	testCases := []map[string]string{
+		// The spine's toc attribute references a missing item.
+		{
+			containerPath:       testContainer,
+			"OPS/content.opf":   badNCX,
+			"OPS/text/c1.xhtml": "<html/>",
+			"OPS/text/c2.xhtml": "<html/>",
+		},
+		// The navigation document is not XML.
+		{
+			containerPath:       testContainer,
+			"OPS/content.opf":   testPackage,
+			"OPS/nav/toc.xhtml": "<html><body><nav",
+			"OPS/text/c1.xhtml": "<html/>",
+			"OPS/text/c2.xhtml": "<html/>",
+		},
+	}
+
+	// Books open without a table of contents.
+	for _, files := range testCases {
+		rf := newTestReader(t, files).Rootfiles[0]
+		if points, _ := rf.TOC.Flatten(); len(points) != 0 {
+			t.Errorf(expFormat, 0, len(points))
+		}
+		if len(rf.Itemrefs) != 2 {
+			t.Errorf(expFormat, 2, len(rf.Itemrefs))
+		}
+		if rf.TOCErr == nil {
+			t.Errorf(expFormat, "an error", rf.TOCErr)
+		}
+	}
+	rf := newTestReader(t, testCases[0]).Rootfiles[0]
+	if rf.TOCIDREF != "ncx" || rf.PageProgressionDirection != "rtl" {
+		t.Errorf(expFormat, "ncx rtl", rf.TOCIDREF+" "+rf.PageProgressionDirection)
+	}
+}
+
+func TestEncodedHREF(t *testing.T) {
+	pkg := strings.Replace(testPackage, `href="text/c1.xhtml"`, `href="text/caf%C3%A9%201.xhtml"`, 1)
+	r := newTestReader(t, map[string]string{
+		containerPath:           testContainer,
+		"OPS/content.opf":       pkg,
+		"OPS/nav/toc.xhtml":     testNav,
+		"OPS/text/café 1.xhtml": "<html/>",
+		"OPS/text/c2.xhtml":     "<html/>",
+	})
+	rf := r.Rootfiles[0]
+
+	f, err := rf.Itemrefs[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for _, href := range []string{"text/caf%C3%A9%201.xhtml", "text/café 1.xhtml", "text/caf%c3%a9 1.xhtml"} {
+		if idx := rf.SpineIndex(href); idx != 0 {
+			t.Errorf(expFormat, 0, idx)
+		}
+	}
+}
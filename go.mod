@@ -3,19 +3,21 @@ module github.com/wormggmm/goreader
 go 1.20
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/google/logger v1.1.1
+	github.com/mattn/go-runewidth v0.0.14
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/nsf/termbox-go v1.1.1
+	github.com/rivo/uniseg v0.4.4
 	github.com/stretchr/testify v1.8.4
 	github.com/wormggmm/gohook v0.0.2
 	golang.org/x/net v0.10.0
+	golang.org/x/text v0.14.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/stretchr/objx v0.5.0 // indirect
 	github.com/vcaesar/keycode v0.10.1 // indirect
 	golang.org/x/sys v0.8.0 // indirect
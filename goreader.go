@@ -10,12 +10,15 @@ import (
 	"github.com/google/logger"
 	"github.com/wormggmm/goreader/app"
 	"github.com/wormggmm/goreader/epub"
+	"github.com/wormggmm/goreader/parse"
 )
 
 var (
-	version   = "v0.0.7"
-	helpPrint bool
-	opt       = &app.Option{}
+	version       = "v0.0.7"
+	helpPrint     bool
+	searchPattern string
+	themeName     string
+	opt           = &app.Option{}
 )
 
 func init() {
@@ -27,13 +30,32 @@ func init() {
 		fmt.Fprintln(os.Stderr, "")
 		fmt.Fprintln(os.Stderr, "When Reading:")
 		printHelp()
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "In Library:")
+		printLibraryHelp()
 	}
 	flag.BoolVar(&opt.DebugMode, "d", false, "debug mode(debug log in same directory of the book)")
 	flag.BoolVar(&opt.NoBlank, "nb", false, "not blank line")
 	flag.BoolVar(&opt.GlobalHook, "g", false, "hook hotkey global(can without focus)")
+	flag.BoolVar(&opt.LibraryDB, "db", false, "keep reading state in $XDG_DATA_HOME/goreader instead of .mark files(imports existing .mark files)")
+	flag.IntVar(&opt.MaxWidth, "w", 0, "max text width(default follow the terminal width)")
+	flag.BoolVar(&opt.Justify, "justify", false, "justify text to both edges")
+	flag.BoolVar(&opt.Hyphenate, "hyphenate", false, "hyphenate words at the end of lines, in the book's language")
+	flag.BoolVar(&opt.Vertical, "vertical", false, "lay out books written vertically in columns, read from right to left")
+	flag.StringVar(&opt.Image.Mode, "images", "auto", "show images with the terminal's graphics protocol(sixel, kitty or iterm) if it has one, else draw them as ascii art(ascii), in color with half blocks(blocks) or with braille dots for line art(braille)")
+	flag.StringVar(&opt.Image.Dither, "dither", parse.DitherNone, "image dithering: none, ordered or floyd-steinberg")
+	flag.Float64Var(&opt.Image.CellRatio, "cell-ratio", 2, "height of a terminal cell over its width, to keep the aspect ratio of images")
+	flag.IntVar(&opt.Image.CellWidth, "cell-width", 10, "width of a terminal cell in pixels, to scale sixel images")
+	flag.BoolVar(&opt.Image.TrueColor, "truecolor", false, "draw images in blocks mode with 24-bit color")
+	flag.StringVar(&themeName, "theme", "", "color theme: default, dark, light, solarized, monochrome or a theme from the config file")
+	flag.StringVar(&searchPattern, "s", "", "print lines matching the pattern(case-insensitive regexp) and exit")
 }
 func main() {
-	if len(os.Args) <= 1 {
+	if len(os.Args) > 1 && os.Args[1] == "export-marks" {
+		os.Exit(exportMarks(os.Args[2:]))
+	}
+	libraryPath := os.Getenv("GOREADER_LIBRARY")
+	if len(os.Args) <= 1 && libraryPath == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -42,12 +64,34 @@ func main() {
 		printHelp()
 		os.Exit(1)
 	}
+	if opt.Image.Mode == "auto" {
+		if opt.Image.Mode = parse.DetectGraphics(os.Getenv); opt.Image.Mode == "" {
+			opt.Image.Mode = parse.ImageASCII
+		}
+	}
+	if err := checkImageOption(opt.Image); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid image option: %s\n", err.Error())
+		os.Exit(1)
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load config: %s\n", err.Error())
+		os.Exit(1)
+	}
+	opt.Keys = cfg.Keys
+	if opt.Theme, err = cfg.LoadTheme(themeName); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load theme: %s\n", err.Error())
+		os.Exit(1)
+	}
 	args := flag.Args()
-	if len(args) <= 0 {
+	if len(args) <= 0 && libraryPath == "" {
 		fmt.Fprintln(os.Stderr, "No epub file specified")
 		os.Exit(1)
 	}
-	filePath := args[0]
+	filePath := libraryPath
+	if len(args) > 0 {
+		filePath = args[0]
+	}
 	fileDir := filepath.Dir(filePath)
 	if !opt.DebugMode {
 		fileDir = os.DevNull
@@ -55,6 +99,17 @@ func main() {
 	lf := newLogger(fileDir)
 	defer lf.Close()
 	defer logger.Close()
+
+	if fi, err := os.Stat(filePath); err == nil && fi.IsDir() {
+		l := app.NewLibrary(filePath, opt)
+		l.Run()
+		if l.Err() != nil {
+			fmt.Fprintf(os.Stderr, "Exit with error: %s\n", l.Err().Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	rc, err := epub.OpenReader(filePath)
 	if err != nil {
 		var msg string
@@ -70,6 +125,14 @@ func main() {
 	defer rc.Close()
 	book := rc.Rootfiles[0]
 
+	if searchPattern != "" {
+		if err := printSearch(book, searchPattern); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to search: %s\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	a := app.NewApp(book, filePath, opt)
 	a.Run()
 
@@ -81,6 +144,77 @@ func main() {
 
 }
 
+// printSearch prints each line of the book matching pattern, prefixed with its
+// chapter and line number.
+func printSearch(book *epub.Rootfile, pattern string) error {
+	re, err := parse.CompileSearch(pattern, false)
+	if err != nil {
+		return err
+	}
+	matches, err := parse.Search(book, re, parse.Option{})
+	if err != nil {
+		return err
+	}
+
+	var last *parse.Match
+	for i, m := range matches {
+		if last != nil && last.Chapter == m.Chapter && last.Row == m.Row {
+			continue
+		}
+		fmt.Printf("%d:%d: %s\n", m.Chapter, m.Row, m.Line)
+		last = &matches[i]
+	}
+	return nil
+}
+
+// exportMarks runs the export-marks subcommand, which writes the bookmarks and
+// annotations of a book to stdout, and returns the exit code.
+func exportMarks(args []string) int {
+	fs := flag.NewFlagSet("export-marks", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, "goreader export-marks [-db] [-json] epub_file")
+		fmt.Fprintln(os.Stderr, "")
+		fs.PrintDefaults()
+	}
+	exportOpt := &app.Option{}
+	asJSON := fs.Bool("json", false, "write JSON instead of Markdown")
+	fs.BoolVar(&exportOpt.LibraryDB, "db", false, "read marks from $XDG_DATA_HOME/goreader instead of the .mark file")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	filePath := fs.Arg(0)
+
+	lf := newLogger(os.DevNull)
+	defer lf.Close()
+	defer logger.Close()
+
+	rc, err := epub.OpenReader(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open epub: %s\n", err.Error())
+		return 1
+	}
+	defer rc.Close()
+
+	e, err := app.NewExport(rc.Rootfiles[0], filePath, exportOpt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to export marks: %s\n", err.Error())
+		return 1
+	}
+	if *asJSON {
+		err = e.WriteJSON(os.Stdout)
+	} else {
+		err = e.WriteMarkdown(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to export marks: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
 func newLogger(logPath string) *os.File {
 	if logPath != os.DevNull {
 		logPath += "/goreader-debug.log"
@@ -94,26 +228,62 @@ func newLogger(logPath string) *os.File {
 	return lf
 }
 func printUsage() {
-	fmt.Fprintln(os.Stderr, "goreader [-h] [-d] [-g] [-nb] [epub_file]")
+	fmt.Fprintln(os.Stderr, "goreader [-h] [-d] [-g] [-nb] [-db] [-w width] [-justify] [-hyphenate] [-vertical] [-images mode] [-dither method] [-cell-ratio ratio] [-cell-width pixels] [-truecolor] [-theme name] [-s pattern] [epub_file | library_dir]")
+	fmt.Fprintln(os.Stderr, "goreader export-marks [-db] [-json] epub_file")
 	fmt.Fprintln(os.Stderr, "")
 }
 
+// checkImageOption reports an error if the image mode or dithering method is
+// not one goreader knows.
+func checkImageOption(o parse.ImageOption) error {
+	switch o.Mode {
+	case parse.ImageASCII, parse.ImageBlocks, parse.ImageBraille,
+		parse.ImageSixel, parse.ImageKitty, parse.ImageITerm:
+	default:
+		return fmt.Errorf("unknown image mode %q", o.Mode)
+	}
+	switch o.Dither {
+	case parse.DitherNone, parse.DitherOrdered, parse.DitherFloydSteinberg:
+	default:
+		return fmt.Errorf("unknown dithering method %q", o.Dither)
+	}
+	if o.CellRatio <= 0 {
+		return fmt.Errorf("cell ratio %v is not positive", o.CellRatio)
+	}
+	if o.CellWidth <= 0 {
+		return fmt.Errorf("cell width %v is not positive", o.CellWidth)
+	}
+	return nil
+}
+
+// printHelp prints the key bindings, including those set in the config file.
 func printHelp() {
-	fmt.Fprintln(os.Stderr, "	Key                  Action")
+	var keys map[string]app.KeyList
+	if cfg, err := app.LoadConfig(); err == nil {
+		keys = cfg.Keys
+	}
+	if err := app.WriteHelp(os.Stderr, keys); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid key bindings: %s\n", err.Error())
+	}
+	printKey("Ctrl/Cmd + 1,2,3", "Turn on/off global hotkey listener")
+	printKey("Mouse Wheel", "Scroll like j/h")
+	printKey("m + key1,key2,key3", "Add bookmark named key1,key2,key3")
+	printKey("n + key1,key2,key3", "Load bookmark named key1,key2,key3")
+}
+
+func printLibraryHelp() {
+	printKey("Key", "Action")
 	fmt.Fprintln(os.Stderr)
-	fmt.Fprintln(os.Stderr, "	q / Esc              Quit")
-	fmt.Fprintln(os.Stderr, "	k / Up arrow         Scroll up")
-	fmt.Fprintln(os.Stderr, "	j / Down arrow       Scroll down")
-	fmt.Fprintln(os.Stderr, "	h / Left arrow       Scroll left")
-	fmt.Fprintln(os.Stderr, "	l / Right arrow      Scroll right")
-	fmt.Fprintln(os.Stderr, "	b                    Previous page")
-	fmt.Fprintln(os.Stderr, "	f                    Next page")
-	fmt.Fprintln(os.Stderr, "	B                    Previous chapter")
-	fmt.Fprintln(os.Stderr, "	F                    Next chapter")
-	fmt.Fprintln(os.Stderr, "	g                    Top of chapter")
-	fmt.Fprintln(os.Stderr, "	G                    Bottom of chapter")
-	fmt.Fprintln(os.Stderr, "	Ctrl/Cmd + 1,2,3     Turn on/off global hotkey listener")
-	fmt.Fprintln(os.Stderr, "	Mouse Wheel          Scroll like j/h")
-	fmt.Fprintln(os.Stderr, "	m + key1,key2,key3   Add bookmark named key1,key2,key3")
-	fmt.Fprintln(os.Stderr, "	n + key1,key2,key3   Load bookmark named key1,key2,key3")
+	printKey("q / Esc", "Quit")
+	printKey("k / j", "Move selection")
+	printKey("b / f", "Previous / next page")
+	printKey("g / G", "First / last book")
+	printKey("Enter", "Open book")
+	printKey("/ text", "Filter by title, author or file name")
+	printKey("s", "Sort by title, author, progress or last read")
+}
+
+// printKey prints a line of help for a key.
+func printKey(key, action string) {
+	fmt.Fprintf(os.Stderr, "\t%-24s %s\n", key, action)
 }
@@ -40,6 +40,54 @@ func (a *MockApplication) PrevChapter() {
 	a.Called()
 }
 
+func (a *MockApplication) ShowTOC() {
+	a.Called()
+}
+
+func (a *MockApplication) SearchForward() {
+	a.Called()
+}
+
+func (a *MockApplication) SearchBackward() {
+	a.Called()
+}
+
+func (a *MockApplication) NextMatch() {
+	a.Called()
+}
+
+func (a *MockApplication) PrevMatch() {
+	a.Called()
+}
+
+func (a *MockApplication) VisualMode() {
+	a.Called()
+}
+
+func (a *MockApplication) ShowAnnotations() {
+	a.Called()
+}
+
+func (a *MockApplication) ShowHelp() {
+	a.Called()
+}
+
+func (a *MockApplication) NextLink() {
+	a.Called()
+}
+
+func (a *MockApplication) FollowLink() {
+	a.Called()
+}
+
+func (a *MockApplication) LinkBack() {
+	a.Called()
+}
+
+func (a *MockApplication) ShowNote() {
+	a.Called()
+}
+
 func (a *MockApplication) Err() error {
 	a.Called()
 	return nil
@@ -2,6 +2,7 @@ package mock
 
 import (
 	"github.com/stretchr/testify/mock"
+	"github.com/wormggmm/goreader/nav"
 	"github.com/wormggmm/goreader/parse"
 )
 
@@ -41,6 +42,11 @@ func (p *MockPageNavigator) PageUp() bool {
 	return false
 }
 
+func (p *MockPageNavigator) PageRows() int {
+	args := p.Called()
+	return args.Int(0)
+}
+
 func (p *MockPageNavigator) Pages() int {
 	panic("not implemented") // TODO: Implement
 }
@@ -57,6 +63,26 @@ func (p *MockPageNavigator) ScrollRight() {
 	p.Called()
 }
 
+func (p *MockPageNavigator) ShowPopup(title, text string) {
+	p.Called(title, text)
+}
+
+func (p *MockPageNavigator) ClosePopup() {
+	p.Called()
+}
+
+func (p *MockPageNavigator) SuppressImages(suppress bool) {
+	p.Called(suppress)
+}
+
+func (p *MockPageNavigator) ScrollBlocksLeft() {
+	p.Called()
+}
+
+func (p *MockPageNavigator) ScrollBlocksRight() {
+	p.Called()
+}
+
 func (p *MockPageNavigator) ScrollUp() {
 	p.Called()
 }
@@ -65,6 +91,10 @@ func (p *MockPageNavigator) SetDoc(_ parse.Cellbuf) {
 	panic("not implemented") // TODO: Implement
 }
 
+func (p *MockPageNavigator) SetHighlights(_ []nav.Highlight) {
+	panic("not implemented") // TODO: Implement
+}
+
 func (p *MockPageNavigator) Size() (int, int) {
 	panic("not implemented") // TODO: Implement
 }
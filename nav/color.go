@@ -0,0 +1,29 @@
+package nav
+
+import (
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/parse"
+)
+
+// rgbStyle returns fg and bg as 24-bit colors if the terminal is in
+// termbox.OutputRGB mode, which draws palette colors wrong, or else as they
+// are.
+func rgbStyle(fg, bg termbox.Attribute) (termbox.Attribute, termbox.Attribute) {
+	if termbox.SetOutputMode(termbox.OutputCurrent) != termbox.OutputRGB {
+		return fg, bg
+	}
+	s := parse.Style{Fg: fg, Bg: bg}.RGB()
+	return s.Fg, s.Bg
+}
+
+// setCell is termbox.SetCell, with the colors converted by rgbStyle.
+func setCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	fg, bg = rgbStyle(fg, bg)
+	termbox.SetCell(x, y, ch, fg, bg)
+}
+
+// clearScreen is termbox.Clear, with the colors converted by rgbStyle.
+func clearScreen(fg, bg termbox.Attribute) error {
+	fg, bg = rgbStyle(fg, bg)
+	return termbox.Clear(fg, bg)
+}
@@ -0,0 +1,130 @@
+package nav
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/parse"
+)
+
+// imageKey identifies the escape sequence of the rows first to first+rows-1
+// of an image of the document, by index, in a mode.
+type imageKey struct {
+	image, first, rows int
+	mode               string
+}
+
+// placement is an image shown at column x and row y of the screen.
+type placement struct {
+	imageKey
+	x, y int
+}
+
+// SuppressImages keeps images from being shown while suppress is set, as
+// when a menu or prompt is drawn over the page.
+func (p *Pager) SuppressImages(suppress bool) {
+	p.suppressImages = suppress
+}
+
+// imageOut returns where the escape sequences of images are written.
+func (p *Pager) imageOut() io.Writer {
+	if p.ImageOut == nil {
+		return os.Stdout
+	}
+	return p.ImageOut
+}
+
+// flush draws the page in the terminal, and then the images on it. When the
+// images shown change, those of the last page are erased first: the cells
+// under sixel and iTerm2 images are drawn again, and kitty images are
+// deleted. Images that stay where they are are not sent again.
+func (p *Pager) flush(screenRows map[int]int, x0 int) error {
+	shown := p.placements(screenRows, x0)
+	changed := !samePlacements(shown, p.shown)
+	var err error
+	switch {
+	case !changed || len(p.shown) == 0:
+		err = termbox.Flush()
+	case p.Image.Mode == parse.ImageKitty:
+		io.WriteString(p.imageOut(), parse.KittyClear)
+		err = termbox.Flush()
+	default:
+		err = termbox.Sync()
+	}
+	p.shown = shown
+	if err != nil || !changed || len(shown) == 0 {
+		return err
+	}
+	_, err = io.WriteString(p.imageOut(), p.imageEscapes(shown))
+	return err
+}
+
+// placements returns where the images of the page are shown, given the
+// screen row of each row of the document on it and the screen column of its
+// first column. Images partly on the page are cut to the rows on it.
+// Nothing is shown under popups, menus and prompts.
+func (p *Pager) placements(screenRows map[int]int, x0 int) []placement {
+	if !p.Image.Graphics() || p.popup != "" || p.suppressImages {
+		return nil
+	}
+	var shown []placement
+	for i, im := range p.doc.Images {
+		first, rows := -1, 0
+		for r := 0; r < im.Height; r++ {
+			if _, ok := screenRows[im.Row+r]; ok {
+				if first < 0 {
+					first = r
+				}
+				rows = r - first + 1
+			}
+		}
+		x := x0 + im.Col
+		if first < 0 || x < 0 {
+			continue
+		}
+		key := imageKey{image: i, first: first, rows: rows, mode: p.Image.Mode}
+		shown = append(shown, placement{key, x, screenRows[im.Row+first]})
+	}
+	return shown
+}
+
+// samePlacements reports whether a and b show the same images in the same
+// places.
+func samePlacements(a, b []placement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// imageEscapes returns the escape sequences that show images at their
+// placements. The sequences of the images are kept until the document
+// changes, since encoding them is slow.
+func (p *Pager) imageEscapes(shown []placement) string {
+	if p.imageCache == nil {
+		p.imageCache = make(map[imageKey]string)
+	}
+	var b strings.Builder
+	for _, pl := range shown {
+		seq, ok := p.imageCache[pl.imageKey]
+		if !ok {
+			seq = p.doc.Images[pl.image].Escape(p.Image, pl.first, pl.rows)
+			p.imageCache[pl.imageKey] = seq
+		}
+		if seq == "" {
+			continue
+		}
+		// Save the cursor and move it to the top left cell of the image,
+		// then restore it, so termbox finds it where it left it.
+		fmt.Fprintf(&b, "\x1b7\x1b[%d;%dH%s\x1b8", pl.y+1, pl.x+1, seq)
+	}
+	return b.String()
+}
@@ -0,0 +1,196 @@
+package nav
+
+import (
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/parse"
+)
+
+// Menu is a scrollable list of entries drawn in a box on top of the pager.
+type Menu struct {
+	Title    string
+	Items    []string
+	Empty    string // shown in place of the entries when there are none
+	Selected int
+	scrollY  int
+}
+
+// lines returns the lines shown in the menu's box: its entries, or a line
+// saying there are none.
+func (m *Menu) lines() []string {
+	if len(m.Items) > 0 {
+		return m.Items
+	}
+	if m.Empty != "" {
+		return []string{m.Empty}
+	}
+	return []string{"No entries"}
+}
+
+// NewMenu creates a menu with the given entry initially selected.
+func NewMenu(title string, items []string, selected int) *Menu {
+	m := &Menu{Title: title, Items: items}
+	m.Select(selected)
+	return m
+}
+
+// Select moves the selection to the entry at index i, clamped to the list.
+func (m *Menu) Select(i int) {
+	if i >= len(m.Items) {
+		i = len(m.Items) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	m.Selected = i
+}
+
+// Down moves the selection to the next entry.
+func (m *Menu) Down() {
+	m.Select(m.Selected + 1)
+}
+
+// Up moves the selection to the previous entry.
+func (m *Menu) Up() {
+	m.Select(m.Selected - 1)
+}
+
+// PageDown moves the selection down by the number of visible entries.
+func (m *Menu) PageDown() {
+	_, _, _, h := m.bounds()
+	m.Select(m.Selected + h - 2)
+}
+
+// PageUp moves the selection up by the number of visible entries.
+func (m *Menu) PageUp() {
+	_, _, _, h := m.bounds()
+	m.Select(m.Selected - (h - 2))
+}
+
+// ToTop selects the first entry.
+func (m *Menu) ToTop() {
+	m.Select(0)
+}
+
+// ToBottom selects the last entry.
+func (m *Menu) ToBottom() {
+	m.Select(len(m.Items) - 1)
+}
+
+// bounds returns the position and size of the menu's box, including its
+// border, centered within the terminal.
+func (m *Menu) bounds() (x, y, w, h int) {
+	width, height := termbox.Size()
+
+	w = textWidth(m.Title) + 4
+	lines := m.lines()
+	for _, line := range lines {
+		if l := textWidth(line) + 4; l > w {
+			w = l
+		}
+	}
+	if w > width-4 {
+		w = width - 4
+	}
+	h = len(lines) + 2
+	if h > height-2 {
+		h = height - 2
+	}
+
+	return (width - w) / 2, (height - h) / 2, w, h
+}
+
+// Draw displays the menu over whatever is currently on screen.
+func (m *Menu) Draw() error {
+	x0, y0, w, h := m.bounds()
+	if w < 4 || h < 3 {
+		return termbox.Flush()
+	}
+
+	// Keep the selection within the visible rows.
+	rows := h - 2
+	if m.Selected < m.scrollY {
+		m.scrollY = m.Selected
+	} else if m.Selected >= m.scrollY+rows {
+		m.scrollY = m.Selected - rows + 1
+	}
+
+	drawBox(x0, y0, w, h, m.Title)
+	if len(m.Items) == 0 {
+		drawText(x0+1, y0+1, w-2, " "+m.lines()[0], termbox.ColorDefault, termbox.ColorDefault)
+		return termbox.Flush()
+	}
+	for row := 0; row < rows; row++ {
+		i := row + m.scrollY
+		if i >= len(m.Items) {
+			break
+		}
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		if i == m.Selected {
+			fg |= termbox.AttrReverse
+			bg |= termbox.AttrReverse
+		}
+		drawText(x0+1, y0+1+row, w-2, " "+m.Items[i], fg, bg)
+	}
+
+	return termbox.Flush()
+}
+
+// drawBox clears a rectangle and draws a border around it, with an optional
+// title embedded in the top edge.
+func drawBox(x0, y0, w, h int, title string) {
+	fg, bg := termbox.ColorDefault, termbox.ColorDefault
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			ch := ' '
+			switch {
+			case y == y0 && x == x0:
+				ch = '┌'
+			case y == y0 && x == x0+w-1:
+				ch = '┐'
+			case y == y0+h-1 && x == x0:
+				ch = '└'
+			case y == y0+h-1 && x == x0+w-1:
+				ch = '┘'
+			case y == y0 || y == y0+h-1:
+				ch = '─'
+			case x == x0 || x == x0+w-1:
+				ch = '│'
+			}
+			setCell(x, y, ch, fg, bg)
+		}
+	}
+	if title != "" {
+		title = " " + title + " "
+		width := textWidth(title)
+		if width > w-4 {
+			width = w - 4
+		}
+		drawText(x0+2, y0, width, title, fg|termbox.AttrBold, bg)
+	}
+}
+
+// drawText writes str starting at x, y, padding or truncating it to width
+// cells.
+func drawText(x, y, width int, str string, fg, bg termbox.Attribute) {
+	i := 0
+	for _, ch := range str {
+		w := parse.RuneWidth(ch)
+		if i+w > width {
+			break
+		}
+		setCell(x+i, y, ch, fg, bg)
+		i += w
+	}
+	for ; i < width; i++ {
+		setCell(x+i, y, ' ', fg, bg)
+	}
+}
+
+// textWidth returns the number of cells str takes on the terminal.
+func textWidth(str string) int {
+	w := 0
+	for _, ch := range str {
+		w += parse.RuneWidth(ch)
+	}
+	return w
+}
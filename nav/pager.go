@@ -1,6 +1,8 @@
 package nav
 
 import (
+	"io"
+	"strings"
 	"time"
 
 	termbox "github.com/nsf/termbox-go"
@@ -14,17 +16,32 @@ type PageNavigator interface {
 	MaxScrollY() int
 	PageDown() bool
 	PageUp() bool
+	PageRows() int
 	Pages() int
 	ScrollDown()
 	ScrollLeft()
 	ScrollRight()
 	ScrollUp()
+	ScrollBlocksLeft()
+	ScrollBlocksRight()
+	ShowPopup(title, text string)
+	ClosePopup()
+	SuppressImages(suppress bool)
 	SetDoc(parse.Cellbuf)
 	Size() (int, int)
 	ToBottom()
 	ToTop()
 	ScrollY() int
 	SetScrollY(y int)
+	SetHighlights(h []Highlight)
+}
+
+// Highlight marks a region of the pager's cell buffer to be drawn with a
+// different style. Colors left as termbox.ColorDefault keep the cell's own
+// color.
+type Highlight struct {
+	parse.Region
+	parse.Style
 }
 
 type Pager struct {
@@ -33,20 +50,60 @@ type Pager struct {
 	doc        parse.Cellbuf
 	NotBlank   bool
 	showYCount int // current page showd lines count, include blank lines
+	highlights map[int]Highlight
+	// blockScroll holds the horizontal scroll distance of the preformatted
+	// blocks of doc, by index, which scroll independently of the page.
+	blockScroll map[int]int
+	// popup is the text of a box drawn over the page, with its title.
+	popup, popupTitle string
+
+	// Theme sets the colors of text without a style of its own and of the
+	// background around it. It defaults to parse.DefaultTheme.
+	Theme *parse.Theme
+	// Image sets how the images of the document are shown. Images shown
+	// with a terminal graphics protocol are written to ImageOut, which
+	// defaults to os.Stdout, after the page is drawn.
+	Image          parse.ImageOption
+	ImageOut       io.Writer
+	suppressImages bool
+	shown          []placement         // the images shown with the last page
+	imageCache     map[imageKey]string // escape sequences of the images shown
 }
 
-// setDoc sets the pager's cell buffer
+// textStyle returns the style of the page.
+func (p *Pager) textStyle() parse.Style {
+	if p.Theme == nil {
+		return parse.DefaultTheme.Text
+	}
+	return p.Theme.Text
+}
+
+// setDoc sets the pager's cell buffer and clears any highlights.
 func (p *Pager) SetDoc(doc parse.Cellbuf) {
 	p.doc = doc
+	p.highlights = nil
+	p.blockScroll = nil
+	p.imageCache = nil
+}
+
+// SetHighlights replaces the highlighted regions of the pager's cell buffer.
+func (p *Pager) SetHighlights(h []Highlight) {
+	p.highlights = make(map[int]Highlight)
+	for _, hl := range h {
+		for i := hl.Start; i < hl.End; i++ {
+			p.highlights[i] = hl
+		}
+	}
 }
 
 func (p *Pager) DrawMsg(msg string) error {
-	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	text := p.textStyle()
+	clearScreen(text.Fg, text.Bg)
 	// width, height := termbox.Size()
 	for idx, c := range msg {
-		termbox.SetCell(idx, 0, c, termbox.ColorDefault, termbox.ColorDefault)
+		setCell(idx, 0, c, text.Fg, text.Bg)
 	}
-	err := termbox.Flush()
+	err := p.flush(nil, 0)
 	if err != nil {
 		return err
 	}
@@ -56,10 +113,22 @@ func (p *Pager) DrawMsg(msg string) error {
 
 // Draw displays a pager's cell buffer in the terminal.
 func (p *Pager) Draw() error {
-	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	text := p.textStyle()
+	clearScreen(text.Fg, text.Bg)
 
 	width, height := termbox.Size()
+	if p.doc.Vertical {
+		p.drawVertical(width, height)
+		if p.popup != "" {
+			p.drawPopup()
+		}
+		return p.flush(nil, 0)
+	}
 	var centerOffset int
+	if width > p.doc.Width {
+		centerOffset = (width - p.doc.Width) / 2
+	}
+	screenRows := make(map[int]int) // the screen row of each row shown
 	screenY := -1
 	p.showYCount = 0
 	for y := 0; y < height; y++ {
@@ -84,23 +153,199 @@ func (p *Pager) Draw() error {
 				continue
 			}
 		}
+		screenRows[y+p.scrollY] = screenY
+		block, scroll := p.scrolledBlock(y + p.scrollY)
 		for x := 0; x < p.doc.Width; x++ {
 			index := (y+p.scrollY)*p.doc.Width + x
 			if index >= len(p.doc.Cells) || index <= 0 {
 				continue
 			}
 			cell := p.doc.Cells[index]
-			if width > p.doc.Width {
-				centerOffset = (width - p.doc.Width) / 2
+			scrolled := block != nil && x >= block.X
+			if scrolled {
+				cell = block.Cell(y+p.scrollY-block.Row, x-block.X+scroll)
+			}
+			if cell.Ch == parse.Continuation {
+				// The double-width character before the cell covers it.
+				continue
+			}
+			style := parse.Style{Fg: cell.Fg, Bg: cell.Bg}.Over(text)
+			if hl, ok := p.highlights[index]; ok && !scrolled {
+				style = hl.Style.Over(style)
 			}
 
 			// Calling SetCell with coordinates outside of the terminal viewport
 			// results in a no-op.
-			termbox.SetCell(x+p.scrollX+centerOffset, screenY, cell.Ch, cell.Fg, cell.Bg)
+			setCell(x+p.scrollX+centerOffset, screenY, cell.Ch, style.Fg, style.Bg)
+		}
+	}
+	if p.popup != "" {
+		p.drawPopup()
+	}
+
+	return p.flush(screenRows, p.scrollX+centerOffset)
+}
+
+// verticalForms maps punctuation to the forms it takes in vertical text.
+var verticalForms = map[rune]rune{
+	'、': '︑', '。': '︒', '，': '︐', '：': '︓', '；': '︔', '！': '︕', '？': '︖',
+	'「': '﹁', '」': '﹂', '『': '﹃', '』': '﹄', '（': '︵', '）': '︶',
+	'【': '︻', '】': '︼', '〈': '︿', '〉': '﹀', '《': '︽', '》': '︾',
+	'｛': '︷', '｝': '︸', '〔': '︹', '〕': '︺', '…': '︙', '‥': '︰',
+	'ー': '︱', '―': '︱', '—': '︱',
+}
+
+// drawVertical displays a cell buffer laid out in vertical mode, with each
+// of its rows as a column, from right to left. Columns are two cells wide, so
+// that double-width characters fit in them.
+func (p *Pager) drawVertical(width, height int) {
+	text := p.textStyle()
+	p.showYCount = p.PageRows()
+	for i := 0; i < p.showYCount; i++ {
+		x := width - 2*(i+1)
+		for y := 0; y < p.doc.Width && y < height; y++ {
+			index := (p.scrollY+i)*p.doc.Width + y
+			if index >= len(p.doc.Cells) {
+				break
+			}
+			cell := p.doc.Cells[index]
+			style := parse.Style{Fg: cell.Fg, Bg: cell.Bg}.Over(text)
+			if hl, ok := p.highlights[index]; ok {
+				style = hl.Style.Over(style)
+			}
+			ch := cell.Ch
+			if v, ok := verticalForms[ch]; ok {
+				ch = v
+			}
+			setCell(x, y, ch, style.Fg, style.Bg)
+		}
+	}
+}
+
+// popupWidth is the widest a popup's box is drawn.
+const popupWidth = 64
+
+// ShowPopup shows text in a box over the page until ClosePopup is called.
+// Lines of text are wrapped to the width of the box.
+func (p *Pager) ShowPopup(title, text string) {
+	p.popup, p.popupTitle = text, title
+}
+
+// ClosePopup removes the box shown by ShowPopup.
+func (p *Pager) ClosePopup() {
+	p.popup, p.popupTitle = "", ""
+}
+
+// drawPopup draws the popup's box in the middle of the terminal. Text that
+// does not fit is cut off with an ellipsis.
+func (p *Pager) drawPopup() {
+	width, height := termbox.Size()
+	w := popupWidth
+	if w > width-4 {
+		w = width - 4
+	}
+	if w < 8 || height < 3 {
+		return
+	}
+	lines := wrap(p.popup, w-4)
+	h := len(lines) + 2
+	if h > height-2 {
+		h = height - 2
+		lines = lines[:h-2]
+		lines[len(lines)-1] += "…"
+	}
+
+	x0, y0 := (width-w)/2, (height-h)/2
+	drawBox(x0, y0, w, h, p.popupTitle)
+	for i, line := range lines {
+		drawText(x0+2, y0+1+i, w-4, line, termbox.ColorDefault, termbox.ColorDefault)
+	}
+}
+
+// wrap splits text into lines at most width cells wide, breaking lines
+// between words and keeping the line breaks of text.
+func wrap(text string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			switch {
+			case line == "":
+				line = word
+			case textWidth(line)+1+textWidth(word) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+			for textWidth(line) > width {
+				n := fit(line, width)
+				lines = append(lines, line[:n])
+				line = line[n:]
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fit returns the length in bytes of the start of str that fits in width
+// cells, which is at least one character.
+func fit(str string, width int) int {
+	w := 0
+	for i, ch := range str {
+		if w += parse.RuneWidth(ch); w > width && i > 0 {
+			return i
+		}
+	}
+	return len(str)
+}
+
+// scrolledBlock returns the preformatted block containing the given row and
+// its scroll distance, or nil if the row is not in a scrolled block.
+func (p *Pager) scrolledBlock(row int) (*parse.Block, int) {
+	for i := range p.doc.Blocks {
+		b := &p.doc.Blocks[i]
+		if row >= b.Row && row < b.Row+len(b.Lines) && p.blockScroll[i] > 0 {
+			return b, p.blockScroll[i]
 		}
 	}
+	return nil, 0
+}
+
+// blockScrollStep is the number of columns blocks are scrolled by at a time.
+const blockScrollStep = 4
+
+// ScrollBlocksLeft pans the preformatted blocks on the page left.
+func (p *Pager) ScrollBlocksLeft() {
+	p.scrollBlocks(-blockScrollStep)
+}
 
-	return termbox.Flush()
+// ScrollBlocksRight pans the preformatted blocks on the page that are wider
+// than the page right, up to the end of their longest line.
+func (p *Pager) ScrollBlocksRight() {
+	p.scrollBlocks(blockScrollStep)
+}
+
+// scrollBlocks pans the preformatted blocks on the page by n columns.
+func (p *Pager) scrollBlocks(n int) {
+	if p.blockScroll == nil {
+		p.blockScroll = make(map[int]int)
+	}
+	top, bottom := p.scrollY, p.scrollY+p.showYCount
+	for i, b := range p.doc.Blocks {
+		if b.Row >= bottom || b.Row+len(b.Lines) <= top {
+			continue
+		}
+		scroll := p.blockScroll[i] + n
+		if max := b.Width() - (p.doc.Width - b.X); scroll > max {
+			scroll = max
+		}
+		if scroll < 0 {
+			scroll = 0
+		}
+		p.blockScroll[i] = scroll
+	}
 }
 
 // scrollDown pans the pager's viewport down, without exceeding the underlying
@@ -122,6 +367,11 @@ func (p *Pager) ScrollUp() {
 // scrollLeft pans the pager's viewport left, without exceeding the underlying
 // cell buffer document's boundaries.
 func (p *Pager) ScrollLeft() {
+	if p.doc.Vertical {
+		// The next column is on the left.
+		p.ScrollDown()
+		return
+	}
 	if p.scrollX < 0 {
 		p.scrollX++
 	}
@@ -130,6 +380,10 @@ func (p *Pager) ScrollLeft() {
 // scrollRight pans the pager's viewport right, without exceeding the
 // underlying cell buffer document's boundaries.
 func (p *Pager) ScrollRight() {
+	if p.doc.Vertical {
+		p.ScrollUp()
+		return
+	}
 	if p.scrollX > -p.MaxScrollX() {
 		p.scrollX--
 	}
@@ -157,7 +411,7 @@ func (p *Pager) SetScrollY(y int) {
 // pageUp pans the pager's viewport up by a full page, without exceeding the
 // underlying cell buffer document's boundaries.
 func (p *Pager) PageUp() bool {
-	_, viewHeight := termbox.Size()
+	viewHeight := p.PageRows()
 	if p.scrollY > viewHeight {
 		p.scrollY -= viewHeight
 		return true
@@ -179,7 +433,7 @@ func (p *Pager) ToTop() {
 // toBottom set's the pager's horizontal panning distance back to zero and
 // vertical panning distance to the last viewport page.
 func (p *Pager) ToBottom() {
-	_, viewHeight := termbox.Size()
+	viewHeight := p.PageRows()
 	p.scrollX = 0
 	p.scrollY = p.Pages() * viewHeight
 }
@@ -194,8 +448,7 @@ func (p *Pager) MaxScrollX() int {
 // maxScrollY represents the pager's maximum vertical scroll distance.
 func (p *Pager) MaxScrollY() int {
 	_, docHeight := p.Size()
-	_, viewHeight := termbox.Size()
-	return docHeight - viewHeight
+	return docHeight - p.PageRows()
 }
 
 // size returns the width and height of the pager's underlying cell buffer
@@ -209,6 +462,15 @@ func (p *Pager) Size() (int, int) {
 // document can be split into viewport sized pages.
 func (p *Pager) Pages() int {
 	_, docHeight := p.Size()
-	_, viewHeight := termbox.Size()
-	return docHeight / viewHeight
+	return docHeight / p.PageRows()
+}
+
+// PageRows returns the number of rows of the pager's cell buffer document
+// shown at a time, which are columns in vertical mode.
+func (p *Pager) PageRows() int {
+	width, height := termbox.Size()
+	if p.doc.Vertical {
+		return width / 2
+	}
+	return height
 }
@@ -0,0 +1,49 @@
+package nav
+
+import (
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Prompt is a single line of text input drawn at the bottom of the terminal,
+// like the search prompt in less.
+type Prompt struct {
+	Prefix string
+	input  []rune
+}
+
+// NewPrompt creates an empty prompt shown after prefix.
+func NewPrompt(prefix string) *Prompt {
+	return &Prompt{Prefix: prefix}
+}
+
+// Insert appends a character to the prompt's input.
+func (p *Prompt) Insert(ch rune) {
+	p.input = append(p.input, ch)
+}
+
+// Backspace removes the last character of the prompt's input.
+func (p *Prompt) Backspace() {
+	if len(p.input) > 0 {
+		p.input = p.input[:len(p.input)-1]
+	}
+}
+
+// Empty reports whether the prompt has no input.
+func (p *Prompt) Empty() bool {
+	return len(p.input) == 0
+}
+
+// String returns the prompt's input.
+func (p *Prompt) String() string {
+	return string(p.input)
+}
+
+// Draw displays the prompt on the last line of the terminal, over whatever is
+// currently on screen.
+func (p *Prompt) Draw() error {
+	width, height := termbox.Size()
+	line := p.Prefix + string(p.input)
+	drawText(0, height-1, width, line, termbox.ColorDefault, termbox.ColorDefault)
+	termbox.SetCursor(textWidth(line), height-1)
+	return termbox.Flush()
+}
@@ -0,0 +1,366 @@
+package parse
+
+import (
+	"strings"
+	"unicode"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// bidiClass is the bidirectional character type of a character, as in
+// UAX #9, without the explicit embedding and isolate types.
+type bidiClass uint8
+
+const (
+	bidiL   bidiClass = iota // left-to-right letters
+	bidiR                    // right-to-left letters, such as Hebrew
+	bidiAL                   // Arabic letters
+	bidiEN                   // European numbers
+	bidiAN                   // Arabic numbers
+	bidiES                   // number signs
+	bidiET                   // number terminators, such as currency signs
+	bidiCS                   // number separators
+	bidiNSM                  // combining marks
+	bidiWS                   // whitespace, and empty cells
+	bidiON                   // other neutrals
+)
+
+// mirrored maps the characters displayed mirrored in right-to-left text to
+// their mirror image.
+var mirrored = map[rune]rune{
+	'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{',
+	'<': '>', '>': '<', '«': '»', '»': '«', '‹': '›', '›': '‹',
+}
+
+// bidiClassOf returns the bidirectional type of a character.
+func bidiClassOf(r rune) bidiClass {
+	switch {
+	case r == 0 || unicode.IsSpace(r):
+		return bidiWS
+	case r >= '0' && r <= '9', r >= '۰' && r <= '۹':
+		return bidiEN
+	case r >= '٠' && r <= '٩', r == '٫', r == '٬':
+		return bidiAN
+	case unicode.In(r, unicode.Mn, unicode.Me):
+		return bidiNSM
+	case unicode.In(r, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Mandaic):
+		return bidiAL
+	case unicode.In(r, unicode.Hebrew, unicode.Nko, unicode.Samaritan):
+		return bidiR
+	case r == '+' || r == '-':
+		return bidiES
+	case r == '#' || r == '$' || r == '%' || r == '°' || r == '‰' || unicode.Is(unicode.Sc, r):
+		return bidiET
+	case r == ',' || r == '.' || r == ':' || r == '/' || r == '،':
+		return bidiCS
+	case unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r):
+		return bidiL
+	}
+	return bidiON
+}
+
+// strong returns the direction of a resolved type for neutrals next to it:
+// bidiL, or bidiR for right-to-left letters and numbers.
+func strong(t bidiClass) bidiClass {
+	if t == bidiL {
+		return bidiL
+	}
+	return bidiR
+}
+
+// bidiLevels returns the embedding level of each character of a line with
+// the given types, in a paragraph of the given direction, following rules W1
+// to I2 and L1 of UAX #9.
+func bidiLevels(classes []bidiClass, rtl bool) []int {
+	n := len(classes)
+	t := append([]bidiClass(nil), classes...)
+	e := bidiL
+	if rtl {
+		e = bidiR
+	}
+
+	// W1 to W3: combining marks take the type of the character before them,
+	// European numbers after Arabic letters are Arabic numbers, and Arabic
+	// letters are right-to-left.
+	prev, last := e, e
+	for i := range t {
+		if t[i] == bidiNSM {
+			t[i] = prev
+		}
+		prev = t[i]
+		switch t[i] {
+		case bidiL, bidiR, bidiAL:
+			last = t[i]
+		case bidiEN:
+			if last == bidiAL {
+				t[i] = bidiAN
+			}
+		}
+	}
+	for i := range t {
+		if t[i] == bidiAL {
+			t[i] = bidiR
+		}
+	}
+	// W4: a single separator between two numbers of the same type joins them.
+	for i := 1; i < n-1; i++ {
+		switch {
+		case t[i] == bidiES && t[i-1] == bidiEN && t[i+1] == bidiEN:
+			t[i] = bidiEN
+		case t[i] == bidiCS && t[i-1] == t[i+1] && (t[i-1] == bidiEN || t[i-1] == bidiAN):
+			t[i] = t[i-1]
+		}
+	}
+	// W5: terminators next to European numbers are part of them.
+	for i := 0; i < n; {
+		if t[i] != bidiET {
+			i++
+			continue
+		}
+		j := i
+		for j < n && t[j] == bidiET {
+			j++
+		}
+		if (i > 0 && t[i-1] == bidiEN) || (j < n && t[j] == bidiEN) {
+			for k := i; k < j; k++ {
+				t[k] = bidiEN
+			}
+		}
+		i = j
+	}
+	// W6 and W7: remaining separators are neutral, and European numbers
+	// after left-to-right text are left-to-right.
+	last = e
+	for i := range t {
+		switch t[i] {
+		case bidiES, bidiET, bidiCS:
+			t[i] = bidiON
+		case bidiL, bidiR:
+			last = t[i]
+		case bidiEN:
+			if last == bidiL {
+				t[i] = bidiL
+			}
+		}
+	}
+	// N1 and N2: neutrals between characters of the same direction take
+	// it, and other neutrals take the direction of the paragraph.
+	for i := 0; i < n; {
+		if t[i] != bidiWS && t[i] != bidiON {
+			i++
+			continue
+		}
+		j := i
+		for j < n && (t[j] == bidiWS || t[j] == bidiON) {
+			j++
+		}
+		before, after := e, e
+		if i > 0 {
+			before = strong(t[i-1])
+		}
+		if j < n {
+			after = strong(t[j])
+		}
+		dir := e
+		if before == after {
+			dir = before
+		}
+		for k := i; k < j; k++ {
+			t[k] = dir
+		}
+		i = j
+	}
+
+	// I1 and I2.
+	base := 0
+	if rtl {
+		base = 1
+	}
+	levels := make([]int, n)
+	for i := range t {
+		levels[i] = base
+		switch {
+		case base == 0 && t[i] == bidiR:
+			levels[i] = 1
+		case base == 0 && (t[i] == bidiEN || t[i] == bidiAN):
+			levels[i] = 2
+		case base == 1 && t[i] != bidiR:
+			levels[i] = 2
+		}
+	}
+	// L1: whitespace at the end of the line is at the paragraph level.
+	for i := n - 1; i >= 0 && classes[i] == bidiWS; i-- {
+		levels[i] = base
+	}
+	return levels
+}
+
+// visualOrder returns the indices of characters with the given levels in
+// display order, reversing each run at or above every odd level (rule L2).
+func visualOrder(levels []int) []int {
+	order := make([]int, len(levels))
+	highest, lowestOdd := 0, -1
+	for i, l := range levels {
+		order[i] = i
+		if l > highest {
+			highest = l
+		}
+		if l%2 == 1 && (lowestOdd < 0 || l < lowestOdd) {
+			lowestOdd = l
+		}
+	}
+	if lowestOdd < 0 {
+		return order
+	}
+	for level := highest; level >= lowestOdd; level-- {
+		for i := 0; i < len(order); {
+			if levels[order[i]] < level {
+				i++
+				continue
+			}
+			j := i
+			for j < len(order) && levels[order[j]] >= level {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				order[a], order[b] = order[b], order[a]
+			}
+			i = j
+		}
+	}
+	return order
+}
+
+// bidiUnit is a character of a row being reordered: the cell at x, and the
+// continuation cell after it if the character is double-width.
+type bidiUnit struct {
+	x, width int
+}
+
+// reorderRow displays the text of a row in visual order, following the
+// Unicode bidirectional algorithm. Rows of right-to-left paragraphs are
+// reordered between lmargin and the right edge, which also aligns them to the
+// right; unless whole is false, when only the text between the first and last
+// non-empty cell is reordered. Left-to-right rows without right-to-left text
+// are left as they are.
+func (c *Cellbuf) reorderRow(row, lmargin int, rtl, whole bool) {
+	start := row * c.Width
+	lo, hi := -1, -1
+	rtlText := false
+	for x := lmargin; x < c.Width && start+x < len(c.Cells); x++ {
+		if ch := c.Cells[start+x].Ch; ch != 0 {
+			if lo < 0 {
+				lo = x
+			}
+			hi = x + 1
+			if t := bidiClassOf(ch); t == bidiR || t == bidiAL || t == bidiAN {
+				rtlText = true
+			}
+		}
+	}
+	if lo < 0 || !rtl && !rtlText {
+		return
+	}
+	if rtl && whole {
+		lo, hi = lmargin, c.Width
+	}
+	for start+hi > len(c.Cells) {
+		c.Cells = append(c.Cells, make([]termbox.Cell, 1024)...)
+	}
+
+	var units []bidiUnit
+	var classes []bidiClass
+	for x := lo; x < hi; {
+		u := bidiUnit{x, 1}
+		if x+1 < hi && c.Cells[start+x+1].Ch == Continuation {
+			u.width = 2
+		}
+		units = append(units, u)
+		classes = append(classes, bidiClassOf(c.Cells[start+x].Ch))
+		x += u.width
+	}
+	levels := bidiLevels(classes, rtl)
+
+	cells := append([]termbox.Cell(nil), c.Cells[start+lo:start+hi]...)
+	moved := make(map[int]int) // new column of each unit's column
+	x := lo
+	for _, i := range visualOrder(levels) {
+		u := units[i]
+		moved[u.x] = x
+		for k := 0; k < u.width; k++ {
+			c.Cells[start+x+k] = cells[u.x-lo+k]
+		}
+		if m, ok := mirrored[cells[u.x-lo].Ch]; ok && levels[i]%2 == 1 {
+			c.Cells[start+x].Ch = m
+		}
+		x += u.width
+	}
+	c.moveImages(row, func(im Image) int {
+		// The spaces reserved for an image end up reversed.
+		col := -1
+		for x := im.Col; x < im.Col+im.Width; x++ {
+			if to, ok := moved[x]; ok && (col < 0 || to < col) {
+				col = to
+			}
+		}
+		if col < 0 {
+			return im.Col
+		}
+		return col
+	})
+
+	// Rows are reordered when their block ends, so their characters are
+	// among the last ones laid out.
+	for j := len(c.textCells) - 1; j >= 0 && c.textCells[j] >= start; j-- {
+		if i := c.textCells[j]; i < start+c.Width {
+			if to, ok := moved[i-start]; ok {
+				c.textCells[j] = start + to
+			}
+		}
+	}
+	c.unordered = true
+}
+
+// firstStrong returns the direction of the first letter in the rows first to
+// last, bidiR for right-to-left letters, or bidiL if there is none or it is
+// left-to-right.
+func (c *Cellbuf) firstStrong(first, last int) bidiClass {
+	for i := first * c.Width; i < (last+1)*c.Width && i < len(c.Cells); i++ {
+		switch bidiClassOf(c.Cells[i].Ch) {
+		case bidiL:
+			return bidiL
+		case bidiR, bidiAL:
+			return bidiR
+		}
+	}
+	return bidiL
+}
+
+// direction returns the base direction of the innermost open element: "ltr",
+// "rtl" or "auto", from the CSS direction or dir attribute of the nearest
+// element that sets one. Other text in books whose pages progress from right
+// to left, which include books in Japanese, takes the direction of its first
+// letter.
+func (p *parser) direction() string {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		if d := p.stack[i].css.direction; d != "" {
+			return d
+		}
+		if d := p.stack[i].dir; d != "" {
+			return d
+		}
+	}
+	if p.baseDir == "rtl" {
+		return "auto"
+	}
+	return "ltr"
+}
+
+// parseDir returns the value of a dir attribute, or "" if it is not valid.
+func parseDir(v string) string {
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "ltr", "rtl", "auto":
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVisualOrder(t *testing.T) {
+	tests := []struct {
+		text string
+		rtl  bool
+		want string
+	}{
+		{"abc def", false, "abc def"},
+		{"abc שלום עולם def", false, "abc םלוע םולש def"},
+		{"שלום abc", true, "abc םולש"},
+		{"עמוד 12-14 (ראה)", true, "(האר) 12-14 דומע"},
+		{"مرحبا 123", false, "123 ابحرم"},
+	}
+	for _, test := range tests {
+		runes := []rune(test.text)
+		classes := make([]bidiClass, len(runes))
+		for i, r := range runes {
+			classes[i] = bidiClassOf(r)
+		}
+		levels := bidiLevels(classes, test.rtl)
+		var b strings.Builder
+		for _, i := range visualOrder(levels) {
+			r := runes[i]
+			if m, ok := mirrored[r]; ok && levels[i]%2 == 1 {
+				r = m
+			}
+			b.WriteRune(r)
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("Expected: %q, but got: %q\n", test.want, got)
+		}
+	}
+}
+
+func TestRightToLeft(t *testing.T) {
+	tests := []struct {
+		text string
+		opt  Option
+		want string
+	}{
+		{
+			`<p dir="rtl">שלום עולם</p>`,
+			Option{Width: 16},
+			"     םלוע םולש",
+		},
+		{
+			// Paragraphs of books read from right to left take the
+			// direction of their first letter, unless they set their own.
+			`<p>שלום עולם</p><p>日本語</p><p dir="ltr">abc</p>`,
+			Option{Width: 16, Direction: "rtl"},
+			"     םלוע םולש\n\n  日本語\n\n  abc",
+		},
+		{
+			`<div dir="auto">שלום world</div><div dir="auto">hello שלום</div>`,
+			Option{Width: 16},
+			"      world םולש\n\nhello םולש",
+		},
+		{
+			`<p dir="rtl" style="text-align: left; text-indent: 0">שלום עולם</p>`,
+			Option{Width: 16},
+			"םלוע םולש",
+		},
+	}
+	for _, test := range tests {
+		doc, err := ParseText(strings.NewReader(test.text), nil, test.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := layout(&doc); got != test.want {
+			t.Errorf("Expected:\n%s\nbut got:\n%s\n", test.want, got)
+		}
+	}
+}
+
+func TestRightToLeftOffsets(t *testing.T) {
+	const text = `<p dir="rtl">אבג דהו זחט יכל</p>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := layout(&doc), "   והד גבא\n     לכי טחז"; got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+	// Offsets follow the logical order of the text, from right to left.
+	if doc.TextCell(1) != doc.TextCell(0)-1 {
+		t.Errorf("Expected: %v, but got: %v\n", doc.TextCell(0)-1, doc.TextCell(1))
+	}
+	if got, want := doc.Text(0, doc.TextLen()), "אבג דהו זחט יכל"; got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+	regions := doc.TextRegions(0, 6)
+	if len(regions) != 1 || regions[0].End != doc.TextCell(0)+1 || regions[0].Start != doc.TextCell(5) {
+		t.Errorf("Expected: %v, but got: %v\n", "one region from the sixth to the first character", regions)
+	}
+}
@@ -0,0 +1,628 @@
+package parse
+
+import (
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/epub"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The parser supports the subset of CSS that can be rendered in a terminal:
+// font-weight, font-style, text-decoration, text-transform, text-align,
+// text-indent, margin-left, display, list-style-type and color. Selectors
+// are combinations of element names, classes and ids, with descendant and
+// child combinators. Rules with other selectors and at-rules such as @media
+// are ignored.
+
+// emPerColumn is the width of a column in em, used to convert lengths to
+// columns. A character cell is about half as wide as it is high.
+const emPerColumn = 0.5
+
+// tristate is a boolean CSS property, which may be left unset.
+type tristate int8
+
+const (
+	unset tristate = iota
+	on
+	off
+)
+
+// cssStyle holds the supported properties set on an element.
+type cssStyle struct {
+	bold, italic, underline tristate
+	color                   termbox.Attribute // termbox.ColorDefault if unset
+	display                 string            // "block", "inline", "none" or unset
+	align                   string            // "left", "center", "right", "justify" or unset
+	transform               string            // "uppercase", "lowercase", "capitalize", "none" or unset
+	margin                  int               // left margin in columns
+	indent                  int               // first line indent in columns
+	hasIndent               bool
+	listType                string // list-style-type, or unset
+	direction               string // "ltr", "rtl" or unset
+	writingMode             string // writing-mode, or unset
+}
+
+// cssDecl is a property declaration.
+type cssDecl struct {
+	property, value string
+	important       bool
+}
+
+// cssCompound is a selector for a single element, such as p.note#first.
+// combinator relates it to the compound before it in a selector: ' ' for a
+// descendant, '>' for a child.
+type cssCompound struct {
+	tag        string
+	id         string
+	classes    []string
+	combinator byte
+}
+
+// cssRule is a rule with a single selector. Rules with selector lists are
+// split into one rule per selector.
+type cssRule struct {
+	selector    []cssCompound
+	specificity int
+	order       int
+	decls       []cssDecl
+}
+
+// stylesheet holds the rules of the style sheets of a document, in the order
+// they were read.
+type stylesheet struct {
+	rules []cssRule
+}
+
+// element is an open HTML element, with the state needed to lay out its
+// content and restore the layout at its end.
+type element struct {
+	atom    atom.Atom
+	tag     string
+	id      string
+	classes []string
+	css     cssStyle
+
+	block    bool // starts a new paragraph
+	hidden   bool // not displayed, because of display: none on it or a parent
+	lmargin  int  // left margin of the enclosing element
+	startRow int  // first row of a block
+
+	markerType string // list-style-type of the items of a list
+	counter    int    // number of the last item of a list
+
+	gutter   int    // column of the bar of a blockquote or aside
+	noteType string // "footnote", "endnote" or "noteref"
+
+	href      string // target of a link
+	linkStart int    // text offset of the start of a link
+
+	dir string // the dir attribute: "ltr", "rtl", "auto" or unset
+}
+
+// newElement returns the element of a start tag, styled by the rules of s and
+// its style attribute. parents are the enclosing elements, from outermost to
+// innermost.
+func (s *stylesheet) newElement(token html.Token, parents []*element, width int) *element {
+	e := &element{atom: token.DataAtom, tag: strings.ToLower(token.Data)}
+	var inline []cssDecl
+	for _, a := range token.Attr {
+		switch a.Key {
+		case "id":
+			e.id = a.Val
+		case "class":
+			e.classes = strings.Fields(a.Val)
+		case "style":
+			inline = parseDeclarations(a.Val)
+		case "dir":
+			e.dir = parseDir(a.Val)
+		}
+	}
+
+	var decls, important []cssDecl
+	for _, r := range s.matching(e, parents) {
+		for _, d := range r.decls {
+			if d.important {
+				important = append(important, d)
+			} else {
+				decls = append(decls, d)
+			}
+		}
+	}
+	decls = append(decls, inline...)
+	decls = append(decls, important...)
+	for _, d := range decls {
+		e.css.set(d, width)
+	}
+	return e
+}
+
+// matching returns the rules matching e within parents, in the order their
+// declarations apply.
+func (s *stylesheet) matching(e *element, parents []*element) []cssRule {
+	var matched []cssRule
+	for _, r := range s.rules {
+		if r.matches(e, parents) {
+			matched = append(matched, r)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].specificity != matched[j].specificity {
+			return matched[i].specificity < matched[j].specificity
+		}
+		return matched[i].order < matched[j].order
+	})
+	return matched
+}
+
+// matches reports whether the rule's selector matches e within parents.
+func (r *cssRule) matches(e *element, parents []*element) bool {
+	last := len(r.selector) - 1
+	if !r.selector[last].matches(e) {
+		return false
+	}
+	i := len(parents) - 1
+	for n := last; n > 0; n-- {
+		child := r.selector[n]
+		compound := r.selector[n-1]
+		if child.combinator == '>' {
+			if i < 0 || !compound.matches(parents[i]) {
+				return false
+			}
+			i--
+			continue
+		}
+		for ; i >= 0 && !compound.matches(parents[i]); i-- {
+		}
+		if i < 0 {
+			return false
+		}
+		i--
+	}
+	return true
+}
+
+func (c *cssCompound) matches(e *element) bool {
+	if c.tag != "" && c.tag != "*" && c.tag != e.tag {
+		return false
+	}
+	if c.id != "" && c.id != e.id {
+		return false
+	}
+	for _, class := range c.classes {
+		found := false
+		for _, ec := range e.classes {
+			if ec == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parse adds the rules of a style sheet.
+func (s *stylesheet) parse(css string) {
+	css = stripCSSComments(css)
+	for {
+		open := strings.IndexByte(css, '{')
+		if open < 0 {
+			return
+		}
+		prelude := strings.TrimSpace(css[:open])
+		end := matchingBrace(css, open)
+		body := css[open+1 : end]
+		if end < len(css) {
+			end++
+		}
+		css = css[end:]
+
+		if strings.HasPrefix(prelude, "@") {
+			// Statement at-rules such as @import end at a semicolon, before
+			// the block of the next rule.
+			if i := strings.LastIndexByte(prelude, ';'); i >= 0 {
+				prelude = strings.TrimSpace(prelude[i+1:])
+			}
+			if prelude == "" || strings.HasPrefix(prelude, "@") {
+				continue
+			}
+		}
+
+		decls := parseDeclarations(body)
+		for _, sel := range strings.Split(prelude, ",") {
+			selector, specificity, ok := parseSelector(sel)
+			if !ok {
+				continue
+			}
+			s.rules = append(s.rules, cssRule{
+				selector:    selector,
+				specificity: specificity,
+				order:       len(s.rules),
+				decls:       decls,
+			})
+		}
+	}
+}
+
+// load adds the rules of the style sheet in a manifest item.
+func (s *stylesheet) load(item epub.Item) {
+	r, err := item.Open()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	s.parse(string(b))
+}
+
+// findItem returns the manifest item that href, relative to the document,
+// refers to. Since the location of the document is not known, an item whose
+// path ends with href is taken to be the one.
+func findItem(items []epub.Item, href string) (epub.Item, bool) {
+	if i := strings.IndexAny(href, "#?"); i >= 0 {
+		href = href[:i]
+	}
+	href = path.Clean(href)
+	for strings.HasPrefix(href, "../") {
+		href = href[len("../"):]
+	}
+	for _, item := range items {
+		if item.HREF == href || strings.HasSuffix(item.HREF, "/"+href) {
+			return item, true
+		}
+	}
+	return epub.Item{}, false
+}
+
+// stripCSSComments removes /* */ comments from css.
+func stripCSSComments(css string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			break
+		}
+		b.WriteString(css[:start])
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return b.String()
+		}
+		css = css[start+2+end+2:]
+	}
+	b.WriteString(css)
+	return b.String()
+}
+
+// matchingBrace returns the index of the brace closing the block opened at
+// css[open], or len(css) if it is not closed.
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+// parseDeclarations parses the declarations of a rule or style attribute.
+func parseDeclarations(s string) []cssDecl {
+	var decls []cssDecl
+	for _, d := range strings.Split(s, ";") {
+		property, value, ok := strings.Cut(d, ":")
+		if !ok {
+			continue
+		}
+		decl := cssDecl{
+			property: strings.ToLower(strings.TrimSpace(property)),
+			value:    strings.ToLower(strings.TrimSpace(value)),
+		}
+		if v, ok := strings.CutSuffix(decl.value, "!important"); ok {
+			decl.value = strings.TrimSpace(v)
+			decl.important = true
+		}
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// parseSelector parses a selector and returns its specificity. It reports
+// false for selectors that are not supported.
+func parseSelector(s string) ([]cssCompound, int, bool) {
+	s = strings.ReplaceAll(s, ">", " > ")
+	var selector []cssCompound
+	specificity := 0
+	combinator := byte(' ')
+	for _, field := range strings.Fields(s) {
+		if field == ">" {
+			if len(selector) == 0 || combinator == '>' {
+				return nil, 0, false
+			}
+			combinator = '>'
+			continue
+		}
+		if strings.ContainsAny(field, ":[+~") {
+			return nil, 0, false
+		}
+
+		c := cssCompound{combinator: combinator}
+		combinator = ' '
+		for len(field) > 0 {
+			prefix := field[0]
+			if prefix == '.' || prefix == '#' {
+				field = field[1:]
+			}
+			end := strings.IndexAny(field, ".#")
+			if end < 0 {
+				end = len(field)
+			}
+			name := field[:end]
+			field = field[end:]
+			if name == "" {
+				return nil, 0, false
+			}
+			switch prefix {
+			case '.':
+				c.classes = append(c.classes, name)
+				specificity += 10
+			case '#':
+				c.id = name
+				specificity += 100
+			default:
+				c.tag = strings.ToLower(name)
+				if c.tag != "*" {
+					specificity++
+				}
+			}
+		}
+		selector = append(selector, c)
+	}
+	if len(selector) == 0 || combinator == '>' {
+		return nil, 0, false
+	}
+	return selector, specificity, true
+}
+
+// set applies a declaration to the style. Unsupported properties and values
+// are ignored.
+func (s *cssStyle) set(d cssDecl, width int) {
+	switch d.property {
+	case "font-weight":
+		s.bold = parseWeight(d.value)
+	case "font-style":
+		s.italic = parseFontStyle(d.value)
+	case "font":
+		for _, v := range strings.Fields(d.value) {
+			if b := parseWeight(v); b != unset {
+				s.bold = b
+			} else if i := parseFontStyle(v); i != unset {
+				s.italic = i
+			}
+		}
+	case "text-decoration", "text-decoration-line":
+		for _, v := range strings.Fields(d.value) {
+			switch v {
+			case "underline":
+				s.underline = on
+			case "none":
+				s.underline = off
+			}
+		}
+	case "text-transform":
+		switch d.value {
+		case "uppercase", "lowercase", "capitalize", "none":
+			s.transform = d.value
+		}
+	case "text-align":
+		switch d.value {
+		case "left", "right", "center", "justify", "start", "end":
+			s.align = d.value
+		}
+	case "writing-mode", "-epub-writing-mode", "-webkit-writing-mode":
+		s.writingMode = d.value
+	case "direction":
+		switch d.value {
+		case "ltr", "rtl":
+			s.direction = d.value
+		}
+	case "display":
+		switch d.value {
+		case "none", "inline":
+			s.display = d.value
+		case "block", "list-item", "table", "flex", "grid":
+			s.display = "block"
+		}
+	case "margin-left":
+		if n, ok := parseLength(d.value, width); ok {
+			s.margin = n
+		}
+	case "margin":
+		// The left margin is the last of up to four values.
+		fields := strings.Fields(d.value)
+		if len(fields) == 0 {
+			return
+		}
+		left := fields[len(fields)-1]
+		if len(fields) == 3 {
+			left = fields[1]
+		}
+		if n, ok := parseLength(left, width); ok {
+			s.margin = n
+		}
+	case "text-indent":
+		if n, ok := parseLength(d.value, width); ok {
+			s.indent, s.hasIndent = n, true
+		}
+	case "list-style-type", "list-style":
+		for _, v := range strings.Fields(d.value) {
+			if _, ok := bullets[v]; ok || v == "none" || v == "decimal" ||
+				v == "lower-alpha" || v == "upper-alpha" || v == "lower-roman" || v == "upper-roman" {
+				s.listType = v
+			}
+		}
+	case "color":
+		if c, ok := parseCSSColor(d.value); ok {
+			s.color = c
+		}
+	}
+}
+
+func parseWeight(v string) tristate {
+	switch v {
+	case "bold", "bolder":
+		return on
+	case "normal", "lighter":
+		return off
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		if n >= 600 {
+			return on
+		}
+		return off
+	}
+	return unset
+}
+
+func parseFontStyle(v string) tristate {
+	switch v {
+	case "italic", "oblique":
+		return on
+	case "normal":
+		return off
+	}
+	return unset
+}
+
+// parseLength converts a length to columns, for a layout width columns wide.
+// Negative lengths are taken as zero and "auto" is not supported.
+func parseLength(v string, width int) (int, bool) {
+	units := []struct {
+		suffix  string
+		columns float64
+	}{
+		{"rem", 1 / emPerColumn},
+		{"em", 1 / emPerColumn},
+		{"ex", 1},
+		{"ch", 1},
+		{"px", 1.0 / 8},
+		{"pt", 1.0 / 6},
+		{"%", float64(width) / 100},
+	}
+	n, columns := v, 0.0
+	for _, u := range units {
+		if s, ok := strings.CutSuffix(v, u.suffix); ok {
+			n, columns = s, u.columns
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil || (columns == 0 && f != 0) {
+		return 0, false
+	}
+	if f < 0 {
+		return 0, true
+	}
+	return int(math.Round(f * columns)), true
+}
+
+// cssColors are the CSS named colors, as RGB values.
+var cssColors = map[string]int{
+	"black":   0x000000,
+	"silver":  0xc0c0c0,
+	"gray":    0x808080,
+	"grey":    0x808080,
+	"white":   0xffffff,
+	"maroon":  0x800000,
+	"red":     0xff0000,
+	"purple":  0x800080,
+	"fuchsia": 0xff00ff,
+	"magenta": 0xff00ff,
+	"green":   0x008000,
+	"lime":    0x00ff00,
+	"olive":   0x808000,
+	"yellow":  0xffff00,
+	"navy":    0x000080,
+	"blue":    0x0000ff,
+	"teal":    0x008080,
+	"aqua":    0x00ffff,
+	"cyan":    0x00ffff,
+	"orange":  0xffa500,
+	"brown":   0xa52a2a,
+}
+
+// parseCSSColor parses a named, #rgb, #rrggbb or rgb() color to a color of the
+// 256 color palette.
+func parseCSSColor(v string) (termbox.Attribute, bool) {
+	rgb, ok := cssColors[v]
+	switch {
+	case ok:
+	case strings.HasPrefix(v, "#") && len(v) == 4:
+		n, err := strconv.ParseUint(v[1:], 16, 16)
+		if err != nil {
+			return 0, false
+		}
+		r, g, b := int(n>>8), int(n>>4&0xf), int(n&0xf)
+		rgb = (r*17)<<16 | (g*17)<<8 | b*17
+	case strings.HasPrefix(v, "#") && len(v) == 7:
+		n, err := strconv.ParseUint(v[1:], 16, 32)
+		if err != nil {
+			return 0, false
+		}
+		rgb = int(n)
+	case strings.HasPrefix(v, "rgb(") && strings.HasSuffix(v, ")"):
+		parts := strings.Split(v[len("rgb("):len(v)-1], ",")
+		if len(parts) != 3 {
+			return 0, false
+		}
+		for _, part := range parts {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || n < 0 || n > 255 {
+				return 0, false
+			}
+			rgb = rgb<<8 | n
+		}
+	default:
+		return 0, false
+	}
+	return color256(rgbTo256(rgb>>16, rgb>>8&0xff, rgb&0xff)), true
+}
+
+// transform applies a text-transform value to text. Characters are mapped one
+// to one, so that text offsets are not changed. start reports whether text
+// starts a word, for capitalize.
+func transform(text, value string, start bool) string {
+	switch value {
+	case "uppercase":
+		return strings.Map(unicode.ToUpper, text)
+	case "lowercase":
+		return strings.Map(unicode.ToLower, text)
+	case "capitalize":
+		runes := []rune(text)
+		for i, r := range runes {
+			if start && !unicode.IsSpace(r) {
+				runes[i] = unicode.ToUpper(r)
+			}
+			start = unicode.IsSpace(r)
+		}
+		return string(runes)
+	}
+	return text
+}
@@ -0,0 +1,143 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector    string
+		specificity int
+		ok          bool
+	}{
+		{"p", 1, true},
+		{"span.italic", 11, true},
+		{"#note", 100, true},
+		{"div.chapter > p.first", 22, true},
+		{"body .poem span", 12, true},
+		{"*", 0, true},
+		{"a:hover", 0, false},
+		{"h1 + p", 0, false},
+		{"p >", 0, false},
+	}
+
+	for _, test := range tests {
+		_, specificity, ok := parseSelector(test.selector)
+		if ok != test.ok || specificity != test.specificity {
+			t.Errorf("Expected: %v %v, but got: %v %v\n", test.specificity, test.ok, specificity, ok)
+		}
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	tests := []struct {
+		value   string
+		columns int
+		ok      bool
+	}{
+		{"0", 0, true},
+		{"2em", 4, true},
+		{"1.25em", 3, true},
+		{"10%", 8, true},
+		{"16px", 2, true},
+		{"-1em", 0, true},
+		{"auto", 0, false},
+		{"3", 0, false},
+	}
+
+	for _, test := range tests {
+		columns, ok := parseLength(test.value, 80)
+		if ok != test.ok || columns != test.columns {
+			t.Errorf("Expected: %v %v, but got: %v %v\n", test.columns, test.ok, columns, ok)
+		}
+	}
+}
+
+func TestCSS(t *testing.T) {
+	const text = `<html><head><style>
+/* emphasis by class */
+.italic { font-style: italic }
+span.bold, .strong { font-weight: 700 }
+.plain i { font-style: normal }
+#hidden { display: none }
+div.poem > p { margin-left: 2em; text-indent: 0 }
+@media print { .italic { font-style: normal } }
+h1 { text-align: center; text-transform: uppercase }
+.right { text-align: right !important }
+</style></head><body>
+<h1>Title</h1>
+<p>a <span class="italic">x</span> <span class="bold">y</span> <span style="text-decoration: underline">z</span></p>
+<p class="plain">e <i>f</i></p>
+<p>g <span id="hidden">hidden</span> h</p>
+<div class="poem"><p>verse</p></div>
+<p class="right" style="text-align: left">end</p>
+</body></html>`
+
+	theme, _ := LookupTheme("monochrome")
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 20, Theme: theme})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{}
+	for row := 0; row < doc.Rows(); row++ {
+		if line := doc.Line(row); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	want := []string{"TITLE", "a x y z", "e f", "g h", "verse", "end"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Fatalf("Expected: %q, but got: %q\n", want, lines)
+	}
+
+	styles := map[rune]termbox.Attribute{
+		'a': termbox.ColorDefault,
+		'x': termbox.AttrCursive,
+		'y': termbox.AttrBold,
+		'z': termbox.AttrUnderline,
+		'f': termbox.ColorDefault,
+	}
+	columns := map[string]int{
+		"TITLE": (20 - len("TITLE")) / 2,
+		"verse": 4,
+		"end":   20 - len("end"),
+	}
+	for row := 0; row < doc.Rows(); row++ {
+		for x := 0; x < doc.Width && row*doc.Width+x < len(doc.Cells); x++ {
+			c := doc.Cells[row*doc.Width+x]
+			if want, ok := styles[c.Ch]; ok && c.Fg != want {
+				t.Errorf("Expected %q: %v, but got: %v\n", c.Ch, want, c.Fg)
+			}
+		}
+		line := doc.Line(row)
+		if want, ok := columns[line]; ok && doc.Cells[row*doc.Width+want].Ch != rune(line[0]) {
+			t.Errorf("Expected %q at column %v\n", line, want)
+		}
+	}
+
+	// Hidden text keeps its text offsets.
+	if got := doc.Text(len("Titleaxyzefghidden"), len("Titleaxyzefghiddenh")); got != "h" {
+		t.Errorf("Expected: %q, but got: %q\n", "h", got)
+	}
+}
+
+func TestBookColors(t *testing.T) {
+	const text = `<p style="color: #ff0000">red</p>`
+	for _, name := range []string{"default", "light"} {
+		theme, _ := LookupTheme(name)
+		doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 20, Theme: theme})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := termbox.ColorDefault
+		if theme.BookColors {
+			want = color256(196)
+		}
+		if got := doc.Cells[doc.TextCell(0)].Fg &^ termbox.AttrBold; got != want {
+			t.Errorf("Expected: %v, but got: %v\n", want, got)
+		}
+	}
+}
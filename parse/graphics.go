@@ -0,0 +1,299 @@
+package parse
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/nfnt/resize"
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Image modes of terminals that show images themselves. Space is reserved
+// for the images in the Cellbuf, and the pager shows them over it.
+const (
+	// ImageSixel shows images as sixels, which xterm, mlterm, foot and
+	// others support.
+	ImageSixel = "sixel"
+	// ImageKitty shows images with the kitty graphics protocol.
+	ImageKitty = "kitty"
+	// ImageITerm shows images with the inline images protocol of iTerm2,
+	// which WezTerm also supports.
+	ImageITerm = "iterm"
+)
+
+// defaultCellWidth is the width of a terminal cell in pixels assumed when
+// none is given.
+const defaultCellWidth = 10
+
+// KittyClear deletes the images shown with the kitty graphics protocol,
+// which are not erased by text drawn over them.
+const KittyClear = "\x1b_Ga=d,q=2\x1b\\"
+
+// Image is an image shown with a terminal graphics protocol, over the blank
+// cells reserved for it from row Row and column Col.
+type Image struct {
+	Row, Col      int
+	Width, Height int // in cells
+	Image         image.Image
+}
+
+// Graphics reports whether images are shown with a terminal graphics
+// protocol rather than drawn with characters.
+func (o ImageOption) Graphics() bool {
+	switch o.Mode {
+	case ImageSixel, ImageKitty, ImageITerm:
+		return true
+	}
+	return false
+}
+
+// cellSize returns the width and height of a cell in pixels.
+func (o ImageOption) cellSize() (w, h int) {
+	w = o.CellWidth
+	if w <= 0 {
+		w = defaultCellWidth
+	}
+	ratio := o.CellRatio
+	if ratio <= 0 {
+		ratio = defaultCellRatio
+	}
+	return w, int(math.Round(float64(w) * ratio))
+}
+
+// DetectGraphics returns the graphics protocol the terminal supports, from
+// the environment variables that getenv looks up, or "" if it supports none
+// that is known.
+func DetectGraphics(getenv func(string) string) string {
+	term, program := getenv("TERM"), getenv("TERM_PROGRAM")
+	switch {
+	case term == "xterm-kitty", getenv("KITTY_WINDOW_ID") != "",
+		term == "xterm-ghostty", program == "ghostty":
+		return ImageKitty
+	case program == "iTerm.app", program == "WezTerm":
+		return ImageITerm
+	case strings.Contains(term, "sixel"), strings.HasPrefix(term, "mlterm"),
+		strings.HasPrefix(term, "foot"), strings.HasPrefix(term, "yaft"):
+		return ImageSixel
+	}
+	return ""
+}
+
+// appendGraphic reserves rows for an image shown with a terminal graphics
+// protocol, at most w columns wide, from the left margin. Images are not
+// enlarged past their size in pixels.
+func (c *Cellbuf) appendGraphic(img image.Image, w int, opt ImageOption) {
+	cw, _ := opt.cellSize()
+	dx, dy := img.Bounds().Dx(), img.Bounds().Dy()
+	if dx <= 0 || dy <= 0 || w <= 0 {
+		return
+	}
+	if cols := (dx + cw - 1) / cw; cols < w {
+		w = cols
+	}
+	ratio := opt.CellRatio
+	if ratio <= 0 {
+		ratio = defaultCellRatio
+	}
+	h := int(math.Round(float64(dy) * float64(w) / (float64(dx) * ratio)))
+	if h < 1 {
+		h = 1
+	}
+
+	// The reserved cells hold spaces, so that their rows are not taken as
+	// blank.
+	rows := make([][]termbox.Cell, h)
+	for y := range rows {
+		rows[y] = make([]termbox.Cell, w)
+		for x := range rows[y] {
+			rows[y][x].Ch = ' '
+		}
+	}
+	if c.col > c.lmargin {
+		c.row++
+		c.col = c.lmargin
+	}
+	c.Images = append(c.Images, Image{Row: c.row, Col: c.lmargin, Width: w, Height: h, Image: img})
+	c.appendImage(rows)
+}
+
+// moveImages moves the images whose top is in a row to the columns to
+// returns for them. The rows below it are moved the same way.
+func (c *Cellbuf) moveImages(row int, to func(im Image) int) {
+	for i, im := range c.Images {
+		if im.Row == row {
+			c.Images[i].Col = to(im)
+		}
+	}
+}
+
+// Escape returns the escape sequence that shows rows first to first+rows-1 of
+// an image, in the mode of opt, at the cursor.
+func (im Image) Escape(opt ImageOption, first, rows int) string {
+	if first < 0 || rows <= 0 || first+rows > im.Height {
+		return ""
+	}
+	img := im.Image
+	b := img.Bounds()
+	if first > 0 || rows < im.Height {
+		top := b.Min.Y + first*b.Dy()/im.Height
+		bottom := b.Min.Y + (first+rows)*b.Dy()/im.Height
+		img = crop(img, image.Rect(b.Min.X, top, b.Max.X, bottom))
+	}
+	switch opt.Mode {
+	case ImageSixel:
+		cw, ch := opt.cellSize()
+		return sixel(img, im.Width*cw, rows*ch)
+	case ImageKitty:
+		return kitty(img, im.Width, rows)
+	case ImageITerm:
+		return iterm(img, im.Width, rows)
+	}
+	return ""
+}
+
+// crop returns the part r of an image.
+func crop(img image.Image, r image.Rectangle) image.Image {
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(out, out.Bounds(), img, r.Min, draw.Src)
+	return out
+}
+
+// encodePNG returns an image encoded as PNG.
+func encodePNG(img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// kittyChunk is the most base64 data sent in one kitty graphics command.
+const kittyChunk = 4096
+
+// kitty returns the kitty graphics command that shows an image scaled to
+// cols×rows cells, without moving the cursor.
+func kitty(img image.Image, cols, rows int) string {
+	data := base64.StdEncoding.EncodeToString(encodePNG(img))
+	var b strings.Builder
+	for first := true; first || data != ""; first = false {
+		chunk := data
+		if len(chunk) > kittyChunk {
+			chunk = chunk[:kittyChunk]
+		}
+		data = data[len(chunk):]
+		more := 0
+		if data != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,c=%d,r=%d,C=1,q=2,m=%d;%s\x1b\\", cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return b.String()
+}
+
+// iterm returns the iTerm2 escape sequence that shows an image scaled to
+// cols×rows cells.
+func iterm(img image.Image, cols, rows int) string {
+	data := encodePNG(img)
+	return fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=0:%s\a",
+		len(data), cols, rows, base64.StdEncoding.EncodeToString(data))
+}
+
+// sixel returns an image scaled to w×h pixels as sixels, in the colors of a
+// 6×6×6 color cube.
+func sixel(img image.Image, w, h int) string {
+	img = resize.Resize(uint(w), uint(h), img, resize.Lanczos3)
+	colors := rgbs(img, w, h)
+
+	// The color of each pixel, as an index in the cube.
+	index := make([][]int, h)
+	used := make(map[int]bool)
+	for y := range index {
+		index[y] = make([]int, w)
+		for x, c := range colors[y] {
+			i := 36*nearestLevel(c[0]) + 6*nearestLevel(c[1]) + nearestLevel(c[2])
+			index[y][x] = i
+			used[i] = true
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1bPq\"1;1;%d;%d", w, h)
+	palette := make([]int, 0, len(used))
+	for i := range used {
+		palette = append(palette, i)
+	}
+	sort.Ints(palette)
+	for _, i := range palette {
+		// Sixel colors are given in percent.
+		r, g, bl := paletteRGB(16 + i)
+		fmt.Fprintf(&b, "#%d;2;%d;%d;%d", i, int(r)*100/255, int(g)*100/255, int(bl)*100/255)
+	}
+
+	// Each band of six rows is drawn once for each of its colors, with a
+	// character for each column whose bits are the rows of that color.
+	for top := 0; top < h; top += 6 {
+		if top > 0 {
+			b.WriteByte('-')
+		}
+		var bandColors []int
+		seen := make(map[int]bool)
+		for y := top; y < top+6 && y < h; y++ {
+			for _, i := range index[y] {
+				if !seen[i] {
+					seen[i] = true
+					bandColors = append(bandColors, i)
+				}
+			}
+		}
+		sort.Ints(bandColors)
+		for n, i := range bandColors {
+			if n > 0 {
+				b.WriteByte('$')
+			}
+			fmt.Fprintf(&b, "#%d", i)
+			line := make([]byte, w)
+			for x := range line {
+				var bits byte
+				for y := top; y < top+6 && y < h; y++ {
+					if index[y][x] == i {
+						bits |= 1 << (y - top)
+					}
+				}
+				line[x] = '?' + bits
+			}
+			writeRuns(&b, line)
+		}
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeRuns writes sixel characters, with runs of more than three of the same
+// character repeated with "!".
+func writeRuns(b *strings.Builder, line []byte) {
+	for x := 0; x < len(line); {
+		n := 1
+		for x+n < len(line) && line[x+n] == line[x] {
+			n++
+		}
+		if n > 3 {
+			fmt.Fprintf(b, "!%d%c", n, line[x])
+		} else {
+			for k := 0; k < n; k++ {
+				b.WriteByte(line[x])
+			}
+		}
+		x += n
+	}
+}
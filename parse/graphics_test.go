@@ -0,0 +1,209 @@
+package parse
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/wormggmm/goreader/epub"
+)
+
+// filled returns an image w×h pixels, whose rows are in the given colors,
+// each taking an equal share of the height.
+func filled(w, h int, colors ...color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, colors[y*len(colors)/h])
+		}
+	}
+	return img
+}
+
+var (
+	red  = color.RGBA{255, 0, 0, 255}
+	blue = color.RGBA{0, 0, 255, 255}
+)
+
+func TestGraphicsReserveRows(t *testing.T) {
+	rc, err := epub.OpenReader("../epub/_test_files/alice.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	book := rc.Rootfiles[0]
+
+	f, err := book.Spine.Itemrefs[2].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := ParseText(f, book.Manifest.Items, Option{Image: ImageOption{Mode: ImageKitty}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The drop cap is centered over blank cells, and the text after it
+	// keeps its offsets.
+	if len(doc.Images) != 1 {
+		t.Fatalf("Expected: %v, but got: %v\n", 1, len(doc.Images))
+	}
+	im := doc.Images[0]
+	if want := (doc.Width - im.Width) / 2; im.Col != want {
+		t.Errorf("Expected: %v, but got: %v\n", want, im.Col)
+	}
+	for y := im.Row; y < im.Row+im.Height; y++ {
+		for x := im.Col; x < im.Col+im.Width; x++ {
+			if ch := doc.Cells[y*doc.Width+x].Ch; ch != ' ' {
+				t.Fatalf("Expected: %q, but got: %q\n", ' ', ch)
+			}
+		}
+	}
+	loc, err := book.ResolveCFI("epubcfi(/6/6!/4/8/1:0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := doc.RowAt(loc.TextOffset)
+	if line := doc.Line(row); !strings.HasPrefix(line, "LICE was beginning") {
+		t.Errorf("Expected: %q to start with %q\n", line, "LICE was beginning")
+	}
+	if row < im.Row+im.Height {
+		t.Errorf("Expected: row after %v, but got: %v\n", im.Row+im.Height, row)
+	}
+}
+
+func TestGraphicsSize(t *testing.T) {
+	const text = `<p>Text</p>`
+	tests := []struct {
+		w, h      int
+		wantWidth int
+		wantRows  int
+	}{
+		// Images are scaled down to the width of the page, and keep their
+		// aspect ratio with cells twice as high as they are wide.
+		{400, 200, 20, 5},
+		// Images are not enlarged.
+		{30, 60, 3, 3},
+	}
+	for _, tt := range tests {
+		doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.appendGraphic(filled(tt.w, tt.h, red), 20, ImageOption{Mode: ImageSixel})
+		im := doc.Images[0]
+		if im.Width != tt.wantWidth || im.Height != tt.wantRows {
+			t.Errorf("Expected: %v×%v, but got: %v×%v\n", tt.wantWidth, tt.wantRows, im.Width, im.Height)
+		}
+		if im.Col != 0 {
+			t.Errorf("Expected: %v, but got: %v\n", 0, im.Col)
+		}
+	}
+}
+
+func TestCenteredGraphics(t *testing.T) {
+	const text = `<p>Text</p>`
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Images are moved with the cells reserved for them.
+	doc.appendGraphic(filled(40, 20, red), 20, ImageOption{Mode: ImageKitty})
+	doc.alignRow(doc.Images[0].Row, 0, "center")
+	if got := doc.Images[0].Col; got != 8 {
+		t.Errorf("Expected: %v, but got: %v\n", 8, got)
+	}
+}
+
+func TestSixel(t *testing.T) {
+	opt := ImageOption{Mode: ImageSixel, CellWidth: 5, CellRatio: 1.2}
+	tests := []struct {
+		im          Image
+		first, rows int
+		want        string
+	}{
+		// A band of six rows of red, with the run of five columns repeated.
+		{Image{Width: 1, Height: 1, Image: filled(5, 6, red)}, 0, 1,
+			"\x1bPq\"1;1;5;6#180;2;100;0;0#180!5~\x1b\\"},
+		// The lower of two rows of an image.
+		{Image{Width: 1, Height: 2, Image: filled(5, 12, red, blue)}, 1, 1,
+			"\x1bPq\"1;1;5;6#5;2;0;0;100#5!5~\x1b\\"},
+		// Two colors in a band: red in its upper three rows and blue in the
+		// lower three.
+		{Image{Width: 1, Height: 1, Image: filled(3, 6, red, blue)}, 0, 1,
+			"\x1bPq\"1;1;5;6#5;2;0;0;100#180;2;100;0;0#5!5w$#180!5F\x1b\\"},
+	}
+	for _, tt := range tests {
+		if got := tt.im.Escape(opt, tt.first, tt.rows); got != tt.want {
+			t.Errorf("Expected: %q, but got: %q\n", tt.want, got)
+		}
+	}
+}
+
+func TestKitty(t *testing.T) {
+	opt := ImageOption{Mode: ImageKitty}
+	img := filled(4, 4, red)
+	im := Image{Width: 2, Height: 1, Image: img}
+	data := base64.StdEncoding.EncodeToString(encodePNG(img))
+	want := fmt.Sprintf("\x1b_Ga=T,f=100,c=2,r=1,C=1,q=2,m=0;%s\x1b\\", data)
+	if got := im.Escape(opt, 0, 1); got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+
+	// Large images are sent in chunks.
+	noise := image.NewGray(image.Rect(0, 0, 100, 100))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	im = Image{Width: 10, Height: 5, Image: noise}
+	got := im.Escape(opt, 0, 5)
+	chunks := strings.SplitAfter(got, "\x1b\\")
+	chunks = chunks[:len(chunks)-1]
+	if len(chunks) < 2 {
+		t.Fatalf("Expected: more than one chunk, but got: %v\n", len(chunks))
+	}
+	for i, chunk := range chunks {
+		prefix := "\x1b_Gm=1;"
+		switch {
+		case i == 0:
+			prefix = "\x1b_Ga=T,f=100,c=10,r=5,C=1,q=2,m=1;"
+		case i == len(chunks)-1:
+			prefix = "\x1b_Gm=0;"
+		}
+		if !strings.HasPrefix(chunk, prefix) {
+			t.Errorf("Expected: %q to start with %q\n", chunk[:20], prefix)
+		}
+	}
+}
+
+func TestITerm(t *testing.T) {
+	img := filled(4, 4, red)
+	im := Image{Width: 3, Height: 2, Image: img}
+	png := encodePNG(img)
+	want := fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=3;height=2;preserveAspectRatio=0:%s\a",
+		len(png), base64.StdEncoding.EncodeToString(png))
+	if got := im.Escape(ImageOption{Mode: ImageITerm}, 0, 2); got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+}
+
+func TestDetectGraphics(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"TERM": "xterm-kitty"}, ImageKitty},
+		{map[string]string{"TERM": "xterm-256color", "KITTY_WINDOW_ID": "1"}, ImageKitty},
+		{map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "iTerm.app"}, ImageITerm},
+		{map[string]string{"TERM": "foot"}, ImageSixel},
+		{map[string]string{"TERM": "xterm-256color"}, ""},
+	}
+	for _, tt := range tests {
+		getenv := func(key string) string { return tt.env[key] }
+		if got := DetectGraphics(getenv); got != tt.want {
+			t.Errorf("Expected: %q, but got: %q\n", tt.want, got)
+		}
+	}
+}
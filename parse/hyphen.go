@@ -0,0 +1,155 @@
+package parse
+
+import (
+	"embed"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// hyphenationFiles holds the hyphenation patterns of each supported language,
+// one pattern per line in the format of TeX's \patterns, followed by
+// exception words with their hyphens. Lines starting with % are comments.
+//
+//go:embed hyphenation/*.txt
+var hyphenationFiles embed.FS
+
+// hyphenationLanguages maps language tags to their pattern files.
+var hyphenationLanguages = map[string]string{
+	"en":    "hyphenation/en-us.txt",
+	"en-us": "hyphenation/en-us.txt",
+}
+
+const (
+	// leftHyphenMin and rightHyphenMin are the fewest characters left before
+	// and after a hyphen, as in TeX's English hyphenation.
+	leftHyphenMin  = 2
+	rightHyphenMin = 3
+)
+
+var (
+	hyphenatorsMu sync.Mutex
+	hyphenators   = make(map[string]*hyphenator)
+)
+
+// hyphenator finds where words may be hyphenated with Liang's algorithm.
+type hyphenator struct {
+	patterns   map[string][]byte // values between the letters, by letters
+	exceptions map[string][]int  // hyphen positions, by word
+	maxLen     int               // letters of the longest pattern
+}
+
+// hyphenatorFor returns the hyphenator of a language, given as a tag such as
+// "en-US", or nil if there are no patterns for it. Patterns are read the
+// first time a language is used.
+func hyphenatorFor(lang string) *hyphenator {
+	lang = strings.ToLower(strings.ReplaceAll(lang, "_", "-"))
+	file, ok := hyphenationLanguages[lang]
+	if !ok {
+		primary, _, _ := strings.Cut(lang, "-")
+		if file, ok = hyphenationLanguages[primary]; !ok {
+			return nil
+		}
+	}
+
+	hyphenatorsMu.Lock()
+	defer hyphenatorsMu.Unlock()
+	if h, ok := hyphenators[file]; ok {
+		return h
+	}
+	data, err := hyphenationFiles.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	h := newHyphenator(string(data))
+	hyphenators[file] = h
+	return h
+}
+
+// newHyphenator parses patterns such as "hy3ph" and exception words such as
+// "as-so-ciate", one per line.
+func newHyphenator(data string) *hyphenator {
+	h := &hyphenator{
+		patterns:   make(map[string][]byte),
+		exceptions: make(map[string][]int),
+	}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "%") {
+			continue
+		}
+		if strings.Contains(line, "-") {
+			var word []rune
+			var hyphens []int
+			for _, r := range line {
+				if r == '-' {
+					hyphens = append(hyphens, len(word))
+				} else {
+					word = append(word, r)
+				}
+			}
+			h.exceptions[string(word)] = hyphens
+			continue
+		}
+		var letters []rune
+		values := []byte{0}
+		for _, r := range line {
+			if r >= '0' && r <= '9' {
+				values[len(values)-1] = byte(r - '0')
+			} else {
+				letters = append(letters, r)
+				values = append(values, 0)
+			}
+		}
+		h.patterns[string(letters)] = values
+		if len(letters) > h.maxLen {
+			h.maxLen = len(letters)
+		}
+	}
+	return h
+}
+
+// points returns the positions word may be hyphenated at, as the number of
+// characters before the hyphen, in increasing order. Only words of letters
+// are hyphenated.
+func (h *hyphenator) points(word []rune) []int {
+	if len(word) < leftHyphenMin+rightHyphenMin {
+		return nil
+	}
+	lower := make([]rune, len(word))
+	for i, r := range word {
+		if !unicode.IsLetter(r) {
+			return nil
+		}
+		lower[i] = unicode.ToLower(r)
+	}
+	if hyphens, ok := h.exceptions[string(lower)]; ok {
+		return hyphens
+	}
+
+	padded := append(append([]rune{'.'}, lower...), '.')
+	values := make([]byte, len(padded)+1)
+	for i := range padded {
+		for j := i + 1; j <= len(padded) && j-i <= h.maxLen; j++ {
+			pattern, ok := h.patterns[string(padded[i:j])]
+			if !ok {
+				continue
+			}
+			for k, v := range pattern {
+				if v > values[i+k] {
+					values[i+k] = v
+				}
+			}
+		}
+	}
+
+	var points []int
+	for n := leftHyphenMin; n <= len(word)-rightHyphenMin; n++ {
+		// values[n+1] is the value between word[n-1] and word[n], after the
+		// leading '.'.
+		if values[n+1]%2 == 1 {
+			points = append(points, n)
+		}
+	}
+	return points
+}
@@ -0,0 +1,120 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHyphenate(t *testing.T) {
+	h := hyphenatorFor("en-US")
+	if h == nil {
+		t.Fatal("Expected: English patterns, but got: nil")
+	}
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"hyphenation", "hy-phen-a-tion"},
+		{"Beginning", "Be-gin-ning"},
+		{"conversations", "con-ver-sa-tions"},
+		{"associate", "as-so-ciate"}, // an exception word
+		{"cat", "cat"},
+		{"don't", "don't"},
+	}
+	for _, test := range tests {
+		word := []rune(test.word)
+		var b strings.Builder
+		last := 0
+		for _, p := range h.points(word) {
+			b.WriteString(string(word[last:p]) + "-")
+			last = p
+		}
+		b.WriteString(string(word[last:]))
+		if got := b.String(); got != test.want {
+			t.Errorf("Expected: %q, but got: %q\n", test.want, got)
+		}
+	}
+
+	for _, lang := range []string{"en", "en_GB", "EN-us"} {
+		if hyphenatorFor(lang) != h {
+			t.Errorf("Expected: the English patterns for %q\n", lang)
+		}
+	}
+	if got := hyphenatorFor("xx"); got != nil {
+		t.Errorf("Expected: %v, but got: %v\n", nil, got)
+	}
+}
+
+func TestJustify(t *testing.T) {
+	const text = `<p>Alice was beginning to get very tired of sitting by her sister,
+and of having nothing to do. Supercalifragilisticexpialidocious!</p>
+<p style="text-align: center">Centered text is not justified.</p>`
+
+	doc, err := ParseText(strings.NewReader(text), nil,
+		Option{Width: 24, Justify: true, Hyphenate: true, Language: "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := layout(&doc)
+	want := `  Alice was beginning to
+get very  tired of  sit-
+ting by her sister,  and
+of having nothing to do.
+Supercalifragilisticex-
+pialidocious!
+
+   Centered text is not
+       justified.`
+	if got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+
+	// Text offsets follow the moved words.
+	start := strings.Index(text, "sister")
+	offset := len(strings.Join(strings.Fields(text[len("<p>"):start]), ""))
+	if got := doc.Text(offset, offset+len("sister")); got != "sister" {
+		t.Errorf("Expected: %q, but got: %q\n", "sister", got)
+	}
+}
+
+func TestJustifyLastRow(t *testing.T) {
+	// The buffer grows 1024 cells at a time, so at a width of 25 the row
+	// wrapped at the end of the buffer is cut short by it.
+	text := "<p>" + strings.Repeat("Alice was beginning to get very tired. ", 40) + "</p>"
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 25, Justify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every row but the last ends at the right edge.
+	rows := strings.Split(layout(&doc), "\n")
+	for i, row := range rows[:len(rows)-1] {
+		if n := len(row); n != 25 {
+			t.Errorf("row %d: Expected: %v, but got: %v\n", i, 25, n)
+		}
+	}
+}
+
+func TestBreakLongWord(t *testing.T) {
+	const text = `<p>An abcdefghijklmnopqrstuvwxyz word</p>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := layout(&doc)
+	want := `  An
+abcdefghij
+klmnopqrst
+uvwxyz
+word`
+	if got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+	if got := doc.TextLen(); got != len("Anabcdefghijklmnopqrstuvwxyzword") {
+		t.Errorf("Expected: %v, but got: %v\n", len("Anabcdefghijklmnopqrstuvwxyzword"), got)
+	}
+}
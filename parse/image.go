@@ -0,0 +1,383 @@
+package parse
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/nfnt/resize"
+	termbox "github.com/nsf/termbox-go"
+	"github.com/wormggmm/goreader/epub"
+)
+
+// Image modes, which set the characters images are drawn with.
+const (
+	// ImageASCII draws images in shades of gray, as letters and
+	// punctuation of increasing density.
+	ImageASCII = "ascii"
+	// ImageBlocks draws images in color, with a half block (▀) in each
+	// cell, whose foreground is the upper pixel and background the lower.
+	ImageBlocks = "blocks"
+	// ImageBraille draws images as the dots of braille patterns, eight in
+	// each cell, which suits line art.
+	ImageBraille = "braille"
+)
+
+// Dithering methods, which spread the difference between the colors of an
+// image and the few that can be drawn.
+const (
+	DitherNone           = "none"
+	DitherOrdered        = "ordered"         // a 4×4 Bayer matrix
+	DitherFloydSteinberg = "floyd-steinberg" // error diffusion
+)
+
+// defaultCellRatio is the height of a terminal cell over its width assumed
+// when none is given.
+const defaultCellRatio = 2.0
+
+// ImageOption controls how images are drawn.
+type ImageOption struct {
+	// Mode is ImageASCII, ImageBlocks or ImageBraille, or ImageSixel,
+	// ImageKitty or ImageITerm to show images with a terminal graphics
+	// protocol. It defaults to ImageASCII.
+	Mode string
+	// Dither is DitherNone, DitherOrdered or DitherFloydSteinberg. It
+	// defaults to DitherNone.
+	Dither string
+	// CellRatio is the height of a terminal cell over its width, which
+	// keeps images from being stretched. It defaults to 2.
+	CellRatio float64
+	// TrueColor draws ImageBlocks images in 24-bit color, for terminals in
+	// termbox.OutputRGB mode, instead of the 256 color palette.
+	TrueColor bool
+	// CellWidth is the width of a terminal cell in pixels, which ImageSixel
+	// images are scaled by. It defaults to 10.
+	CellWidth int
+}
+
+// asciiGradient holds the characters of ImageASCII mode, from the darkest
+// pixels to the lightest.
+var asciiGradient = []rune("MND8OZ$7I?+=~:,..")
+
+// bayer is the threshold map of ordered dithering.
+var bayer = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// decodeImage decodes the image of an item, or returns nil if it can't be
+// read.
+func decodeImage(item epub.Item) image.Image {
+	r, err := item.Open()
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// drawImage draws an image as rows of cells, w columns wide, and as many rows
+// high as keeps its aspect ratio.
+func drawImage(img image.Image, w int, opt ImageOption) [][]termbox.Cell {
+	// The pixels drawn in each cell, across and down.
+	px, py := 1, 1
+	switch opt.Mode {
+	case ImageBlocks:
+		py = 2
+	case ImageBraille:
+		px, py = 2, 4
+	}
+	ratio := opt.CellRatio
+	if ratio <= 0 {
+		ratio = defaultCellRatio
+	}
+	bounds := img.Bounds()
+	dx, dy := bounds.Dx(), bounds.Dy()
+	if dx <= 0 || dy <= 0 || w <= 0 {
+		return nil
+	}
+	h := int(math.Round(float64(dy) * float64(w) / (float64(dx) * ratio)))
+	if h < 1 {
+		h = 1
+	}
+	img = resize.Resize(uint(w*px), uint(h*py), img, resize.Lanczos3)
+
+	switch opt.Mode {
+	case ImageBlocks:
+		return drawBlocks(img, w, h, opt)
+	case ImageBraille:
+		return drawBraille(img, w, h, opt.Dither)
+	}
+	return drawASCII(img, w, h, opt.Dither)
+}
+
+// drawASCII draws an image of w×h pixels in ImageASCII mode.
+func drawASCII(img image.Image, w, h int, dither string) [][]termbox.Cell {
+	levels := ditherGray(grays(img, w, h), len(asciiGradient), dither)
+	rows := make([][]termbox.Cell, h)
+	for y := range rows {
+		rows[y] = make([]termbox.Cell, w)
+		for x := range rows[y] {
+			rows[y][x].Ch = asciiGradient[levels[y][x]]
+		}
+	}
+	return rows
+}
+
+// drawBraille draws an image of 2w×4h pixels in ImageBraille mode, with a
+// dot for each dark pixel.
+func drawBraille(img image.Image, w, h int, dither string) [][]termbox.Cell {
+	// dots holds the bit of the braille pattern of each dot of a cell.
+	dots := [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+
+	levels := ditherGray(grays(img, 2*w, 4*h), 2, dither)
+	rows := make([][]termbox.Cell, h)
+	for y := range rows {
+		rows[y] = make([]termbox.Cell, w)
+		for x := range rows[y] {
+			ch := rune(0x2800)
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					if levels[4*y+dy][2*x+dx] == 0 {
+						ch |= dots[dy][dx]
+					}
+				}
+			}
+			rows[y][x].Ch = ch
+		}
+	}
+	return rows
+}
+
+// drawBlocks draws an image of w×2h pixels in ImageBlocks mode.
+func drawBlocks(img image.Image, w, h int, opt ImageOption) [][]termbox.Cell {
+	colors := ditherColor(rgbs(img, w, 2*h), opt)
+	rows := make([][]termbox.Cell, h)
+	for y := range rows {
+		rows[y] = make([]termbox.Cell, w)
+		for x := range rows[y] {
+			rows[y][x] = termbox.Cell{Ch: '▀', Fg: colors[2*y][x], Bg: colors[2*y+1][x]}
+		}
+	}
+	return rows
+}
+
+// grays returns the lightness of the pixels of an image, between 0 and 1.
+func grays(img image.Image, w, h int) [][]float64 {
+	b := img.Bounds()
+	values := make([][]float64, h)
+	for y := range values {
+		values[y] = make([]float64, w)
+		for x := range values[y] {
+			g := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray)
+			values[y][x] = float64(g.Y) / 255
+		}
+	}
+	return values
+}
+
+// rgb is a color with components between 0 and 255.
+type rgb [3]float64
+
+// rgbs returns the colors of the pixels of an image.
+func rgbs(img image.Image, w, h int) [][]rgb {
+	b := img.Bounds()
+	values := make([][]rgb, h)
+	for y := range values {
+		values[y] = make([]rgb, w)
+		for x := range values[y] {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			values[y][x] = rgb{float64(r >> 8), float64(g >> 8), float64(bl >> 8)}
+		}
+	}
+	return values
+}
+
+// ditherGray quantizes values between 0 and 1 to the given number of evenly
+// spaced levels, and returns the index of the level of each value.
+func ditherGray(values [][]float64, levels int, dither string) [][]int {
+	steps := float64(levels - 1)
+	out := make([][]int, len(values))
+	for y, row := range values {
+		out[y] = make([]int, len(row))
+		for x, v := range row {
+			var q float64
+			switch dither {
+			case DitherOrdered:
+				q = math.Floor(v*steps + (bayer[y%4][x%4]+0.5)/16)
+			default:
+				q = math.Round(v * steps)
+			}
+			q = math.Max(0, math.Min(steps, q))
+			out[y][x] = int(q)
+			if dither == DitherFloydSteinberg {
+				diffuse(values, x, y, v-q/steps)
+			}
+		}
+	}
+	return out
+}
+
+// ditherColor converts colors to termbox colors: 24-bit colors if
+// opt.TrueColor is set, or else the nearest colors of the 256 color palette.
+func ditherColor(values [][]rgb, opt ImageOption) [][]termbox.Attribute {
+	out := make([][]termbox.Attribute, len(values))
+	for y, row := range values {
+		out[y] = make([]termbox.Attribute, len(row))
+		for x, c := range row {
+			for i := range c {
+				c[i] = math.Max(0, math.Min(255, c[i]))
+			}
+			if opt.TrueColor {
+				out[y][x] = termbox.RGBToAttribute(uint8(c[0]), uint8(c[1]), uint8(c[2]))
+				continue
+			}
+			target := c
+			if opt.Dither == DitherOrdered {
+				// Offset the color by up to half the distance between the
+				// levels of the color cube.
+				for i := range target {
+					target[i] += (bayer[y%4][x%4]/16 - 0.5) * 40
+				}
+			}
+			i := nearestColor(target)
+			out[y][x] = termbox.Attribute(i + 1)
+			if opt.Dither == DitherFloydSteinberg {
+				r, g, b := paletteRGB(i)
+				for j, p := range []uint8{r, g, b} {
+					diffuseChannel(values, x, y, j, c[j]-float64(p))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// diffuse spreads the error of quantizing the value at x, y to the values
+// right of and below it, as in Floyd-Steinberg dithering.
+func diffuse(values [][]float64, x, y int, err float64) {
+	for _, d := range floydSteinberg {
+		yy, xx := y+d.dy, x+d.dx
+		if yy < len(values) && xx >= 0 && xx < len(values[yy]) {
+			values[yy][xx] += err * d.weight
+		}
+	}
+}
+
+// diffuseChannel is diffuse for a channel of colors.
+func diffuseChannel(values [][]rgb, x, y, channel int, err float64) {
+	for _, d := range floydSteinberg {
+		yy, xx := y+d.dy, x+d.dx
+		if yy < len(values) && xx >= 0 && xx < len(values[yy]) {
+			values[yy][xx][channel] += err * d.weight
+		}
+	}
+}
+
+// floydSteinberg is the share of the quantization error of a pixel given to
+// each of its neighbors.
+var floydSteinberg = []struct {
+	dx, dy int
+	weight float64
+}{
+	{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+}
+
+// basicColors are the RGB values of the 16 basic terminal colors, as in
+// xterm.
+var basicColors = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels are the values of each component of the 6×6×6 color cube of the
+// 256 color palette.
+var cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+
+// paletteRGB returns the RGB value of color i of the 256 color palette.
+func paletteRGB(i int) (r, g, b uint8) {
+	switch {
+	case i < 16:
+		c := basicColors[i]
+		return c[0], c[1], c[2]
+	case i < 232:
+		i -= 16
+		return cubeLevels[i/36], cubeLevels[i/6%6], cubeLevels[i%6]
+	}
+	v := uint8(8 + 10*(i-232))
+	return v, v, v
+}
+
+// nearestColor returns the color of the 256 color palette nearest to c,
+// among the color cube and the grays. The basic colors are left out, since
+// terminals often change them.
+func nearestColor(c rgb) int {
+	cube := 16
+	for i, mult := range []int{36, 6, 1} {
+		cube += mult * nearestLevel(c[i])
+	}
+	gray := (c[0] + c[1] + c[2]) / 3
+	g := int(math.Round((gray - 8) / 10))
+	if g < 0 {
+		g = 0
+	} else if g > 23 {
+		g = 23
+	}
+	if distance(c, 232+g) < distance(c, cube) {
+		return 232 + g
+	}
+	return cube
+}
+
+// nearestLevel returns the index of the level of the color cube nearest to
+// v.
+func nearestLevel(v float64) int {
+	best := 0
+	for i, l := range cubeLevels {
+		if math.Abs(v-float64(l)) < math.Abs(v-float64(cubeLevels[best])) {
+			best = i
+		}
+	}
+	return best
+}
+
+// distance returns the squared distance between c and color i of the 256
+// color palette.
+func distance(c rgb, i int) float64 {
+	r, g, b := paletteRGB(i)
+	dr, dg, db := c[0]-float64(r), c[1]-float64(g), c[2]-float64(b)
+	return dr*dr + dg*dg + db*db
+}
+
+// appendImage writes the rows of an image from the left margin, on rows of
+// their own. Cells without colors take the current style. Like appendLine, it
+// does not advance the text offset.
+func (c *Cellbuf) appendImage(rows [][]termbox.Cell) {
+	if c.col > c.lmargin {
+		c.row++
+	}
+	for _, row := range rows {
+		for x, cell := range row {
+			if c.lmargin+x >= c.Width {
+				break
+			}
+			if cell.Fg == termbox.ColorDefault && cell.Bg == termbox.ColorDefault {
+				cell.Fg, cell.Bg = c.fg, c.bg
+			}
+			c.setCell(c.lmargin+x, c.row, cell.Ch, cell.Fg, cell.Bg)
+		}
+		c.row++
+	}
+	c.col = c.lmargin
+}
@@ -0,0 +1,117 @@
+package parse
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// halves returns an image w×h pixels, black on the left and white on the
+// right.
+func halves(w, h int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := w / 2; x < w; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	return img
+}
+
+func TestImageASCII(t *testing.T) {
+	tests := []struct {
+		ratio float64
+		want  []string
+	}{
+		{2, []string{"MM..", "MM.."}},
+		{1, []string{"MM..", "MM..", "MM..", "MM.."}},
+	}
+	for _, tt := range tests {
+		rows := drawImage(halves(4, 4), 4, ImageOption{CellRatio: tt.ratio})
+		var got []string
+		for _, row := range rows {
+			var s []rune
+			for _, cell := range row {
+				s = append(s, cell.Ch)
+			}
+			got = append(got, string(s))
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("Expected: %v, but got: %v\n", tt.want, got)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Expected: %v, but got: %v\n", tt.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestImageBraille(t *testing.T) {
+	// Two cells of 2×4 dots, each with its left column dark.
+	rows := drawImage(halves(2, 4), 1, ImageOption{Mode: ImageBraille})
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		t.Fatalf("Expected: 1 cell, but got: %v\n", rows)
+	}
+	if got, want := rows[0][0].Ch, '⡇'; got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+}
+
+func TestImageBlocks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 2))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(0, 1, color.RGBA{0, 0, 255, 255})
+
+	tests := []struct {
+		trueColor bool
+		want      termbox.Cell
+	}{
+		// Colors 196 and 21 of the 256 color palette, which termbox numbers
+		// from 1.
+		{false, termbox.Cell{Ch: '▀', Fg: 197, Bg: 22}},
+		{true, termbox.Cell{Ch: '▀', Fg: termbox.RGBToAttribute(255, 0, 0), Bg: termbox.RGBToAttribute(0, 0, 255)}},
+	}
+	for _, tt := range tests {
+		rows := drawImage(img, 1, ImageOption{Mode: ImageBlocks, TrueColor: tt.trueColor})
+		if len(rows) != 1 || len(rows[0]) != 1 {
+			t.Fatalf("Expected: 1 cell, but got: %v\n", rows)
+		}
+		if got := rows[0][0]; got != tt.want {
+			t.Errorf("Expected: %v, but got: %v\n", tt.want, got)
+		}
+	}
+}
+
+func TestDither(t *testing.T) {
+	tests := []struct {
+		dither string
+		want   int
+	}{
+		{DitherNone, 0},
+		{DitherOrdered, 8},
+		{DitherFloydSteinberg, 8},
+	}
+	for _, tt := range tests {
+		// Quantize a mid gray to black and white: dithering makes half of
+		// the pixels black.
+		values := make([][]float64, 4)
+		for y := range values {
+			values[y] = []float64{0.5, 0.5, 0.5, 0.5}
+		}
+		black := 0
+		for _, row := range ditherGray(values, 2, tt.dither) {
+			for _, level := range row {
+				if level == 0 {
+					black++
+				}
+			}
+		}
+		if black != tt.want {
+			t.Errorf("%s: Expected: %v, but got: %v\n", tt.dither, tt.want, black)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package parse
+
+import (
+	"io"
+	"strings"
+
+	"github.com/wormggmm/goreader/epub"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// IDIndex indexes the text of the elements of a book's documents by id, so
+// that a note can be shown wherever it is referenced from, whichever
+// document it is in. Documents are indexed the first time they are looked up.
+type IDIndex struct {
+	book *epub.Rootfile
+	docs map[string]map[string]string // element text by id, by item HREF
+}
+
+// NewIDIndex creates an index of the documents of book.
+func NewIDIndex(book *epub.Rootfile) *IDIndex {
+	return &IDIndex{book: book, docs: make(map[string]map[string]string)}
+}
+
+// Text returns the text of the element with the given id in the document at
+// href, relative to the package document. Paragraphs are separated by line
+// breaks. Elements within a paragraph, such as the backlink a note often
+// starts with, stand for the whole paragraph.
+func (x *IDIndex) Text(href, id string) (string, bool) {
+	ids, ok := x.docs[href]
+	if !ok {
+		for i := range x.book.Manifest.Items {
+			item := &x.book.Manifest.Items[i]
+			if !epub.SameHREF(item.HREF, href) {
+				continue
+			}
+			if f, err := item.Open(); err == nil {
+				ids = indexIDs(f)
+				f.Close()
+			}
+			break
+		}
+		x.docs[href] = ids
+	}
+	text, ok := ids[id]
+	return text, ok
+}
+
+// idElement is an open element of a document being indexed.
+type idElement struct {
+	tag   string
+	block bool
+	ids   []string
+	text  strings.Builder
+}
+
+// indexIDs returns the text of the elements of an HTML document with an id,
+// by id.
+func indexIDs(r io.Reader) map[string]string {
+	ids := make(map[string]string)
+	var stack []*idElement
+	closeElement := func() {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, id := range e.ids {
+			ids[id] = paragraphs(e.text.String())
+		}
+		if e.block {
+			for _, parent := range stack {
+				parent.text.WriteByte('\n')
+			}
+		}
+	}
+
+	z := html.NewTokenizer(r)
+	for {
+		tokenType := z.Next()
+		token := z.Token()
+		switch tokenType {
+		case html.ErrorToken:
+			for len(stack) > 0 {
+				closeElement()
+			}
+			return ids
+		case html.StartTagToken, html.SelfClosingTagToken:
+			e := &idElement{tag: strings.ToLower(token.Data), block: blockElements[token.DataAtom]}
+			if e.block || token.DataAtom == atom.Br {
+				for _, parent := range stack {
+					parent.text.WriteByte('\n')
+				}
+			}
+			for _, a := range token.Attr {
+				if a.Key == "id" || (a.Key == "name" && token.DataAtom == atom.A) {
+					e.ids = append(e.ids, a.Val)
+				}
+			}
+			if !e.block {
+				// Inline elements stand for the paragraph they are in.
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i].block {
+						stack[i].ids = append(stack[i].ids, e.ids...)
+						e.ids = nil
+						break
+					}
+				}
+			}
+			if tokenType == html.SelfClosingTagToken || voidElements[token.DataAtom] {
+				continue
+			}
+			stack = append(stack, e)
+		case html.EndTagToken:
+			tag := strings.ToLower(token.Data)
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].tag == tag {
+					for len(stack) > i {
+						closeElement()
+					}
+					break
+				}
+			}
+		case html.TextToken:
+			if n := len(stack); n > 0 && (stack[n-1].tag == "style" || stack[n-1].tag == "script") {
+				continue
+			}
+			// Line breaks in the text only separate words; paragraphs are
+			// separated by the elements around them.
+			text := strings.NewReplacer("\r", " ", "\n", " ").Replace(token.Data)
+			for _, e := range stack {
+				e.text.WriteString(text)
+			}
+		}
+	}
+}
+
+// paragraphs collapses the whitespace of each line of text, dropping empty
+// lines.
+func paragraphs(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if words := strings.Fields(line); len(words) > 0 {
+			lines = append(lines, strings.Join(words, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
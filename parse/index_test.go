@@ -0,0 +1,37 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIndexIDs(t *testing.T) {
+	const text = `<html><head><style>p { color: red }</style></head><body>
+<p>Text<a id="ref1" href="#fn1" epub:type="noteref">1</a>.</p>
+<aside id="fn1" epub:type="footnote">
+  <p>First   paragraph
+  of the note.</p>
+  <p>Second<br/>line.</p>
+</aside>
+<ol><li id="en1"><p><a href="#ref1" id="back1">1.</a> An endnote.</p></li></ol>
+</body></html>`
+
+	ids := indexIDs(strings.NewReader(text))
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"fn1", "First paragraph of the note.\nSecond\nline."},
+		{"en1", "1. An endnote."},
+		{"back1", "1. An endnote."},
+		{"ref1", "Text1."},
+	}
+	for _, test := range tests {
+		if got := ids[test.id]; got != test.want {
+			t.Errorf("Expected: %q, but got: %q\n", test.want, got)
+		}
+	}
+	if _, ok := ids["missing"]; ok {
+		t.Errorf("Expected: %v, but got: %v\n", false, ok)
+	}
+}
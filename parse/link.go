@@ -0,0 +1,88 @@
+package parse
+
+import (
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Link is a hyperlink of a document. Start and End are the text offsets of
+// its text, and HREF is its target as written in the document. NoteRef is
+// set for references to footnotes and endnotes.
+type Link struct {
+	Start, End int
+	HREF       string
+	NoteRef    bool
+}
+
+// Links returns the links of the document that have text, in document order.
+func (c *Cellbuf) Links() []Link {
+	return c.links
+}
+
+// startLink starts recording the text of an <a> element with an href.
+func (p *parser) startLink(token html.Token, e *element) {
+	if e.atom != atom.A || e.hidden {
+		return
+	}
+	for _, a := range token.Attr {
+		if a.Key == "href" && a.Val != "" {
+			e.href = a.Val
+			e.linkStart = p.textOffset()
+		}
+	}
+}
+
+// endLink records the link of an <a> element, unless it has no text.
+func (p *parser) endLink(e *element) {
+	if e.href == "" {
+		return
+	}
+	if end := p.textOffset(); end > e.linkStart {
+		p.doc.links = append(p.doc.links, Link{
+			Start:   e.linkStart,
+			End:     end,
+			HREF:    e.href,
+			NoteRef: e.noteType == "noteref",
+		})
+	}
+}
+
+// textOffset returns the text offset of the next text of the document,
+// including the text of the table being collected, which has no offsets
+// until it is laid out.
+func (p *parser) textOffset() int {
+	if p.table == nil {
+		return p.doc.offset
+	}
+	return p.doc.offset + p.table.textLen()
+}
+
+// textLen returns the number of characters of the document's text collected
+// in the table so far.
+func (t *table) textLen() int {
+	n := count(t.caption)
+	for _, row := range t.rows {
+		for _, cell := range row.cells {
+			n += count(cell.runs)
+		}
+	}
+	return n
+}
+
+// count returns the number of characters of the document's text in runs.
+func count(runs []tableRun) int {
+	n := 0
+	for _, run := range runs {
+		if run.uncounted || run.lineBreak {
+			continue
+		}
+		for _, r := range run.text {
+			if !unicode.IsSpace(r) {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,45 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	const text = `<p>See <a href="ch2.html#sec">the next chapter</a> and
+<a href="#n1" epub:type="noteref">1</a>. <a name="anchor">No link</a>
+<a href="#empty"></a></p>
+<table><tr><td>Cell</td><td><a href="http://example.com/">web</a></td></tr></table>
+<p>After <a href="#top">top</a></p>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		href    string
+		text    string
+		noteRef bool
+	}{
+		{"ch2.html#sec", "the next chapter", false},
+		{"#n1", "¹", true},
+		{"http://example.com/", "web", false},
+		{"#top", "top", false},
+	}
+	links := doc.Links()
+	if len(links) != len(tests) {
+		t.Fatalf("Expected: %v, but got: %v\n", len(tests), links)
+	}
+	for i, test := range tests {
+		if links[i].HREF != test.href {
+			t.Errorf("Expected: %q, but got: %q\n", test.href, links[i].HREF)
+		}
+		if got := doc.Text(links[i].Start, links[i].End); got != test.text {
+			t.Errorf("Expected: %q, but got: %q\n", test.text, got)
+		}
+		if links[i].NoteRef != test.noteRef {
+			t.Errorf("Expected: %v, but got: %v\n", test.noteRef, links[i].NoteRef)
+		}
+	}
+}
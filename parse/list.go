@@ -0,0 +1,245 @@
+package parse
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// listIndent is the number of columns lists are indented by.
+	listIndent = 2
+	// descriptionIndent is the number of columns definition descriptions are
+	// indented by.
+	descriptionIndent = 4
+)
+
+// bulletTypes are the marker types of unordered lists, by nesting depth.
+var bulletTypes = []string{"disc", "circle", "square"}
+
+// markerTypes maps the type attribute of lists and list items to CSS
+// list-style-type values.
+var markerTypes = map[string]string{
+	"1":      "decimal",
+	"a":      "lower-alpha",
+	"A":      "upper-alpha",
+	"i":      "lower-roman",
+	"I":      "upper-roman",
+	"disc":   "disc",
+	"circle": "circle",
+	"square": "square",
+	"none":   "none",
+}
+
+// bullets are the markers of unordered list items, by marker type.
+var bullets = map[string]string{
+	"disc":   "•",
+	"circle": "◦",
+	"square": "▪",
+}
+
+// startList starts a ul, ol or dl element on a new row, indented from the
+// enclosing text. Lists within list items follow the item's text directly,
+// other lists are separated from it by a blank row.
+func (p *parser) startList(token html.Token, e *element) {
+	if p.inItem() {
+		p.doc.newRow()
+	} else {
+		p.doc.row += 2
+		p.doc.col = p.doc.lmargin
+	}
+	p.doc.indent(listIndent)
+
+	e.markerType = p.inherited(func(s *cssStyle) string { return s.listType })
+	for _, a := range token.Attr {
+		switch a.Key {
+		case "type":
+			if t, ok := markerTypes[a.Val]; ok && e.css.listType == "" {
+				e.markerType = t
+			}
+		case "start":
+			if n, err := strconv.Atoi(a.Val); err == nil {
+				e.counter = n - 1
+			}
+		}
+	}
+	if e.markerType != "" {
+		return
+	}
+	switch e.atom {
+	case atom.Ol:
+		e.markerType = "decimal"
+	case atom.Ul:
+		depth := 0
+		for _, parent := range p.stack[:len(p.stack)-1] {
+			if parent.atom == atom.Ul {
+				depth++
+			}
+		}
+		e.markerType = bulletTypes[depth%len(bulletTypes)]
+	}
+}
+
+// startItem starts a list item on a new row, with its marker in the left
+// margin and its text indented past the marker.
+func (p *parser) startItem(token html.Token, e *element) {
+	list := p.list()
+	if list == nil {
+		// An item outside of a list is taken to be in an unordered list.
+		list = &element{markerType: bulletTypes[0]}
+	}
+	list.counter++
+
+	markerType := list.markerType
+	if e.css.listType != "" {
+		markerType = e.css.listType
+	}
+	for _, a := range token.Attr {
+		switch a.Key {
+		case "type":
+			if t, ok := markerTypes[a.Val]; ok && e.css.listType == "" {
+				markerType = t
+			}
+		case "value":
+			if n, err := strconv.Atoi(a.Val); err == nil {
+				list.counter = n
+			}
+		}
+	}
+
+	p.doc.style(p.stack)
+	p.doc.startItem(marker(markerType, list.counter))
+}
+
+// startDefinition starts a dt or dd element on a new row. Terms after the
+// first are separated from the previous description by a blank row, and
+// descriptions are indented.
+func (p *parser) startDefinition(e *element) {
+	p.doc.newRow()
+	switch e.atom {
+	case atom.Dt:
+		if list := p.list(); list != nil && p.doc.row > list.startRow {
+			p.doc.row++
+		}
+	case atom.Dd:
+		p.doc.indent(descriptionIndent)
+	}
+}
+
+// list returns the innermost open list, or nil if there is none.
+func (p *parser) list() *element {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		switch p.stack[i].atom {
+		case atom.Ul, atom.Ol, atom.Dl:
+			return p.stack[i]
+		}
+	}
+	return nil
+}
+
+// inItem reports whether the innermost open element is within a list item or
+// definition.
+func (p *parser) inItem() bool {
+	for _, e := range p.stack[:len(p.stack)-1] {
+		switch e.atom {
+		case atom.Li, atom.Dt, atom.Dd:
+			return true
+		}
+	}
+	return false
+}
+
+// atBlockStart reports whether nothing has been laid out since the start of
+// the list item, definition, quote or figure that the innermost open element
+// is the first child of, so that a paragraph in it starts on the marker's
+// row, or on the first row of the quote instead of after a blank row.
+func (p *parser) atBlockStart() bool {
+	if len(p.stack) < 2 {
+		return false
+	}
+	parent := p.stack[len(p.stack)-2]
+	switch parent.atom {
+	case atom.Li, atom.Dt, atom.Dd, atom.Blockquote, atom.Aside, atom.Figure:
+		return p.doc.row == parent.startRow && p.doc.col == p.doc.lmargin
+	}
+	return false
+}
+
+// startItem starts a list item on a new row, writing marker at the left
+// margin and moving the margin past it for a hanging indent.
+func (c *Cellbuf) startItem(marker string) {
+	c.newRow()
+	if marker == "" {
+		return
+	}
+	for _, r := range marker {
+		c.setCell(c.col, c.row, r, c.fg, c.bg)
+		c.col++
+	}
+	text := c.col + 1
+	c.indent(text - c.lmargin)
+	if c.col = c.lmargin; c.col < text {
+		// The margin is too narrow for the hanging indent.
+		c.col = text
+	}
+	c.space = false
+}
+
+// marker returns the marker of the nth item of a list with the given
+// list-style-type.
+func marker(markerType string, n int) string {
+	if b, ok := bullets[markerType]; ok {
+		return b
+	}
+	switch markerType {
+	case "none":
+		return ""
+	case "lower-alpha":
+		return alpha(n) + "."
+	case "upper-alpha":
+		return strings.ToUpper(alpha(n)) + "."
+	case "lower-roman":
+		return roman(n) + "."
+	case "upper-roman":
+		return strings.ToUpper(roman(n)) + "."
+	}
+	return strconv.Itoa(n) + "."
+}
+
+// alpha returns n as a, b, ..., z, aa, ab, .... Numbers below 1 are written
+// as decimal numbers.
+func alpha(n int) string {
+	if n < 1 {
+		return strconv.Itoa(n)
+	}
+	var s []byte
+	for ; n > 0; n = (n - 1) / 26 {
+		s = append([]byte{byte('a' + (n-1)%26)}, s...)
+	}
+	return string(s)
+}
+
+// roman returns n as a lower case roman numeral. Numbers outside of 1 to 3999
+// are written as decimal numbers.
+func roman(n int) string {
+	if n < 1 || n > 3999 {
+		return strconv.Itoa(n)
+	}
+	numerals := []struct {
+		value int
+		s     string
+	}{
+		{1000, "m"}, {900, "cm"}, {500, "d"}, {400, "cd"}, {100, "c"},
+		{90, "xc"}, {50, "l"}, {40, "xl"}, {10, "x"}, {9, "ix"}, {5, "v"},
+		{4, "iv"}, {1, "i"},
+	}
+	var b strings.Builder
+	for _, num := range numerals {
+		for ; n >= num.value; n -= num.value {
+			b.WriteString(num.s)
+		}
+	}
+	return b.String()
+}
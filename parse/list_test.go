@@ -0,0 +1,89 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLists(t *testing.T) {
+	const text = `<p>Before</p>
+<ul>
+<li>one</li>
+<li><p>two words that wrap</p>
+<ol start="3" type="i"><li>three</li><li value="9">nine</li></ol>
+</li>
+<li style="list-style-type: none">bare</li>
+</ul>
+<ol type="A"><li>alpha</li><li>beta</li></ol>
+<dl><dt>Term</dt><dd>Description</dd><dt>Other</dt><dd>More</dd></dl>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for row := 0; row < doc.Rows(); row++ {
+		lines = append(lines, strings.TrimRight(rowText(&doc, row), " "))
+	}
+	got := strings.Trim(strings.Join(lines, "\n"), "\n")
+	want := `  Before
+
+  • one
+  • two words that
+    wrap
+      iii. three
+      ix. nine
+  bare
+
+  A. alpha
+  B. beta
+
+  Term
+      Description
+
+  Other
+      More`
+	if got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+}
+
+func TestMarker(t *testing.T) {
+	tests := []struct {
+		markerType string
+		n          int
+		want       string
+	}{
+		{"decimal", 12, "12."},
+		{"lower-alpha", 1, "a."},
+		{"lower-alpha", 28, "ab."},
+		{"upper-alpha", 26, "Z."},
+		{"lower-roman", 1994, "mcmxciv."},
+		{"upper-roman", 4, "IV."},
+		{"circle", 2, "◦"},
+		{"none", 1, ""},
+	}
+
+	for _, test := range tests {
+		if got := marker(test.markerType, test.n); got != test.want {
+			t.Errorf("Expected: %q, but got: %q\n", test.want, got)
+		}
+	}
+}
+
+// rowText returns the text of a row, with the spaces around it.
+func rowText(doc *Cellbuf, row int) string {
+	var b strings.Builder
+	for x := 0; x < doc.Width && row*doc.Width+x < len(doc.Cells); x++ {
+		ch := doc.Cells[row*doc.Width+x].Ch
+		if ch == Continuation {
+			continue
+		}
+		if ch == 0 {
+			ch = ' '
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
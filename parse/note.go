@@ -0,0 +1,92 @@
+package parse
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// noteTypes maps the epub:type and role values of footnotes, endnotes and
+// references to them to the kind of note.
+var noteTypes = map[string]string{
+	"footnote":     "footnote",
+	"doc-footnote": "footnote",
+	"endnote":      "endnote",
+	"rearnote":     "endnote",
+	"doc-endnote":  "endnote",
+	"noteref":      "noteref",
+	"doc-noteref":  "noteref",
+}
+
+// noteType returns the kind of note a start tag starts, "footnote",
+// "endnote" or "noteref", or "" if it is not part of a note.
+func noteType(token html.Token) string {
+	for _, a := range token.Attr {
+		if a.Key != "epub:type" && a.Key != "role" {
+			continue
+		}
+		for _, v := range strings.Fields(a.Val) {
+			if t, ok := noteTypes[v]; ok {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+// startNote hides a footnote, so that it is not laid out in the middle of the
+// text it annotates, but links to it still lead to where it would have been.
+// Its text is shown in a popup from the link instead.
+func (p *parser) startNote(e *element) {
+	if e.noteType != "footnote" {
+		return
+	}
+	e.hidden = true
+	if e.id != "" {
+		p.doc.setAnchor(e.id)
+	}
+}
+
+// superscripts and subscripts map characters to their superscript and
+// subscript forms.
+var (
+	superscripts = map[rune]rune{
+		'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶',
+		'7': '⁷', '8': '⁸', '9': '⁹', '+': '⁺', '-': '⁻', '=': '⁼', '(': '⁽',
+		')': '⁾', 'i': 'ⁱ', 'n': 'ⁿ',
+	}
+	subscripts = map[rune]rune{
+		'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆',
+		'7': '₇', '8': '₈', '9': '₉', '+': '₊', '-': '₋', '=': '₌', '(': '₍',
+		')': '₎',
+	}
+)
+
+// raise writes the text of sup and sub elements and of note references in
+// superscript or subscript characters. Text with characters that have no
+// such form is left as it is, rather than only partly raised.
+func (p *parser) raise(text string) string {
+	var forms map[rune]rune
+	for i := len(p.stack) - 1; i >= 0 && forms == nil; i-- {
+		switch e := p.stack[i]; {
+		case e.atom == atom.Sup, e.noteType == "noteref":
+			forms = superscripts
+		case e.atom == atom.Sub:
+			forms = subscripts
+		}
+	}
+	if forms == nil {
+		return text
+	}
+	raised := []rune(text)
+	for i, r := range raised {
+		if f, ok := forms[r]; ok {
+			raised[i] = f
+		} else if !unicode.IsSpace(r) {
+			return text
+		}
+	}
+	return string(raised)
+}
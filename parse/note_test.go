@@ -0,0 +1,39 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNotes(t *testing.T) {
+	const text = `<p>Text<a epub:type="noteref" href="#n1">1</a> and x<sup>2</sup>
+or H<sub>2</sub>O, the 4<sup>th</sup>.</p>
+<aside epub:type="footnote" id="n1"><p>The  note
+text.</p></aside>
+<section epub:type="endnotes"><p id="n2" role="doc-endnote">End note.</p></section>
+<p>After</p>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := layout(&doc)
+	want := `  Text¹ and x² or H₂O, the 4th.
+
+   End note.
+
+   After`
+	if got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+
+	// The hidden footnote keeps its text offsets.
+	if got, want := doc.Text(doc.TextLen()-len("After"), doc.TextLen()), "After"; got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+	if got, want := doc.TextLen(), len(strings.Join(strings.Fields(
+		"Text1 andx2 orH2O,the4th. Thenotetext. Endnote. After"), "")); got != want {
+		t.Errorf("Expected: %v, but got: %v\n", want, got)
+	}
+}
@@ -2,18 +2,12 @@ package parse
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
-	"image"
-	"image/color"
 	"io"
+	"sort"
 	"strings"
 	"unicode"
 
-	_ "image/jpeg"
-	_ "image/png"
-
-	"github.com/nfnt/resize"
 	termbox "github.com/nsf/termbox-go"
 	"github.com/wormggmm/goreader/epub"
 
@@ -21,21 +15,121 @@ import (
 	"golang.org/x/net/html/atom"
 )
 
+// defaultWidth is the layout width used when none is given.
+const defaultWidth = 80
+
+// Option controls how documents are laid out.
+type Option struct {
+	// Width is the number of columns text is wrapped to.
+	Width int
+	// Theme styles the text of HTML elements. It defaults to DefaultTheme.
+	Theme *Theme
+	// Justify aligns text to both edges, unless CSS aligns it otherwise.
+	Justify bool
+	// Hyphenate hyphenates words at the end of rows, with the patterns of
+	// the book's Language, such as "en-US".
+	Hyphenate bool
+	Language  string
+	// Direction is the direction of text without a dir attribute, "rtl" for
+	// books whose pages progress from right to left.
+	Direction string
+	// Vertical lays out documents written vertically, with CSS writing-mode
+	// vertical-rl, in columns Height characters long, which are read from
+	// right to left. Rows of the Cellbuf are then its columns.
+	Vertical bool
+	Height   int
+	// Image controls how images are drawn.
+	Image ImageOption
+}
+
 type parser struct {
-	tagStack  []atom.Atom
+	stack     []*element // open elements, from outermost to innermost
+	css       stylesheet
+	table     *table // the table whose cells are being collected, if any
+	preStart  bool   // whether the last token started a pre element
+	justify   bool
+	baseDir   string // the page progression direction of the book
+	image     ImageOption
 	tokenizer *html.Tokenizer
 	doc       Cellbuf
 	items     []epub.Item
 }
 
+// voidElements are the elements that have no content and no end tag.
+var voidElements = map[atom.Atom]bool{
+	atom.Area: true, atom.Base: true, atom.Br: true, atom.Col: true,
+	atom.Embed: true, atom.Hr: true, atom.Img: true, atom.Input: true,
+	atom.Link: true, atom.Meta: true, atom.Param: true, atom.Source: true,
+	atom.Track: true, atom.Wbr: true,
+}
+
+// blockElements are the elements displayed as blocks by default, which CSS
+// margins and alignment apply to.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true,
+	atom.Blockquote: true, atom.Body: true, atom.Dd: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Figcaption: true, atom.Figure: true,
+	atom.Footer: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true,
+	atom.Li: true, atom.Nav: true, atom.Ol: true, atom.P: true, atom.Pre: true,
+	atom.Section: true, atom.Table: true, atom.Title: true, atom.Tr: true,
+	atom.Ul: true,
+}
+
+// paragraphElements are the block elements that start a new paragraph.
+var paragraphElements = map[atom.Atom]bool{
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true,
+	atom.H6: true, atom.Title: true, atom.Div: true, atom.Tr: true, atom.P: true,
+	atom.Blockquote: true, atom.Aside: true, atom.Figure: true,
+	atom.Figcaption: true,
+}
+
+// paragraphIndent is the first line indent of <p> elements without a CSS
+// text-indent.
+const paragraphIndent = 2
+
 type Cellbuf struct {
-	Cells   []termbox.Cell
-	Width   int
-	lmargin int
-	col     int
-	row     int
-	space   bool
-	fg, bg  termbox.Attribute
+	Cells    []termbox.Cell
+	Width    int
+	Vertical bool    // whether rows are displayed as columns from right to left
+	Blocks   []Block // preformatted blocks, in document order
+	Images   []Image // images shown with a terminal graphics protocol
+	lmargin  int
+	col      int
+	row      int
+	space    bool
+	fg, bg   termbox.Attribute
+	theme    *Theme
+	anchors  map[string]int
+	aligned  map[int]bool // rows aligned by the innermost block around them
+	links    []Link
+
+	// offset counts the non-whitespace characters of the document's text
+	// laid out so far. rowOffsets holds the offset of the first text in each
+	// row, or -1 for rows without text. Text offsets do not depend on the
+	// layout width, so they identify a position in the document across
+	// re-layouts, and match the offsets of epub.CFILocation. Text that is
+	// not part of the document (e.g. image alt text) is laid out with
+	// uncounted set and has no offset. textCells holds the cell index of the
+	// character at each offset. It is sorted unless text was laid out out of
+	// order, as in the columns of a table.
+	offset     int
+	rowOffsets []int
+	textCells  []int
+	uncounted  bool
+	unordered  bool
+
+	justify    bool // justify the rows of the text being laid out
+	hyphenator *hyphenator
+
+	verticalText bool // whether any text is written vertically
+
+	// wordBreaks holds the offsets of the text continuing words broken
+	// across rows. clusters holds the text of the grapheme clusters with
+	// combining marks, in normalization form C, by the offset of their first
+	// character.
+	wordBreaks map[int]bool
+	clusters   map[int]string
 }
 
 // setCell changes a cell's attributes in the cell buffer document at the given
@@ -48,27 +142,288 @@ func (c *Cellbuf) setCell(x, y int, ch rune, fg, bg termbox.Attribute) {
 	c.Cells[y*c.Width+x] = termbox.Cell{Ch: ch, Fg: fg, Bg: bg}
 }
 
+// setAnchor records the current row as the position of the element with the
+// given id, so that links with fragments can be resolved to a row.
+func (c *Cellbuf) setAnchor(id string) {
+	if c.anchors == nil {
+		c.anchors = make(map[string]int)
+	}
+	if _, ok := c.anchors[id]; !ok {
+		c.anchors[id] = c.row
+	}
+}
+
+// Anchor returns the row of the element with the given id.
+func (c *Cellbuf) Anchor(id string) (int, bool) {
+	row, ok := c.anchors[id]
+	return row, ok
+}
+
+// markOffset records the current text offset as the start of the given row,
+// unless text has already been laid out in it.
+func (c *Cellbuf) markOffset(row int) {
+	for len(c.rowOffsets) <= row {
+		c.rowOffsets = append(c.rowOffsets, -1)
+	}
+	if c.rowOffsets[row] < 0 {
+		c.rowOffsets[row] = c.offset
+	}
+}
+
+// Offset returns the text offset of the first text on or after the given row.
+func (c *Cellbuf) Offset(row int) int {
+	if row < 0 {
+		row = 0
+	}
+	for ; row < len(c.rowOffsets); row++ {
+		if c.rowOffsets[row] >= 0 {
+			return c.rowOffsets[row]
+		}
+	}
+	return c.offset
+}
+
+// RowAt returns the row containing the text at the given offset.
+func (c *Cellbuf) RowAt(offset int) int {
+	row := 0
+	for r, o := range c.rowOffsets {
+		if o < 0 {
+			continue
+		}
+		if o > offset {
+			break
+		}
+		row = r
+	}
+	return row
+}
+
+// TextLen returns the number of characters of text in the document, which is
+// one past the last text offset.
+func (c *Cellbuf) TextLen() int {
+	return len(c.textCells)
+}
+
+// TextCell returns the index into Cells of the character at the given text
+// offset, or -1 if the offset is out of range.
+func (c *Cellbuf) TextCell(offset int) int {
+	if offset < 0 || offset >= len(c.textCells) {
+		return -1
+	}
+	return c.textCells[offset]
+}
+
+// TextOffset returns the text offset of the last character at or before the
+// cell at index i, or -1 if there is no text before it.
+func (c *Cellbuf) TextOffset(i int) int {
+	if !c.unordered {
+		return sort.SearchInts(c.textCells, i+1) - 1
+	}
+	offset, cell := -1, -1
+	for o, ci := range c.textCells {
+		if ci <= i && ci >= cell {
+			offset, cell = o, ci
+		}
+	}
+	return offset
+}
+
+// TextRegions returns the cells holding the text from offset start up to end,
+// as one region per row, or per table cell in a row. Regions span the gaps
+// between words, but not the margins or table borders. Text in right-to-left
+// order extends its region to the left.
+func (c *Cellbuf) TextRegions(start, end int) []Region {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(c.textCells) {
+		end = len(c.textCells)
+	}
+
+	var regions []Region
+	for o := start; o < end; o++ {
+		i := c.textCells[o]
+		if n := len(regions); n > 0 && c.Row(regions[n-1].Start) == c.Row(i) {
+			r := &regions[n-1]
+			if i >= r.Start && c.blank(r.End, i) {
+				if e := c.cellEnd(i); e > r.End {
+					r.End = e
+				}
+				continue
+			}
+			if i < r.Start && c.blank(c.cellEnd(i), r.Start) {
+				r.Start = i
+				continue
+			}
+		}
+		regions = append(regions, Region{i, c.cellEnd(i)})
+	}
+	return regions
+}
+
+// blank reports whether the cells from index start up to end are empty.
+func (c *Cellbuf) blank(start, end int) bool {
+	for i := start; i < end && i < len(c.Cells); i++ {
+		if c.Cells[i].Ch != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Text returns the text from offset start up to end in logical order, with
+// the characters of a row separated by the empty cells between them, and
+// rows joined by a single space, or by nothing where a word is broken across
+// them.
+func (c *Cellbuf) Text(start, end int) string {
+	text, _ := c.logicalText(start, end)
+	return text
+}
+
+// logicalText returns Text(start, end), and the offset of the character at
+// each byte of it, or -1 for the spaces between characters.
+func (c *Cellbuf) logicalText(start, end int) (string, []int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(c.textCells) {
+		end = len(c.textCells)
+	}
+	var b strings.Builder
+	var offsets []int
+	space := func(n int) {
+		b.WriteString(strings.Repeat(" ", n))
+		for ; n > 0; n-- {
+			offsets = append(offsets, -1)
+		}
+	}
+	prev := -1
+	for o := start; o < end; o++ {
+		i := c.textCells[o]
+		if i == prev {
+			// Combining marks and hidden text share the cell of the
+			// character before them.
+			continue
+		}
+		if prev >= 0 {
+			lo, hi := c.cellEnd(prev), i
+			if i < prev {
+				lo, hi = c.cellEnd(i), prev
+			}
+			switch {
+			case c.Row(i) != c.Row(prev) && c.wordBreaks[o]:
+			case c.Row(i) != c.Row(prev) || !c.blank(lo, hi):
+				space(1)
+			case hi > lo:
+				space(hi - lo)
+			}
+		}
+		text := c.clusters[o]
+		if ch := c.Cells[i].Ch; text == "" && ch != 0 {
+			text = string(ch)
+		}
+		b.WriteString(text)
+		for range []byte(text) {
+			offsets = append(offsets, o)
+		}
+		prev = i
+	}
+	return b.String(), offsets
+}
+
 // style sets the foreground/background attributes for future cells in the cell
-// buffer document based on HTML tags in the tag stack.
-func (c *Cellbuf) style(tags []atom.Atom) {
-	fg := termbox.ColorDefault
-	for _, tag := range tags {
-		switch tag {
-		case atom.B, atom.Strong, atom.Em:
-			fg |= termbox.AttrBold
-		case atom.I:
-			fg |= termbox.ColorYellow
-		case atom.Title:
-			fg |= termbox.ColorRed
-		case atom.H1:
-			fg |= termbox.ColorMagenta
-		case atom.H2:
-			fg |= termbox.ColorBlue
-		case atom.H3, atom.H4, atom.H5, atom.H6:
-			fg |= termbox.ColorCyan
-		}
-	}
-	c.fg = fg
+// buffer document based on the open HTML elements.
+func (c *Cellbuf) style(elements []*element) {
+	s := c.theme.style(elements)
+	c.fg, c.bg = s.Fg, s.Bg
+}
+
+// skipText advances the text offset past text that is not displayed, such as
+// the content of elements with display: none, so that offsets keep matching
+// those of epub.CFILocation. Its characters are placed at the last character
+// displayed.
+func (c *Cellbuf) skipText(str string) {
+	if c.uncounted {
+		return
+	}
+	cell := 0
+	if n := len(c.textCells); n > 0 {
+		cell = c.textCells[n-1]
+	}
+	for _, r := range str {
+		if !unicode.IsSpace(r) {
+			c.textCells = append(c.textCells, cell)
+			c.offset++
+		}
+	}
+}
+
+// alignRows puts the text of rows first to last in visual order for their
+// direction, "ltr", "rtl" or "auto", and aligns it between lmargin and the
+// right edge to "center" or "right". Right-to-left rows are aligned to the
+// right unless align is "left". Rows already aligned by an inner block are
+// left as they are.
+func (c *Cellbuf) alignRows(first, last, lmargin int, align, dir string) {
+	if c.aligned == nil {
+		c.aligned = make(map[int]bool)
+	}
+	rtl := dir == "rtl" || dir == "auto" && c.firstStrong(first, last) == bidiR
+	for row := first; row <= last; row++ {
+		if c.aligned[row] {
+			continue
+		}
+		c.aligned[row] = true
+		if !c.Vertical {
+			c.reorderRow(row, lmargin, rtl, align != "left")
+		}
+		if align == "center" || align == "right" {
+			c.alignRow(row, lmargin, align)
+		}
+	}
+}
+
+// alignRow moves the text of a row to the center or right of the space
+// between lmargin and the right edge.
+func (c *Cellbuf) alignRow(row, lmargin int, align string) {
+	start := row * c.Width
+	lo, hi := -1, -1
+	for x := 0; x < c.Width && start+x < len(c.Cells); x++ {
+		if c.Cells[start+x].Ch != 0 {
+			if lo < 0 {
+				lo = x
+			}
+			hi = x
+		}
+	}
+	if lo < 0 {
+		return
+	}
+	if lmargin > lo {
+		lmargin = lo
+	}
+	shift := c.Width - 1 - hi
+	if align == "center" {
+		shift = (shift - (lo - lmargin)) / 2
+	}
+	if shift <= 0 {
+		return
+	}
+
+	cells := append([]termbox.Cell(nil), c.Cells[start+lo:start+hi+1]...)
+	for x := lo; x <= hi; x++ {
+		c.Cells[start+x] = termbox.Cell{}
+	}
+	for i, cell := range cells {
+		c.setCell(lo+shift+i, row, cell.Ch, cell.Fg, cell.Bg)
+	}
+	c.moveImages(row, func(im Image) int { return im.Col + shift })
+	// Rows in visual order are not sorted within, but still follow the rows
+	// before them.
+	for i := sort.SearchInts(c.textCells, start); i < len(c.textCells) && c.textCells[i] < start+c.Width; i++ {
+		if x := c.textCells[i] - start; x >= lo && x <= hi {
+			c.textCells[i] += shift
+		}
+	}
 }
 
 // appendText appends text to the cell buffer document.
@@ -89,15 +444,20 @@ func (c *Cellbuf) appendText(str string) {
 		if c.col != c.lmargin && c.space {
 			c.col++
 		}
-		word := []rune(scanner.Text())
-		if len(word) > c.Width-c.col {
-			c.row++
-			c.col = c.lmargin
-		}
-		for _, r := range word {
-			c.setCell(c.col, c.row, r, c.fg, c.bg)
-			c.col++
+		word := c.glyphs(scanner.Text())
+		for glyphsWidth(word) > c.Width-c.col {
+			n, hyphen := c.breakWord(word)
+			c.putWord(word[:n])
+			if hyphen {
+				c.setCell(c.col, c.row, '-', c.fg, c.bg)
+			}
+			word = word[n:]
+			c.wrapRow()
+			if n > 0 {
+				c.breakWordAt(c.offset)
+			}
 		}
+		c.putWord(word)
 		c.space = true
 	}
 	if !unicode.IsSpace(runes[len(runes)-1]) {
@@ -105,12 +465,66 @@ func (c *Cellbuf) appendText(str string) {
 	}
 }
 
+// newRow moves to the left margin of the next row, unless nothing has been
+// laid out in the current row past the left margin.
+func (c *Cellbuf) newRow() {
+	if c.col > c.lmargin {
+		c.row++
+	}
+	c.col = c.lmargin
+}
+
+// indent moves the left margin n columns to the right, leaving at least half
+// of the width for text.
+func (c *Cellbuf) indent(n int) {
+	c.lmargin += n
+	if c.lmargin > c.Width/2 {
+		c.lmargin = c.Width / 2
+	}
+	if c.col < c.lmargin {
+		c.col = c.lmargin
+	}
+}
+
+// appendLine writes str on a row of its own, starting at the left margin,
+// without wrapping or collapsing whitespace. It is used for decorations and
+// images whose size depends on the layout width, so it does not advance the
+// text offset.
+func (c *Cellbuf) appendLine(str string) {
+	if c.col > c.lmargin {
+		c.row++
+	}
+	c.col = c.lmargin
+	for _, g := range c.glyphs(str) {
+		if c.col+g.width > c.Width {
+			break
+		}
+		c.putGlyph(c.col, c.row, g, c.fg, c.bg)
+		c.col += g.width
+	}
+	c.row++
+	c.col = c.lmargin
+}
+
 // parseText takes in html content via an io.Reader and returns a buffer
-// containing only plain text.
-func ParseText(r io.Reader, items []epub.Item) (Cellbuf, error) {
+// containing only plain text, laid out in columns if vertical is set.
+func parseText(r io.Reader, items []epub.Item, opt Option, vertical bool) (Cellbuf, error) {
+	if opt.Width <= 0 {
+		opt.Width = defaultWidth
+	}
+	if vertical && opt.Height > 0 {
+		opt.Width = opt.Height
+	}
 	tokenizer := html.NewTokenizer(r)
-	doc := Cellbuf{Width: 80}
-	p := parser{tokenizer: tokenizer, doc: doc, items: items}
+	if opt.Theme == nil {
+		opt.Theme = DefaultTheme
+	}
+	doc := Cellbuf{Width: opt.Width, Vertical: vertical, theme: opt.Theme}
+	if opt.Hyphenate {
+		doc.hyphenator = hyphenatorFor(opt.Language)
+	}
+	p := parser{tokenizer: tokenizer, doc: doc, items: items, justify: opt.Justify,
+		baseDir: opt.Direction, image: opt.Image}
 	err := p.parse(r)
 	if err != nil {
 		return p.doc, err
@@ -126,17 +540,18 @@ func (p *parser) parse(io.Reader) (err error) {
 		switch tokenType {
 		case html.ErrorToken:
 			err = p.tokenizer.Err()
-		case html.StartTagToken:
-			p.tagStack = append(p.tagStack, token.DataAtom) // push element
-			fallthrough
-		case html.SelfClosingTagToken:
-			p.handleStartTag(token)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			p.handleStartTag(token, tokenType == html.SelfClosingTagToken)
 		case html.TextToken:
 			p.handleText(token)
 		case html.EndTagToken:
-			p.tagStack = p.tagStack[:len(p.tagStack)-1] // pop element
+			p.handleEndTag(token)
 		}
 		if err == io.EOF {
+			// Close the elements left open, to lay out their content.
+			for len(p.stack) > 0 {
+				p.endElement()
+			}
 			return nil
 		} else if err != nil {
 			return err
@@ -145,58 +560,237 @@ func (p *parser) parse(io.Reader) (err error) {
 }
 
 // handleText appends text elements to the parser buffer. It filters elements
-// that should not be displayed as text (e.g. style blocks).
+// that should not be displayed as text (e.g. style blocks), and reads the
+// style sheets in style blocks.
 func (p *parser) handleText(token html.Token) {
-	// Skip style tags
-	if len(p.tagStack) > 0 && p.tagStack[len(p.tagStack)-1] == atom.Style {
+	top := p.top()
+	if top != nil && top.atom == atom.Style {
+		p.css.parse(token.Data)
 		return
 	}
-	p.doc.style(p.tagStack)
-	p.doc.appendText(string(token.Data))
+	hidden := top != nil && top.hidden
+	if !hidden {
+		p.writingMode()
+	}
+	if hidden && p.table == nil {
+		p.doc.skipText(token.Data)
+		return
+	}
+	p.doc.style(p.stack)
+	text := token.Data
+	if t := p.inherited(func(s *cssStyle) string { return s.transform }); t != "" {
+		text = transform(text, t, p.doc.space || p.doc.col <= p.doc.lmargin)
+	}
+	text = p.raise(text)
+	if p.table != nil {
+		p.table.add(tableRun{text: text, style: Style{p.doc.fg, p.doc.bg}, hidden: hidden})
+		return
+	}
+	if p.pre() != nil {
+		if p.preStart {
+			// A line break right after a pre start tag is not part of its
+			// content.
+			text = strings.TrimPrefix(strings.TrimPrefix(text, "\r"), "\n")
+			p.preStart = false
+		}
+		p.doc.appendPre(text)
+		return
+	}
+	align := p.align()
+	p.doc.justify = p.justify && (align == "" || align == "justify")
+	p.doc.appendText(text)
 }
 
-// handleStartTag appends text representations of non-text elements (e.g. image alt
-// tags) to the parser buffer.
-func (p *parser) handleStartTag(token html.Token) {
+// top returns the innermost open element, or nil if there is none.
+func (p *parser) top() *element {
+	if len(p.stack) == 0 {
+		return nil
+	}
+	return p.stack[len(p.stack)-1]
+}
+
+// inherited returns the value of an inherited CSS property for the innermost
+// open element: the value set on the nearest element that sets it.
+func (p *parser) inherited(property func(*cssStyle) string) string {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		if v := property(&p.stack[i].css); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// textIndent returns the first line indent of the innermost open element,
+// which is a block of the given element type.
+func (p *parser) textIndent(a atom.Atom) int {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		if css := p.stack[i].css; css.hasIndent {
+			return css.indent
+		}
+	}
+	if a == atom.P {
+		return paragraphIndent
+	}
+	return 0
+}
+
+// handleStartTag opens an element, styled by the document's style sheets, and
+// appends text representations of non-text elements (e.g. image alt tags) to
+// the parser buffer. Elements without an end tag are closed right away.
+func (p *parser) handleStartTag(token html.Token, selfClosing bool) {
+	e := p.css.newElement(token, p.stack, p.doc.Width)
+	e.hidden = e.css.display == "none" || (p.top() != nil && p.top().hidden)
+	e.noteType = noteType(token)
+	p.startNote(e)
+	p.startLink(token, e)
+	p.preStart = false
+	p.stack = append(p.stack, e)
+	if token.DataAtom == atom.Link {
+		p.handleLink(token)
+	}
+	switch {
+	case e.hidden:
+	case p.table != nil:
+		p.tableStartTag(token, e)
+	default:
+		p.layoutStartTag(token, e)
+	}
+	if selfClosing || voidElements[token.DataAtom] {
+		p.endElement()
+	}
+}
+
+// layoutStartTag starts the layout of an element.
+func (p *parser) layoutStartTag(token html.Token, e *element) {
+	e.block = blockElements[e.atom]
+	paragraph := paragraphElements[e.atom]
+	switch e.css.display {
+	case "block":
+		paragraph = paragraph || !e.block
+		e.block = true
+	case "inline":
+		e.block, paragraph = false, false
+	}
+	e.lmargin = p.doc.lmargin
+
 	switch token.DataAtom {
+	case atom.Body:
+		p.rootWritingMode()
 	case atom.Img:
 		p.handleImage(token)
 	case atom.Br:
 		p.doc.row++
 		p.doc.col = p.doc.lmargin
-	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Title,
-		atom.Div, atom.Tr:
-		p.doc.row += 2
-		p.doc.col = p.doc.lmargin
-	case atom.P:
-		p.doc.row += 2
-		p.doc.col = p.doc.lmargin
-		p.doc.col += 2
 	case atom.Hr:
 		p.doc.row++
-		p.doc.col = 0
-		p.doc.appendText(strings.Repeat("-", p.doc.Width))
+		p.doc.appendLine(strings.Repeat("-", p.doc.Width-p.doc.lmargin))
+	}
+	if e.block {
+		p.doc.indent(e.css.margin)
+	}
+	switch token.DataAtom {
+	case atom.Ul, atom.Ol, atom.Dl:
+		p.startList(token, e)
+	case atom.Li:
+		p.startItem(token, e)
+	case atom.Dt, atom.Dd:
+		p.startDefinition(e)
+	case atom.Table:
+		p.startTable(e)
+	case atom.Pre:
+		p.startPre()
+	case atom.Blockquote, atom.Aside:
+		if e.block {
+			p.startQuote(e)
+		}
+	}
+	if paragraph && !p.atBlockStart() {
+		p.doc.row += 2
+		p.doc.col = p.doc.lmargin + p.textIndent(e.atom)
+	}
+	e.startRow = p.doc.row
+
+	for _, a := range token.Attr {
+		if a.Key == "id" || (a.Key == "name" && token.DataAtom == atom.A) {
+			p.doc.setAnchor(a.Val)
+		}
+	}
+}
+
+// handleEndTag closes the innermost open element with the tag's name, and
+// any elements left open within it. End tags without an open element are
+// ignored.
+func (p *parser) handleEndTag(token html.Token) {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		if p.stack[i].tag == token.Data {
+			for len(p.stack) > i {
+				p.endElement()
+			}
+			return
+		}
+	}
+}
+
+// endElement closes the innermost open element, aligning its text and
+// restoring the left margin if it is a block.
+func (p *parser) endElement() {
+	e := p.top()
+	if p.table != nil && p.table.elem == e {
+		p.endTable()
+	} else if p.table != nil && !e.hidden {
+		p.tableEndTag(e)
+	}
+	if e.atom == atom.Pre && !e.hidden && p.table == nil {
+		p.endPre()
+	}
+	if e.block && !e.hidden {
+		p.doc.alignRows(e.startRow, p.doc.row, p.doc.lmargin, p.align(), p.direction())
+		if e.gutter > 0 {
+			p.endQuote(e)
+		}
+		p.doc.lmargin = e.lmargin
+	}
+	p.endLink(e)
+	p.stack = p.stack[:len(p.stack)-1]
+}
+
+// handleLink reads linked style sheets.
+func (p *parser) handleLink(token html.Token) {
+	var rel, href string
+	for _, a := range token.Attr {
+		switch a.Key {
+		case "rel":
+			rel = strings.ToLower(a.Val)
+		case "href":
+			href = a.Val
+		}
+	}
+	for _, r := range strings.Fields(rel) {
+		if r == "stylesheet" {
+			if item, ok := findItem(p.items, href); ok {
+				p.css.load(item)
+			}
+			return
+		}
 	}
 }
 
 // handleImage appends image elements to the parser buffer. It extracts alt
-// text and converts images to ascii art.
+// text and draws images as text, in the mode of the image option.
 func (p *parser) handleImage(token html.Token) {
 	for _, a := range token.Attr {
 		switch atom.Lookup([]byte(a.Key)) {
 		case atom.Alt:
 			text := fmt.Sprintf("Alt text: %s", a.Val)
+			p.doc.uncounted = true
 			p.doc.appendText(text)
+			p.doc.uncounted = false
 			p.doc.row++
 			p.doc.col = p.doc.lmargin
 		case atom.Src:
 			for _, item := range p.items {
 				if item.HREF == a.Val {
-					for _, line := range imageToText(item) {
-						p.doc.appendText(line)
-						p.doc.col = p.doc.lmargin
-						p.doc.row++
-					}
+					p.appendImage(item)
 					break
 				}
 			}
@@ -204,37 +798,22 @@ func (p *parser) handleImage(token html.Token) {
 	}
 }
 
-func imageToText(item epub.Item) []string {
-	lines := []string{}
-	r, err := item.Open()
-	if err != nil {
-		return lines
-	}
-
-	img, _, err := image.Decode(r)
-	if err != nil {
-		return lines
+// appendImage draws the image of an item, or reserves space for it if
+// images are shown with a terminal graphics protocol. Vertical text has no
+// room for images in rows, so they are drawn as ascii art there.
+func (p *parser) appendImage(item epub.Item) {
+	img := decodeImage(item)
+	if img == nil {
+		return
 	}
-	bounds := img.Bounds()
-
-	// Assume a character height to width ratio of 2:1.
-	w := 80
-	h := (bounds.Max.Y * w) / (bounds.Max.X * 2)
-	img = resize.Resize(uint(w), uint(h), img, resize.Lanczos3)
-
-	charGradient := []rune("MND8OZ$7I?+=~:,..")
-	buf := new(bytes.Buffer)
-
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			c := color.GrayModel.Convert(img.At(x, y))
-			y := c.(color.Gray).Y
-			pos := (len(charGradient) - 1) * int(y) / 255
-			buf.WriteRune(charGradient[pos])
-		}
-		lines = append(lines, buf.String())
-		buf = new(bytes.Buffer)
+	w := p.doc.Width - p.doc.lmargin
+	opt := p.image
+	switch {
+	case opt.Graphics() && !p.doc.Vertical:
+		p.doc.appendGraphic(img, w, opt)
+		return
+	case opt.Graphics():
+		opt.Mode = ImageASCII
 	}
-
-	return lines
+	p.doc.appendImage(drawImage(img, w, opt))
 }
@@ -0,0 +1,98 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wormggmm/goreader/epub"
+)
+
+func TestOffsetAcrossWidths(t *testing.T) {
+	const text = "<p>Alice was beginning to get very tired of sitting by her sister on the bank, and of having nothing to do.</p>"
+
+	narrow, err := ParseText(strings.NewReader(text), nil, Option{Width: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wide, err := ParseText(strings.NewReader(text), nil, Option{Width: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The word at the start of a row in one layout must be found on the row
+	// RowAt returns in the other.
+	for row := 0; row < narrow.Rows(); row++ {
+		line := narrow.Line(row)
+		if line == "" {
+			continue
+		}
+		word := strings.Fields(line)[0]
+		other := wide.Line(wide.RowAt(narrow.Offset(row)))
+		if !strings.Contains(other, word) {
+			t.Errorf("Expected: %q to contain %q\n", other, word)
+		}
+	}
+}
+
+func TestOffsetMatchesCFI(t *testing.T) {
+	rc, err := epub.OpenReader("../epub/_test_files/alice.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	book := rc.Rootfiles[0]
+
+	f, err := book.Spine.Itemrefs[2].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := ParseText(f, book.Manifest.Items, Option{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first row of the chapter's text, after the heading and the drop cap
+	// image with its alt text.
+	loc, err := book.ResolveCFI("epubcfi(/6/6!/4/8/1:0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := doc.Line(doc.RowAt(loc.TextOffset))
+	if !strings.HasPrefix(line, "LICE was beginning") {
+		t.Errorf("Expected: %q to start with %q\n", line, "LICE was beginning")
+	}
+}
+
+func TestTextRegions(t *testing.T) {
+	const text = "<p>Alice was beginning to get very tired of sitting by her sister on the bank.</p>"
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "beginning to get very" wraps before "to".
+	start := len("Alicewas")
+	end := start + len("beginningtogetvery")
+	if got := doc.Text(start, end); got != "beginning to get very" {
+		t.Errorf("Expected: %q, but got: %q\n", "beginning to get very", got)
+	}
+	regions := doc.TextRegions(start, end)
+	if len(regions) != 2 {
+		t.Fatalf("Expected: %v, but got: %v\n", 2, len(regions))
+	}
+	for _, r := range regions {
+		if doc.Row(r.Start) != doc.Row(r.End-1) {
+			t.Errorf("Expected region %v on a single row\n", r)
+		}
+	}
+
+	for offset := 0; offset < doc.TextLen(); offset++ {
+		if got := doc.TextOffset(doc.TextCell(offset)); got != offset {
+			t.Errorf("Expected: %v, but got: %v\n", offset, got)
+		}
+	}
+	if got := doc.TextCell(doc.TextLen()); got != -1 {
+		t.Errorf("Expected: %v, but got: %v\n", -1, got)
+	}
+}
@@ -0,0 +1,160 @@
+package parse
+
+import (
+	"strings"
+	"unicode"
+
+	termbox "github.com/nsf/termbox-go"
+	"golang.org/x/net/html/atom"
+)
+
+// tabWidth is the distance between the tab stops of preformatted text.
+const tabWidth = 8
+
+// Block is a block of preformatted text, whose lines are laid out as they are
+// instead of being wrapped. Cells only holds the part of each line that fits
+// in the layout width; Lines holds the whole lines, from column X of the
+// rows starting at Row, so that the block can be scrolled horizontally.
+type Block struct {
+	Row   int
+	X     int
+	Lines [][]termbox.Cell
+}
+
+// Width returns the number of columns of the block's longest line.
+func (b Block) Width() int {
+	w := 0
+	for _, line := range b.Lines {
+		if len(line) > w {
+			w = len(line)
+		}
+	}
+	return w
+}
+
+// Cell returns the cell at the given column of a line of the block, or an
+// empty cell if the line is shorter.
+func (b Block) Cell(line, col int) termbox.Cell {
+	if line < 0 || line >= len(b.Lines) || col < 0 || col >= len(b.Lines[line]) {
+		return termbox.Cell{}
+	}
+	return b.Lines[line][col]
+}
+
+// startPre starts a pre element on a new row, separated from the text before
+// it by a blank row. Pre elements within another only change the style of
+// their text.
+func (p *parser) startPre() {
+	if p.pre() != p.top() {
+		return
+	}
+	if !p.atBlockStart() {
+		p.doc.row += 2
+	}
+	p.doc.col = p.doc.lmargin
+	p.doc.startBlock()
+	p.preStart = true
+}
+
+// endPre ends the block of the outermost pre element.
+func (p *parser) endPre() {
+	if p.pre() != p.top() {
+		return
+	}
+	p.doc.endBlock()
+}
+
+// pre returns the outermost open pre element, or nil if there is none.
+func (p *parser) pre() *element {
+	for _, e := range p.stack {
+		if e.atom == atom.Pre {
+			return e
+		}
+	}
+	return nil
+}
+
+// startBlock starts a block of preformatted text at the current position.
+func (c *Cellbuf) startBlock() {
+	c.Blocks = append(c.Blocks, Block{Row: c.row, X: c.col})
+}
+
+// endBlock ends the current block of preformatted text after its last line,
+// keeping its rows from being aligned by the blocks around it. Like a table,
+// it leaves the current row full, so that text after it starts a new row.
+func (c *Cellbuf) endBlock() {
+	b := &c.Blocks[len(c.Blocks)-1]
+	last := c.row
+	if c.col == b.X && last > b.Row {
+		// The block ends with a line break.
+		last--
+	}
+	for len(b.Lines) <= last-b.Row {
+		b.Lines = append(b.Lines, nil)
+	}
+	if c.aligned == nil {
+		c.aligned = make(map[int]bool)
+	}
+	for row := b.Row; row <= last; row++ {
+		c.aligned[row] = true
+	}
+	c.row = last
+	c.col = c.Width
+	c.space = false
+}
+
+// appendPre appends preformatted text to the current block, keeping its
+// spaces and line breaks and expanding its tabs. Text past the right edge is
+// only kept in the block's lines; its offsets are placed at the last cell of
+// the row.
+func (c *Cellbuf) appendPre(str string) {
+	b := &c.Blocks[len(c.Blocks)-1]
+	str = strings.ReplaceAll(str, "\r\n", "\n")
+	for _, g := range c.glyphs(str) {
+		r := g.runes[0]
+		switch r {
+		case '\n':
+			c.row++
+			c.col = b.X
+			continue
+		case '\t':
+			c.col += tabWidth - (c.col-b.X)%tabWidth
+			continue
+		}
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			c.col++
+			continue
+		}
+
+		line := c.row - b.Row
+		for len(b.Lines) <= line {
+			b.Lines = append(b.Lines, nil)
+		}
+		for len(b.Lines[line]) < c.col-b.X {
+			b.Lines[line] = append(b.Lines[line], termbox.Cell{})
+		}
+		b.Lines[line] = append(b.Lines[line], termbox.Cell{Ch: composed(g.runes)[0], Fg: c.fg, Bg: c.bg})
+		if g.width == 2 {
+			b.Lines[line] = append(b.Lines[line], termbox.Cell{Ch: Continuation, Fg: c.fg, Bg: c.bg})
+		}
+
+		cell := c.row*c.Width + c.Width - 1
+		if c.col+g.width <= c.Width {
+			cell = c.row*c.Width + c.col
+			c.putGlyph(c.col, c.row, g, c.fg, c.bg)
+		}
+		for cell >= len(c.Cells) {
+			c.Cells = append(c.Cells, make([]termbox.Cell, 1024)...)
+		}
+		if !c.uncounted {
+			c.markOffset(c.row)
+			c.setCluster(c.offset, g.runes)
+			for range g.runes {
+				c.textCells = append(c.textCells, cell)
+				c.offset++
+			}
+		}
+		c.col += g.width
+	}
+	c.space = false
+}
@@ -0,0 +1,86 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+func TestPre(t *testing.T) {
+	const text = `<p>Before</p>
+<pre>
+func f() {
+	return  "a long string literal"
+}
+</pre>
+<p>After</p>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := layout(&doc)
+	want := `  Before
+
+func f() {
+        return  "a long
+}
+
+   After`
+	if got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+
+	if len(doc.Blocks) != 1 {
+		t.Fatalf("Expected: %v, but got: %v\n", 1, len(doc.Blocks))
+	}
+	b := doc.Blocks[0]
+	if b.Row != 4 || len(b.Lines) != 3 {
+		t.Errorf("Expected: %v, but got: %v\n", "row 4 with 3 lines", b)
+	}
+	var line strings.Builder
+	for _, cell := range b.Lines[1] {
+		if cell.Ch == 0 {
+			cell.Ch = ' '
+		}
+		line.WriteRune(cell.Ch)
+	}
+	if want := `        return  "a long string literal"`; line.String() != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, line.String())
+	}
+	if b.Width() != 39 {
+		t.Errorf("Expected: %v, but got: %v\n", 39, b.Width())
+	}
+
+	// Text past the right edge still has offsets, placed at the row's last
+	// cell, so the text after the block keeps the offsets of the document.
+	start := strings.Index(text, "After")
+	wantOffset := len(strings.Join(strings.Fields(text[:start]), "")) -
+		len("<p></p><pre></pre><p>")
+	if got := doc.TextLen() - len("After"); got != wantOffset {
+		t.Errorf("Expected: %v, but got: %v\n", wantOffset, got)
+	}
+	if got, want := doc.Text(doc.TextLen()-len("After"), doc.TextLen()), "After"; got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+}
+
+func TestPreStyle(t *testing.T) {
+	const text = `<pre>x <b>y</b></pre>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := doc.Cells[doc.TextCell(0)]
+	if x.Ch != 'x' || x.Fg != termbox.ColorGreen {
+		t.Errorf("Expected: %v, but got: %v\n", "green x", x)
+	}
+	y := doc.Cells[doc.TextCell(1)]
+	if y.Ch != 'y' || y.Fg&termbox.AttrBold == 0 {
+		t.Errorf("Expected: %v, but got: %v\n", "bold y", y)
+	}
+}
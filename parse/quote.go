@@ -0,0 +1,63 @@
+package parse
+
+import "golang.org/x/net/html/atom"
+
+// quoteIndent is the number of columns blockquotes and asides are indented
+// by, including the bar in their gutter.
+const quoteIndent = 4
+
+// gutterBars are the characters of the bars drawn in the gutter of
+// blockquotes and asides.
+var gutterBars = map[atom.Atom]rune{
+	atom.Blockquote: '│',
+	atom.Aside:      '┆',
+}
+
+// centered are the elements whose text is centered unless CSS aligns it.
+var centered = map[atom.Atom]bool{
+	atom.Figure:     true,
+	atom.Figcaption: true,
+}
+
+// startQuote indents a blockquote or aside, leaving a gutter for a bar at the
+// left of its text.
+func (p *parser) startQuote(e *element) {
+	e.gutter = p.doc.lmargin + quoteIndent/2
+	p.doc.indent(quoteIndent)
+}
+
+// endQuote draws the bar in the gutter of a blockquote or aside, along the
+// rows of its text. There is no bar if the margin left no room for it.
+func (p *parser) endQuote(e *element) {
+	if e.gutter >= p.doc.lmargin-1 {
+		return
+	}
+	last := p.doc.row
+	if p.doc.col <= p.doc.lmargin {
+		last--
+	}
+	p.doc.style(p.stack)
+	for row := e.startRow; row <= last; row++ {
+		p.doc.setCell(e.gutter, row, gutterBars[e.atom], p.doc.fg, p.doc.bg)
+	}
+}
+
+// align returns the text alignment of the innermost open element: the
+// inherited CSS text-align, or "center" within figures. The start and end of
+// rows are left and right, or right and left in right-to-left text.
+func (p *parser) align() string {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		switch a := p.stack[i].css.align; {
+		case a == "start" && p.direction() == "rtl", a == "end" && p.direction() != "rtl":
+			return "right"
+		case a == "start", a == "end":
+			return "left"
+		case a != "":
+			return a
+		}
+		if centered[p.stack[i].atom] {
+			return "center"
+		}
+	}
+	return ""
+}
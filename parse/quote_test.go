@@ -0,0 +1,35 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	const text = `<p>Before</p>
+<blockquote><p>A quoted paragraph that wraps.</p><p>Second.</p></blockquote>
+<aside><p>Aside</p></aside>
+<figure><p>Picture</p><figcaption>Fig. 1</figcaption></figure>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := layout(&doc)
+	want := `  Before
+
+  │ A quoted paragraph
+  │ that wraps.
+  │
+  │   Second.
+
+  ┆ Aside
+
+        Picture
+
+         Fig. 1`
+	if got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+}
@@ -0,0 +1,124 @@
+package parse
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/wormggmm/goreader/epub"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Region is a range of cells in a Cellbuf, given as indices into Cells. End is
+// exclusive.
+type Region struct {
+	Start, End int
+}
+
+// Match is a search hit within a chapter. Hits that wrap across lines are
+// split into one Match per line.
+type Match struct {
+	Chapter int
+	Row     int
+	Line    string // the text of Row
+	Region
+}
+
+// Rows returns the number of rows in the cell buffer document.
+func (c *Cellbuf) Rows() int {
+	if c.Width <= 0 {
+		return 0
+	}
+	return (len(c.Cells) + c.Width - 1) / c.Width
+}
+
+// Row returns the row containing the cell at index i.
+func (c *Cellbuf) Row(i int) int {
+	return i / c.Width
+}
+
+// Line returns the text of a row, with unset cells as spaces and surrounding
+// space trimmed.
+func (c *Cellbuf) Line(row int) string {
+	var b strings.Builder
+	for x := 0; x < c.Width; x++ {
+		i := row*c.Width + x
+		if i >= len(c.Cells) {
+			break
+		}
+		ch := c.Cells[i].Ch
+		if ch == Continuation {
+			continue
+		}
+		if ch == 0 {
+			ch = ' '
+		}
+		b.WriteRune(ch)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Find returns the regions of the document matched by re. The text is
+// searched in logical order, as Text returns it, so phrases that wrap across
+// rows, words broken across rows and right-to-left text are still found.
+// Matches are returned as the regions of their characters, one per row.
+func (c *Cellbuf) Find(re *regexp.Regexp) []Region {
+	text, offsets := c.logicalText(0, c.TextLen())
+	var regions []Region
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		first, last := -1, -1
+		for _, o := range offsets[loc[0]:loc[1]] {
+			if o < 0 {
+				continue
+			}
+			if first < 0 {
+				first = o
+			}
+			last = o
+		}
+		if first >= 0 {
+			regions = append(regions, c.TextRegions(first, last+1)...)
+		}
+	}
+	return regions
+}
+
+// CompileSearch compiles a search pattern. Patterns are regular expressions
+// and match case-insensitively unless caseSensitive is set.
+func CompileSearch(pattern string, caseSensitive bool) (*regexp.Regexp, error) {
+	// Text returns characters with combining marks composed, so they are
+	// found however the pattern was typed.
+	pattern = norm.NFC.String(pattern)
+	if !caseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// Search renders every item in the book's spine with the given layout options
+// and returns the matches of re in reading order.
+func Search(book *epub.Rootfile, re *regexp.Regexp, opt Option) ([]Match, error) {
+	var matches []Match
+	for chapter, itemref := range book.Spine.Itemrefs {
+		f, err := itemref.Open()
+		if err != nil {
+			return matches, err
+		}
+		doc, err := ParseText(f, book.Manifest.Items, opt)
+		f.Close()
+		if err != nil {
+			return matches, err
+		}
+
+		for _, region := range doc.Find(re) {
+			row := doc.Row(region.Start)
+			matches = append(matches, Match{
+				Chapter: chapter,
+				Row:     row,
+				Line:    doc.Line(row),
+				Region:  region,
+			})
+		}
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,85 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	doc := Cellbuf{Width: 12}
+	doc.appendText("The quick brown fox jumps over the lazy dog.")
+
+	re, err := CompileSearch("the", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := doc.Find(re)
+	if len(matches) != 2 {
+		t.Fatalf("Expected: %v, but got: %v\n", 2, len(matches))
+	}
+
+	// Phrases wrapping across lines are split into one region per line.
+	re, err = CompileSearch(`fox\s+jumps`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches = doc.Find(re)
+	if len(matches) != 2 {
+		t.Fatalf("Expected: %v, but got: %v\n", 2, len(matches))
+	}
+	for i, exp := range []string{"fox", "jumps"} {
+		var got []rune
+		for j := matches[i].Start; j < matches[i].End; j++ {
+			got = append(got, doc.Cells[j].Ch)
+		}
+		if string(got) != exp {
+			t.Errorf("Expected: %v, but got: %v\n", exp, string(got))
+		}
+	}
+
+	re, err = CompileSearch("the", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches = doc.Find(re); len(matches) != 1 {
+		t.Errorf("Expected: %v, but got: %v\n", 1, len(matches))
+	}
+}
+
+func TestFindLogicalOrder(t *testing.T) {
+	tests := []struct {
+		text    string
+		opt     Option
+		pattern string
+		want    []string // the cells of each region
+	}{
+		// Right-to-left words are stored reversed in the cells.
+		{`<p dir="rtl">שלום עולם</p>`, Option{Width: 20}, "שלום", []string{"םולש"}},
+		// Hyphenated words are found whole, without their hyphen.
+		{"<p>Alice was beginning to get very tired</p>", Option{Width: 10, Hyphenate: true, Language: "en"},
+			"beginning", []string{"begin", "ning"}},
+		{"<p>Alice was beginning to get very tired</p>", Option{Width: 10, Hyphenate: true, Language: "en"},
+			"was beginning to", []string{"was begin", "ning to"}},
+	}
+	for _, tt := range tests {
+		doc, err := ParseText(strings.NewReader(tt.text), nil, tt.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		re, err := CompileSearch(tt.pattern, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range doc.Find(re) {
+			var s []rune
+			for i := r.Start; i < r.End; i++ {
+				s = append(s, doc.Cells[i].Ch)
+			}
+			got = append(got, strings.ReplaceAll(string(s), "\x00", " "))
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("Expected: %q, but got: %q\n", tt.want, got)
+		}
+	}
+}
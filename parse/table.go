@@ -0,0 +1,699 @@
+package parse
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Tables are collected cell by cell until the end of the table element, then
+// drawn as a grid with box drawing borders. Tables too wide for the layout
+// width are drawn stacked instead: each cell on rows of its own, labelled
+// with its column's header.
+
+// tableRun is a piece of the text of a table cell, drawn with one style.
+type tableRun struct {
+	text      string
+	style     Style
+	uncounted bool // not part of the document's text, such as image alt text
+	hidden    bool // part of the document's text, but not displayed
+	lineBreak bool // a line break instead of text
+}
+
+// maxColspan is the largest colspan honored, as in the HTML standard, which
+// keeps huge spans from taking all memory.
+const maxColspan = 1000
+
+type tableCell struct {
+	runs    []tableRun
+	colspan int
+	header  bool
+}
+
+type tableRow struct {
+	cells  []*tableCell
+	header bool
+}
+
+// table is a table element whose cells are being collected.
+type table struct {
+	elem      *element
+	style     Style // the style of the borders
+	align     string
+	caption   []tableRun
+	rows      []*tableRow
+	inHead    bool
+	inCaption bool
+	nested    int  // depth of the tables within the table, which are flattened
+	vertical  bool // whether the table is laid out in vertical mode
+}
+
+// cellRune is a character of a table cell. Spaces between words are zero.
+// Combining marks have no width, and are displayed with the character before
+// them.
+type cellRune struct {
+	r         rune
+	width     int
+	style     Style
+	uncounted bool
+	hidden    bool
+}
+
+// borders are the box drawing characters of a border row. cross, down and
+// up join the border with column edges above and below it, below it only, or
+// above it only.
+type borders struct {
+	line                    rune
+	left, right             rune
+	cross, down, up         rune
+	topLeft, topRight       rune
+	bottomLeft, bottomRight rune
+}
+
+var (
+	singleBorders = borders{
+		line: '─', left: '├', right: '┤', cross: '┼', down: '┬', up: '┴',
+		topLeft: '┌', topRight: '┐', bottomLeft: '└', bottomRight: '┘',
+	}
+	// headerBorders separate the header rows from the rest of the table.
+	headerBorders = borders{
+		line: '═', left: '╞', right: '╡', cross: '╪', down: '╤', up: '╧',
+	}
+)
+
+// startTable starts collecting the cells of a table, on a new row separated
+// from the text before it by a blank row.
+func (p *parser) startTable(e *element) {
+	p.doc.newRow()
+	p.doc.row++
+	p.doc.style(p.stack)
+	p.table = &table{
+		elem:     e,
+		style:    Style{Fg: p.doc.fg, Bg: p.doc.bg},
+		align:    p.align(),
+		vertical: p.doc.Vertical,
+	}
+}
+
+// tableStartTag adds the start of an element within a table to the table.
+// Elements that start a new line of text add a line break to the current
+// cell.
+func (p *parser) tableStartTag(token html.Token, e *element) {
+	t := p.table
+	switch e.atom {
+	case atom.Table:
+		t.nested++
+		t.addBreak()
+	case atom.Caption:
+		t.inCaption = t.nested == 0
+	case atom.Thead:
+		t.inHead = t.nested == 0 || t.inHead
+	case atom.Tbody, atom.Tfoot:
+		if t.nested == 0 {
+			t.inHead = false
+		}
+	case atom.Tr:
+		if t.nested > 0 {
+			t.addBreak()
+		} else {
+			t.rows = append(t.rows, &tableRow{header: t.inHead})
+		}
+	case atom.Td, atom.Th:
+		if t.nested > 0 {
+			t.add(tableRun{text: " "})
+			break
+		}
+		cell := &tableCell{colspan: 1, header: e.atom == atom.Th}
+		for _, a := range token.Attr {
+			if a.Key == "colspan" {
+				if n, err := strconv.Atoi(a.Val); err == nil && n > 1 {
+					if n > maxColspan {
+						n = maxColspan
+					}
+					cell.colspan = n
+				}
+			}
+		}
+		row := t.row()
+		row.cells = append(row.cells, cell)
+	case atom.Br, atom.P, atom.Div, atom.Li, atom.Dt, atom.Dd, atom.H1, atom.H2,
+		atom.H3, atom.H4, atom.H5, atom.H6, atom.Blockquote, atom.Pre, atom.Hr:
+		t.addBreak()
+	case atom.Img:
+		for _, a := range token.Attr {
+			if a.Key == "alt" && a.Val != "" {
+				p.doc.style(p.stack)
+				t.add(tableRun{text: " " + a.Val + " ", style: Style{p.doc.fg, p.doc.bg}, uncounted: true})
+			}
+		}
+	}
+
+	for _, a := range token.Attr {
+		if a.Key == "id" || (a.Key == "name" && token.DataAtom == atom.A) {
+			p.doc.setAnchor(a.Val)
+		}
+	}
+}
+
+// tableEndTag adds the end of an element within a table to the table.
+func (p *parser) tableEndTag(e *element) {
+	t := p.table
+	switch e.atom {
+	case atom.Table:
+		t.nested--
+	case atom.Caption:
+		t.inCaption = false
+	case atom.Thead:
+		if t.nested == 0 {
+			t.inHead = false
+		}
+	}
+}
+
+// endTable lays out the collected table.
+func (p *parser) endTable() {
+	t := p.table
+	p.table = nil
+	if widths, ok := t.columnWidths(p.doc.Width - p.doc.lmargin); ok {
+		t.drawGrid(&p.doc, widths)
+	} else {
+		t.drawStacked(&p.doc)
+	}
+}
+
+// row returns the current row, adding one if there is none.
+func (t *table) row() *tableRow {
+	if len(t.rows) == 0 {
+		t.rows = append(t.rows, &tableRow{header: t.inHead})
+	}
+	return t.rows[len(t.rows)-1]
+}
+
+// add adds a run of text to the caption or the current cell. Whitespace
+// outside of cells is ignored.
+func (t *table) add(run tableRun) {
+	if t.inCaption {
+		t.caption = append(t.caption, run)
+		return
+	}
+	if len(t.rows) == 0 || len(t.row().cells) == 0 {
+		if strings.TrimSpace(run.text) == "" && !run.hidden {
+			return
+		}
+		row := t.row()
+		row.cells = append(row.cells, &tableCell{colspan: 1})
+	}
+	row := t.row()
+	cell := row.cells[len(row.cells)-1]
+	cell.runs = append(cell.runs, run)
+}
+
+// addBreak adds a line break to the caption or the current cell.
+func (t *table) addBreak() {
+	t.add(tableRun{lineBreak: true})
+}
+
+// columns returns the number of columns of the table.
+func (t *table) columns() int {
+	n := 0
+	for _, row := range t.rows {
+		cols := 0
+		for _, cell := range row.cells {
+			cols += cell.colspan
+		}
+		if cols > n {
+			n = cols
+		}
+	}
+	return n
+}
+
+// placedCell is a cell with the columns it spans. Cells missing at the end of
+// a row are filled with empty cells.
+type placedCell struct {
+	*tableCell
+	col, span int
+}
+
+// place returns the cells of a row in a table with n columns.
+func (r *tableRow) place(n int) []placedCell {
+	var cells []placedCell
+	col := 0
+	for _, cell := range r.cells {
+		if col >= n {
+			break
+		}
+		span := cell.colspan
+		if col+span > n {
+			span = n - col
+		}
+		cells = append(cells, placedCell{cell, col, span})
+		col += span
+	}
+	for ; col < n; col++ {
+		cells = append(cells, placedCell{&tableCell{colspan: 1}, col, 1})
+	}
+	return cells
+}
+
+// isHeader reports whether the row is a header row: a row of a thead, or a
+// row of th cells only.
+func (r *tableRow) isHeader() bool {
+	if r.header {
+		return true
+	}
+	for _, cell := range r.cells {
+		if !cell.header {
+			return false
+		}
+	}
+	return len(r.cells) > 0
+}
+
+// edges reports, for each column boundary of a table with n columns, whether
+// a cell of the row ends there.
+func (r *tableRow) edges(n int) []bool {
+	edges := make([]bool, n+1)
+	for _, cell := range r.place(n) {
+		edges[cell.col] = true
+	}
+	edges[n] = true
+	return edges
+}
+
+// columnWidths returns the width of each column of the table, so that it
+// fits in width columns with its borders. It reports false if the table does
+// not fit, even with its cells wrapped to their longest word.
+func (t *table) columnWidths(width int) ([]int, bool) {
+	n := t.columns()
+	if n == 0 {
+		return nil, false
+	}
+	minWidths := make([]int, n)
+	maxWidths := make([]int, n)
+	for i := range minWidths {
+		minWidths[i], maxWidths[i] = 1, 1
+	}
+
+	// Cells spanning several columns widen the last of them if needed, once
+	// the widths of the other cells are known.
+	for _, spanning := range []bool{false, true} {
+		for _, row := range t.rows {
+			for _, cell := range row.place(n) {
+				if (cell.span > 1) != spanning {
+					continue
+				}
+				words := cellWords(cell.runs, t.vertical)
+				last := cell.col + cell.span - 1
+				gap := 3 * (cell.span - 1)
+				if w := longestWord(words) - gap - sum(minWidths[cell.col:last]); w > minWidths[last] {
+					minWidths[last] = w
+				}
+				if w := longestLine(words) - gap - sum(maxWidths[cell.col:last]); w > maxWidths[last] {
+					maxWidths[last] = w
+				}
+			}
+		}
+	}
+
+	width -= 3*n + 1
+	if sum(minWidths) > width {
+		return nil, false
+	}
+	widths := make([]int, n)
+	for i := range widths {
+		if maxWidths[i] < minWidths[i] {
+			maxWidths[i] = minWidths[i]
+		}
+		widths[i] = maxWidths[i]
+	}
+	if sum(maxWidths) <= width {
+		return widths, true
+	}
+
+	// Share the space left by the narrowest layout in proportion to how much
+	// wider each column would like to be.
+	extra := width - sum(minWidths)
+	wanted := sum(maxWidths) - sum(minWidths)
+	for i := range widths {
+		widths[i] = minWidths[i] + extra*(maxWidths[i]-minWidths[i])/wanted
+	}
+	return widths, true
+}
+
+// drawGrid draws the table with the given column widths, starting at the
+// current row.
+func (t *table) drawGrid(c *Cellbuf, widths []int) {
+	n := len(widths)
+	xs := make([]int, n+1) // the x of the border left of each column
+	tableWidth := sum(widths) + 3*n + 1
+	xs[0] = c.lmargin
+	switch t.align {
+	case "center":
+		xs[0] += (c.Width - c.lmargin - tableWidth) / 2
+	case "right":
+		xs[0] += c.Width - c.lmargin - tableWidth
+	}
+	for i, w := range widths {
+		xs[i+1] = xs[i] + w + 3
+	}
+
+	startRow := c.row
+	y := c.row
+	for _, line := range wrap(cellWords(t.caption, t.vertical), tableWidth) {
+		c.putLine(xs[0]+(tableWidth-lineWidth(line))/2, y, line)
+		y++
+	}
+
+	var above []bool
+	for i, row := range t.rows {
+		below := row.edges(n)
+		b := singleBorders
+		if i > 0 && t.rows[i-1].isHeader() && !row.isHeader() {
+			b = headerBorders
+		}
+		c.drawBorder(y, xs, above, below, b, t.style)
+		y++
+
+		cells := row.place(n)
+		lines := make([][][]cellRune, len(cells))
+		height := 1
+		for j, cell := range cells {
+			lines[j] = wrap(cellWords(cell.runs, t.vertical), xs[cell.col+cell.span]-xs[cell.col]-3)
+			if len(lines[j]) > height {
+				height = len(lines[j])
+			}
+		}
+		for dy := 0; dy < height; dy++ {
+			for x, edge := range below {
+				if edge {
+					c.setCell(xs[x], y+dy, '│', t.style.Fg, t.style.Bg)
+				}
+			}
+		}
+		for j, cell := range cells {
+			w := xs[cell.col+cell.span] - xs[cell.col] - 3
+			for dy, line := range lines[j] {
+				x := xs[cell.col] + 2
+				if cell.header {
+					x += (w - lineWidth(line)) / 2
+				}
+				c.putLine(x, y+dy, line)
+			}
+		}
+		y += height
+		above = below
+	}
+	c.drawBorder(y, xs, above, nil, singleBorders, t.style)
+
+	if c.aligned == nil {
+		c.aligned = make(map[int]bool)
+	}
+	for row := startRow; row <= y; row++ {
+		c.aligned[row] = true
+	}
+	c.unordered = c.unordered || n > 1
+	// The bottom border fills its row, so text after the table starts on the
+	// next one.
+	c.row = y
+	c.col = c.Width
+}
+
+// drawBorder draws a border row between rows whose cells end at the column
+// boundaries marked in above and below. Either is nil for the top or bottom
+// border.
+func (c *Cellbuf) drawBorder(y int, xs []int, above, below []bool, b borders, s Style) {
+	for x := xs[0]; x <= xs[len(xs)-1]; x++ {
+		c.setCell(x, y, b.line, s.Fg, s.Bg)
+	}
+	for i, x := range xs {
+		up := above != nil && above[i]
+		down := below != nil && below[i]
+		var r rune
+		switch {
+		case i == 0 && above == nil:
+			r = b.topLeft
+		case i == 0 && below == nil:
+			r = b.bottomLeft
+		case i == 0:
+			r = b.left
+		case i == len(xs)-1 && above == nil:
+			r = b.topRight
+		case i == len(xs)-1 && below == nil:
+			r = b.bottomRight
+		case i == len(xs)-1:
+			r = b.right
+		case up && down:
+			r = b.cross
+		case down:
+			r = b.down
+		case up:
+			r = b.up
+		default:
+			r = b.line
+		}
+		c.setCell(x, y, r, s.Fg, s.Bg)
+	}
+}
+
+// drawStacked draws each row of the table as a block of rows, with a row for
+// each cell labelled with the header of its column. Header rows are not drawn
+// when there are other rows, as their text is in the labels.
+func (t *table) drawStacked(c *Cellbuf) {
+	n := t.columns()
+	labels := make([]tableRun, n)
+	hasData := false
+	for _, row := range t.rows {
+		if !row.isHeader() {
+			hasData = true
+			continue
+		}
+		for _, cell := range row.place(n) {
+			if labels[cell.col].text != "" {
+				continue
+			}
+			for _, r := range cell.runs {
+				if !r.hidden && !r.lineBreak && labels[cell.col].text == "" {
+					labels[cell.col].style = r.style
+				}
+				if !r.hidden && !r.lineBreak {
+					labels[cell.col].text += r.text
+				}
+			}
+			labels[cell.col].text = strings.Join(strings.Fields(labels[cell.col].text), " ")
+		}
+	}
+
+	c.appendRuns(t.caption)
+	for _, row := range t.rows {
+		if row.isHeader() && hasData {
+			for _, cell := range row.cells {
+				for _, r := range cell.runs {
+					if !r.uncounted {
+						c.skipText(r.text)
+					}
+				}
+			}
+			continue
+		}
+		c.newRow()
+		c.row++
+		for _, cell := range row.place(n) {
+			if len(cell.runs) == 0 {
+				continue
+			}
+			c.newRow()
+			if label := labels[cell.col]; label.text != "" && !row.isHeader() {
+				c.fg, c.bg = label.style.Fg, label.style.Bg
+				c.uncounted = true
+				c.appendText(label.text + ":")
+				c.uncounted = false
+				c.space = true
+			}
+			c.appendRuns(cell.runs)
+		}
+	}
+	// Text after the table starts on the next row, as after a grid.
+	c.col = c.Width
+}
+
+// appendRuns appends the runs of a table cell as wrapped text.
+func (c *Cellbuf) appendRuns(runs []tableRun) {
+	for _, r := range runs {
+		switch {
+		case r.lineBreak:
+			c.newRow()
+		case r.hidden:
+			c.skipText(r.text)
+		default:
+			c.fg, c.bg = r.style.Fg, r.style.Bg
+			c.uncounted = r.uncounted
+			c.appendText(r.text)
+			c.uncounted = false
+		}
+	}
+}
+
+// putLine writes a line of a table cell at x, y, giving the characters of the
+// document's text their text offsets.
+func (c *Cellbuf) putLine(x, y int, line []cellRune) {
+	base := -1 // the offset of the last character laid out
+	for _, cr := range line {
+		switch {
+		case cr.hidden:
+			c.skipText(string(cr.r))
+			continue
+		case cr.r == 0:
+		case cr.width == 0:
+			// A combining mark belongs to the character before it.
+			if !cr.uncounted && x > 0 && base >= 0 {
+				c.addMark(base, y*c.Width+x-1, cr.r)
+				c.offset++
+				c.textCells = append(c.textCells, y*c.Width+x-1)
+			}
+			continue
+		case x+cr.width > c.Width:
+		default:
+			if !cr.uncounted {
+				c.markOffset(y)
+				base = c.offset
+				c.offset++
+				c.textCells = append(c.textCells, y*c.Width+x)
+			}
+			c.putGlyph(x, y, glyph{runes: []rune{cr.r}, width: cr.width}, cr.style.Fg, cr.style.Bg)
+		}
+		x += cr.width
+	}
+}
+
+// cellWords splits runs into words, with a nil word for each line break.
+// Characters take a single cell each in vertical mode.
+func cellWords(runs []tableRun, vertical bool) [][]cellRune {
+	var words [][]cellRune
+	var word []cellRune
+	flush := func() {
+		if word != nil {
+			words = append(words, word)
+			word = nil
+		}
+	}
+	for _, run := range runs {
+		if run.lineBreak {
+			flush()
+			words = append(words, nil)
+			continue
+		}
+		for _, g := range glyphs(run.text) {
+			if unicode.IsSpace(g.runes[0]) {
+				flush()
+				continue
+			}
+			for i, r := range g.runes {
+				cr := cellRune{r: r, style: run.style, uncounted: run.uncounted, hidden: run.hidden}
+				switch {
+				case i > 0:
+				case vertical:
+					cr.width = 1
+				default:
+					cr.width = g.width
+				}
+				word = append(word, cr)
+			}
+		}
+	}
+	flush()
+	return words
+}
+
+// wrap breaks words into lines no wider than width, with a space between
+// words. Words wider than width are broken across lines. Line breaks at the
+// start and end are dropped.
+func wrap(words [][]cellRune, width int) [][]cellRune {
+	if width < 1 {
+		width = 1
+	}
+	var lines [][]cellRune
+	var line []cellRune
+	w := 0
+	newLine := func() {
+		lines = append(lines, line)
+		line, w = nil, 0
+	}
+	for _, word := range words {
+		if word == nil {
+			if len(line) > 0 || len(lines) > 0 {
+				newLine()
+			}
+			continue
+		}
+		if ww := lineWidth(word); w > 0 && ww > 0 {
+			if w+1+ww > width {
+				newLine()
+			} else {
+				line = append(line, cellRune{width: 1})
+				w++
+			}
+		}
+		for _, cr := range word {
+			if !cr.hidden && cr.width > 0 && w > 0 && w+cr.width > width {
+				newLine()
+			}
+			line = append(line, cr)
+			if !cr.hidden {
+				w += cr.width
+			}
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineWidth returns the number of columns taken by a line or word.
+func lineWidth(line []cellRune) int {
+	w := 0
+	for _, cr := range line {
+		if !cr.hidden {
+			w += cr.width
+		}
+	}
+	return w
+}
+
+// longestWord returns the width of the widest word.
+func longestWord(words [][]cellRune) int {
+	longest := 0
+	for _, word := range words {
+		if w := lineWidth(word); w > longest {
+			longest = w
+		}
+	}
+	return longest
+}
+
+// longestLine returns the width of the widest line of words, when only
+// broken at line breaks.
+func longestLine(words [][]cellRune) int {
+	longest := 0
+	for _, line := range wrap(words, int(^uint(0)>>1)) {
+		if w := lineWidth(line); w > longest {
+			longest = w
+		}
+	}
+	return longest
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,109 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+const tableText = `<p>Before.</p>
+<table><caption>Ages</caption>
+<thead><tr><th>Name</th><th>Age</th><th>Notes</th></tr></thead>
+<tbody>
+<tr><td>Alice</td><td>7</td><td>Fell down a rabbit hole</td></tr>
+<tr><td colspan="2">Spanning both</td><td>x</td></tr>
+<tr><td>Short</td></tr>
+</tbody></table>
+<p>After.</p>`
+
+func TestTableGrid(t *testing.T) {
+	doc, err := ParseText(strings.NewReader(tableText), nil, Option{Width: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `  Before.
+
+            Ages
+┌───────┬─────┬─────────────┐
+│ Name  │ Age │    Notes    │
+╞═══════╪═════╪═════════════╡
+│ Alice │ 7   │ Fell down a │
+│       │     │ rabbit hole │
+├───────┴─────┼─────────────┤
+│ Spanning    │ x           │
+│ both        │             │
+├───────┬─────┼─────────────┤
+│ Short │     │             │
+└───────┴─────┴─────────────┘
+
+   After.`
+	if got := layout(&doc); got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+
+	// Offsets follow the document, cell after cell.
+	text := "Before. Ages Name Age Notes Alice 7 Fell down a rabbit hole Spanning both x Short After."
+	if got := doc.Text(0, doc.TextLen()); got != text {
+		t.Errorf("Expected: %q, but got: %q\n", text, got)
+	}
+	for offset := 0; offset < doc.TextLen(); offset++ {
+		if got := doc.TextOffset(doc.TextCell(offset)); got != offset {
+			t.Errorf("Expected: %v, but got: %v\n", offset, got)
+		}
+	}
+}
+
+func TestTableStacked(t *testing.T) {
+	doc, err := ParseText(strings.NewReader(tableText), nil, Option{Width: 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `  Before.
+
+Ages
+
+Name: Alice
+Age: 7
+Notes: Fell down a
+rabbit hole
+
+Name: Spanning
+both
+Notes: x
+
+Name: Short
+
+   After.`
+	if got := layout(&doc); got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+
+	// The header row is not shown, but keeps its offsets.
+	start := len("Before.AgesNameAgeNotes")
+	if got := doc.Text(start, start+len("Alice")); got != "Alice" {
+		t.Errorf("Expected: %q, but got: %q\n", "Alice", got)
+	}
+}
+
+// layout returns the rows of a document with their margins, without the
+// blank rows at the start and end.
+func layout(doc *Cellbuf) string {
+	var rows []string
+	for row := 0; row < doc.Rows(); row++ {
+		rows = append(rows, strings.TrimRight(rowText(doc, row), " "))
+	}
+	return strings.Trim(strings.Join(rows, "\n"), "\n")
+}
+
+func TestTableHugeColspan(t *testing.T) {
+	// Spans are cut to 1000 columns, rather than taking all memory.
+	const text = `<table><tr><td colspan="100000000">Wide</td></tr><tr><td>a</td><td>b</td></tr></table>`
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := doc.Text(0, doc.TextLen()), "Wide a b"; got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+}
@@ -0,0 +1,497 @@
+package parse
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	termbox "github.com/nsf/termbox-go"
+	"golang.org/x/net/html/atom"
+)
+
+// colorMask selects the color of a termbox.Attribute, leaving out attributes
+// like termbox.AttrBold. Colors above 16 need termbox.Output256.
+const colorMask = termbox.AttrBold - 1
+
+// rgbMask selects the 24-bit color of a termbox.Attribute made by
+// termbox.RGBToAttribute, which is kept above the attributes.
+const rgbMask = ^(termbox.AttrReverse<<1 - 1)
+
+// Style is the colors and attributes text is drawn with. Fg and Bg combine a
+// termbox color, or termbox.ColorDefault to keep the color of the enclosing
+// element, with attributes such as termbox.AttrBold.
+type Style struct {
+	Fg, Bg termbox.Attribute
+}
+
+// Over returns the style s drawn over base: the colors of s replace those of
+// base where they are set, and the attributes of both are combined.
+func (s Style) Over(base Style) Style {
+	return Style{over(s.Fg, base.Fg), over(s.Bg, base.Bg)}
+}
+
+func over(a, base termbox.Attribute) termbox.Attribute {
+	const mask = colorMask | rgbMask
+	if c := a & mask; c != termbox.ColorDefault {
+		base = base&^mask | c
+	}
+	return base | a&^mask
+}
+
+// RGB returns the style with its palette colors changed to the same colors
+// in 24-bit, as termbox.OutputRGB needs. 24-bit colors are left as they are.
+// That mode has no default color with attributes such as bold, so the default
+// colors of a style with attributes become light gray on black.
+func (s Style) RGB() Style {
+	return Style{rgbColor(s.Fg, 7), rgbColor(s.Bg, 0)}
+}
+
+// rgbColor returns the 24-bit color of a, or of palette color def if a has
+// the default color and attributes.
+func rgbColor(a termbox.Attribute, def int) termbox.Attribute {
+	if a&rgbMask != 0 || a == termbox.ColorDefault {
+		return a
+	}
+	i := int(a&colorMask) - 1
+	if i < 0 || i > 255 {
+		i = def
+	}
+	r, g, b := paletteRGB(i)
+	return termbox.RGBToAttribute(r, g, b) | a&^colorMask
+}
+
+// Theme maps HTML elements to the styles their text is drawn with. Text is
+// the style of the page, which the pager fills the screen with, and Search,
+// Annotation, Selection and Link are the styles of highlighted text; the
+// focused link is drawn in the Selection style. BookColors
+// allows text to be drawn in the colors set by the book's style sheets.
+type Theme struct {
+	Name       string
+	Text       Style
+	Elements   map[atom.Atom]Style
+	Search     Style
+	Annotation Style
+	Selection  Style
+	Link       Style
+	BookColors bool
+}
+
+// style returns the style of text within elements, from outermost to
+// innermost. Bold, italic and underlined text is drawn in the style of the
+// element that made it so, or of <b>, <i> and <u> if it was made so by CSS,
+// so that a book's emphasis looks the same whichever way it is marked up.
+func (t *Theme) style(elements []*element) Style {
+	var s Style
+	var bold, italic, underline atom.Atom
+	var color termbox.Attribute
+	for _, e := range elements {
+		switch e.atom {
+		case atom.B, atom.Strong:
+			bold = e.atom
+		case atom.I, atom.Em:
+			italic = e.atom
+		case atom.U:
+			underline = e.atom
+		default:
+			if es, ok := t.Elements[e.atom]; ok {
+				s = es.Over(s)
+			}
+		}
+		bold = e.css.bold.apply(bold, atom.B)
+		italic = e.css.italic.apply(italic, atom.I)
+		underline = e.css.underline.apply(underline, atom.U)
+		if e.css.color != termbox.ColorDefault {
+			color = e.css.color
+		}
+	}
+
+	emphasis := []struct {
+		element  atom.Atom
+		fallback termbox.Attribute
+	}{
+		{bold, termbox.AttrBold},
+		{italic, termbox.AttrCursive},
+		{underline, termbox.AttrUnderline},
+	}
+	for _, em := range emphasis {
+		if em.element == 0 {
+			continue
+		}
+		es, ok := t.Elements[em.element]
+		if !ok {
+			es = Style{Fg: em.fallback}
+		}
+		s = es.Over(s)
+	}
+	if t.BookColors && color != termbox.ColorDefault {
+		s.Fg = s.Fg&^colorMask | color
+	}
+	return s
+}
+
+// apply returns the element whose style a CSS property turns on or off: a if
+// the property is unset, css if it is turned on and none if it is turned off.
+func (t tristate) apply(a, css atom.Atom) atom.Atom {
+	switch t {
+	case on:
+		return css
+	case off:
+		return 0
+	}
+	return a
+}
+
+// Colors256 reports whether the theme uses colors beyond the 16 basic
+// terminal colors, so that the terminal must be put in 256 color mode.
+func (t *Theme) Colors256() bool {
+	if t.BookColors {
+		return true
+	}
+	styles := []Style{t.Text, t.Search, t.Annotation, t.Selection, t.Link}
+	for _, s := range t.Elements {
+		styles = append(styles, s)
+	}
+	for _, s := range styles {
+		if s.Fg&colorMask > termbox.ColorLightGray || s.Bg&colorMask > termbox.ColorLightGray {
+			return true
+		}
+	}
+	return false
+}
+
+// headings are the elements styled as headings by the built-in themes.
+var headings = []atom.Atom{atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6}
+
+// themes are the built-in themes, by name.
+var themes = map[string]*Theme{
+	"default": {
+		Name: "default",
+		Elements: map[atom.Atom]Style{
+			atom.B:      {Fg: termbox.AttrBold},
+			atom.Strong: {Fg: termbox.AttrBold},
+			atom.Em:     {Fg: termbox.AttrBold},
+			atom.I:      {Fg: termbox.ColorYellow},
+			atom.Title:  {Fg: termbox.ColorRed},
+			atom.H1:     {Fg: termbox.ColorMagenta},
+			atom.H2:     {Fg: termbox.ColorBlue},
+			atom.H3:     {Fg: termbox.ColorCyan},
+			atom.H4:     {Fg: termbox.ColorCyan},
+			atom.H5:     {Fg: termbox.ColorCyan},
+			atom.H6:     {Fg: termbox.ColorCyan},
+			atom.Pre:    {Fg: termbox.ColorGreen},
+			atom.Code:   {Fg: termbox.ColorGreen},
+		},
+		Search:     Style{termbox.ColorBlack, termbox.ColorYellow},
+		Annotation: Style{termbox.ColorBlack, termbox.ColorCyan},
+		Selection:  Style{termbox.AttrReverse, termbox.AttrReverse},
+		Link:       Style{Fg: termbox.AttrUnderline},
+	},
+	"dark": {
+		Name: "dark",
+		Text: Style{termbox.ColorLightGray, termbox.ColorBlack},
+		Elements: map[atom.Atom]Style{
+			atom.B:      {Fg: termbox.ColorWhite | termbox.AttrBold},
+			atom.Strong: {Fg: termbox.ColorWhite | termbox.AttrBold},
+			atom.Em:     {Fg: termbox.AttrCursive},
+			atom.I:      {Fg: termbox.ColorLightYellow | termbox.AttrCursive},
+			atom.Title:  {Fg: termbox.ColorLightRed},
+			atom.H1:     {Fg: termbox.ColorLightMagenta | termbox.AttrBold},
+			atom.H2:     {Fg: termbox.ColorLightBlue | termbox.AttrBold},
+			atom.H3:     {Fg: termbox.ColorLightCyan},
+			atom.H4:     {Fg: termbox.ColorLightCyan},
+			atom.H5:     {Fg: termbox.ColorLightCyan},
+			atom.H6:     {Fg: termbox.ColorLightCyan},
+			atom.Pre:    {Fg: termbox.ColorLightGreen},
+			atom.Code:   {Fg: termbox.ColorLightGreen},
+		},
+		Search:     Style{termbox.ColorBlack, termbox.ColorLightYellow},
+		Annotation: Style{termbox.ColorBlack, termbox.ColorCyan},
+		Selection:  Style{termbox.ColorBlack, termbox.ColorLightGray},
+		Link:       Style{Fg: termbox.ColorLightBlue | termbox.AttrUnderline},
+	},
+	"light": {
+		Name: "light",
+		Text: Style{termbox.ColorBlack, termbox.ColorWhite},
+		Elements: map[atom.Atom]Style{
+			atom.B:      {Fg: termbox.AttrBold},
+			atom.Strong: {Fg: termbox.AttrBold},
+			atom.Em:     {Fg: termbox.AttrCursive},
+			atom.I:      {Fg: termbox.AttrCursive},
+			atom.Title:  {Fg: termbox.ColorRed},
+			atom.H1:     {Fg: termbox.ColorMagenta | termbox.AttrBold},
+			atom.H2:     {Fg: termbox.ColorBlue | termbox.AttrBold},
+			atom.H3:     {Fg: termbox.ColorBlue},
+			atom.H4:     {Fg: termbox.ColorBlue},
+			atom.H5:     {Fg: termbox.ColorBlue},
+			atom.H6:     {Fg: termbox.ColorBlue},
+			atom.Pre:    {Fg: termbox.ColorGreen},
+			atom.Code:   {Fg: termbox.ColorGreen},
+		},
+		Search:     Style{termbox.ColorBlack, termbox.ColorYellow},
+		Annotation: Style{termbox.ColorBlack, termbox.ColorLightCyan},
+		Selection:  Style{termbox.ColorWhite, termbox.ColorBlue},
+		Link:       Style{Fg: termbox.ColorBlue | termbox.AttrUnderline},
+		BookColors: true,
+	},
+	// solarized uses the 256 color approximations of the Solarized dark
+	// palette.
+	"solarized": {
+		Name: "solarized",
+		Text: Style{color256(244), color256(234)},
+		Elements: map[atom.Atom]Style{
+			atom.B:      {Fg: color256(245) | termbox.AttrBold},
+			atom.Strong: {Fg: color256(245) | termbox.AttrBold},
+			atom.Em:     {Fg: termbox.AttrCursive},
+			atom.I:      {Fg: color256(136) | termbox.AttrCursive},
+			atom.Title:  {Fg: color256(160)},
+			atom.H1:     {Fg: color256(166) | termbox.AttrBold},
+			atom.H2:     {Fg: color256(33) | termbox.AttrBold},
+			atom.H3:     {Fg: color256(37)},
+			atom.H4:     {Fg: color256(37)},
+			atom.H5:     {Fg: color256(37)},
+			atom.H6:     {Fg: color256(37)},
+			atom.Pre:    {Fg: color256(64)},
+			atom.Code:   {Fg: color256(64)},
+		},
+		Search:     Style{color256(234), color256(136)},
+		Annotation: Style{color256(234), color256(37)},
+		Selection:  Style{color256(234), color256(244)},
+		Link:       Style{Fg: color256(33) | termbox.AttrUnderline},
+	},
+	"monochrome": {
+		Name: "monochrome",
+		Elements: map[atom.Atom]Style{
+			atom.B:      {Fg: termbox.AttrBold},
+			atom.Strong: {Fg: termbox.AttrBold},
+			atom.Em:     {Fg: termbox.AttrCursive},
+			atom.I:      {Fg: termbox.AttrCursive},
+			atom.Title:  {Fg: termbox.AttrBold},
+			atom.H1:     {Fg: termbox.AttrBold | termbox.AttrUnderline},
+			atom.H2:     {Fg: termbox.AttrBold},
+			atom.H3:     {Fg: termbox.AttrBold},
+			atom.H4:     {Fg: termbox.AttrBold},
+			atom.H5:     {Fg: termbox.AttrBold},
+			atom.H6:     {Fg: termbox.AttrBold},
+			atom.Pre:    {Fg: termbox.AttrDim},
+			atom.Code:   {Fg: termbox.AttrDim},
+		},
+		Search:     Style{termbox.AttrReverse, termbox.AttrReverse},
+		Annotation: Style{Fg: termbox.AttrUnderline},
+		Selection:  Style{termbox.AttrReverse | termbox.AttrBold, termbox.AttrReverse},
+		Link:       Style{Fg: termbox.AttrUnderline},
+	},
+}
+
+// DefaultTheme is the theme used when none is chosen.
+var DefaultTheme = themes["default"]
+
+// LookupTheme returns the built-in theme with the given name.
+func LookupTheme(name string) (*Theme, bool) {
+	t, ok := themes[name]
+	return t, ok
+}
+
+// ThemeNames returns the names of the built-in themes, sorted.
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTheme creates a theme from base, with the styles in spec replacing those
+// of base. The keys of spec are element names, or "text", "search",
+// "annotation", "selection" and "link"; the values are parsed with ParseStyle. The
+// "book-colors" key sets BookColors to "true" or "false".
+func NewTheme(name string, base *Theme, spec map[string]string) (*Theme, error) {
+	t := &Theme{
+		Name:       name,
+		Text:       base.Text,
+		Elements:   make(map[atom.Atom]Style, len(base.Elements)),
+		Search:     base.Search,
+		Annotation: base.Annotation,
+		Selection:  base.Selection,
+		Link:       base.Link,
+		BookColors: base.BookColors,
+	}
+	for a, s := range base.Elements {
+		t.Elements[a] = s
+	}
+
+	for key, value := range spec {
+		if key == "book-colors" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value %q", key, value)
+			}
+			t.BookColors = b
+			continue
+		}
+		s, err := ParseStyle(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		switch key {
+		case "text":
+			t.Text = s
+		case "search":
+			t.Search = s
+		case "annotation":
+			t.Annotation = s
+		case "selection":
+			t.Selection = s
+		case "link":
+			t.Link = s
+		case "h":
+			for _, a := range headings {
+				t.Elements[a] = s
+			}
+		default:
+			a := atom.Lookup([]byte(key))
+			if a == 0 {
+				return nil, fmt.Errorf("unknown element %q", key)
+			}
+			t.Elements[a] = s
+		}
+	}
+	return t, nil
+}
+
+// colorNames are the names of the basic terminal colors.
+var colorNames = map[string]termbox.Attribute{
+	"default":        termbox.ColorDefault,
+	"black":          termbox.ColorBlack,
+	"red":            termbox.ColorRed,
+	"green":          termbox.ColorGreen,
+	"yellow":         termbox.ColorYellow,
+	"blue":           termbox.ColorBlue,
+	"magenta":        termbox.ColorMagenta,
+	"cyan":           termbox.ColorCyan,
+	"white":          termbox.ColorWhite,
+	"bright-black":   termbox.ColorDarkGray,
+	"bright-red":     termbox.ColorLightRed,
+	"bright-green":   termbox.ColorLightGreen,
+	"bright-yellow":  termbox.ColorLightYellow,
+	"bright-blue":    termbox.ColorLightBlue,
+	"bright-magenta": termbox.ColorLightMagenta,
+	"bright-cyan":    termbox.ColorLightCyan,
+	"bright-white":   termbox.ColorLightGray,
+	"gray":           termbox.ColorDarkGray,
+	"grey":           termbox.ColorDarkGray,
+}
+
+// attributeNames are the names of the text attributes.
+var attributeNames = map[string]termbox.Attribute{
+	"bold":      termbox.AttrBold,
+	"dim":       termbox.AttrDim,
+	"italic":    termbox.AttrCursive,
+	"underline": termbox.AttrUnderline,
+	"reverse":   termbox.AttrReverse,
+	"blink":     termbox.AttrBlink,
+}
+
+// ParseStyle parses a style such as "yellow", "bold underline" or
+// "#657b83 on #fdf6e3": a foreground color, optionally followed by "on" and a
+// background color, and any number of attributes. Colors are basic color
+// names such as "red" or "bright-red", 256 color palette indices such as
+// "color136", or "#rrggbb", which is approximated in the 256 color palette.
+func ParseStyle(spec string) (Style, error) {
+	var s Style
+	fields := strings.Fields(strings.ToLower(spec))
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if a, ok := attributeNames[field]; ok {
+			s.Fg |= a
+			continue
+		}
+
+		target := &s.Fg
+		if field == "on" {
+			if i++; i >= len(fields) {
+				return s, fmt.Errorf("missing background color in %q", spec)
+			}
+			field, target = fields[i], &s.Bg
+		}
+		c, err := parseColor(field)
+		if err != nil {
+			return s, err
+		}
+		if *target&colorMask != termbox.ColorDefault {
+			return s, fmt.Errorf("more than one color in %q", spec)
+		}
+		*target |= c
+	}
+	return s, nil
+}
+
+// parseColor parses a color name, palette index or #rrggbb value.
+func parseColor(s string) (termbox.Attribute, error) {
+	if c, ok := colorNames[s]; ok {
+		return c, nil
+	}
+	if n, ok := strings.CutPrefix(s, "color"); ok {
+		i, err := strconv.Atoi(n)
+		if err != nil || i < 0 || i > 255 {
+			return 0, fmt.Errorf("invalid color %q", s)
+		}
+		return color256(i), nil
+	}
+	if strings.HasPrefix(s, "#") && len(s) == 7 {
+		rgb, err := strconv.ParseUint(s[1:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid color %q", s)
+		}
+		return color256(rgbTo256(int(rgb>>16), int(rgb>>8&0xff), int(rgb&0xff))), nil
+	}
+	return 0, fmt.Errorf("unknown color %q", s)
+}
+
+// color256 returns the termbox color of an index in the 256 color palette.
+func color256(i int) termbox.Attribute {
+	return termbox.Attribute(i + 1)
+}
+
+// rgbTo256 returns the index of the closest color in the 6x6x6 color cube or
+// the gray ramp of the 256 color palette.
+func rgbTo256(r, g, b int) int {
+	levels := []int{0, 95, 135, 175, 215, 255}
+	nearest := func(v int) int {
+		best := 0
+		for i, l := range levels {
+			if abs(v-l) < abs(v-levels[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	ri, gi, bi := nearest(r), nearest(g), nearest(b)
+	cube := 16 + 36*ri + 6*gi + bi
+	cubeDist := sq(r-levels[ri]) + sq(g-levels[gi]) + sq(b-levels[bi])
+
+	gray := (r + g + b) / 3
+	gi = (gray - 8) / 10
+	if gi < 0 {
+		gi = 0
+	} else if gi > 23 {
+		gi = 23
+	}
+	l := 8 + 10*gi
+	if sq(r-l)+sq(g-l)+sq(b-l) < cubeDist {
+		return 232 + gi
+	}
+	return cube
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func sq(x int) int {
+	return x * x
+}
@@ -0,0 +1,93 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+func TestParseStyle(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Style
+		err  bool
+	}{
+		{"", Style{}, false},
+		{"red", Style{Fg: termbox.ColorRed}, false},
+		{"Bold Underline", Style{Fg: termbox.AttrBold | termbox.AttrUnderline}, false},
+		{"black on yellow", Style{Fg: termbox.ColorBlack, Bg: termbox.ColorYellow}, false},
+		{"on blue bold", Style{Fg: termbox.AttrBold, Bg: termbox.ColorBlue}, false},
+		{"color100", Style{Fg: 101}, false},
+		{"#ffffff on #000000", Style{Fg: 232, Bg: 17}, false},
+		{"red blue", Style{}, true},
+		{"on", Style{}, true},
+		{"color256", Style{}, true},
+		{"mauve", Style{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseStyle(test.spec)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected an error for %q\n", test.spec)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Expected: %v, but got: %v, %v\n", test.want, got, err)
+		}
+	}
+}
+
+func TestStyleOver(t *testing.T) {
+	base := Style{Fg: termbox.ColorWhite | termbox.AttrBold, Bg: termbox.ColorBlack}
+	s := Style{Fg: termbox.ColorRed | termbox.AttrUnderline}
+	want := Style{Fg: termbox.ColorRed | termbox.AttrBold | termbox.AttrUnderline, Bg: termbox.ColorBlack}
+	if got := s.Over(base); got != want {
+		t.Errorf("Expected: %v, but got: %v\n", want, got)
+	}
+}
+
+func TestParseTheme(t *testing.T) {
+	theme, ok := LookupTheme("monochrome")
+	if !ok {
+		t.Fatal("Expected the monochrome theme")
+	}
+	doc, err := ParseText(strings.NewReader("<p>xyz <i>abc</i></p>"), nil, Option{Width: 40, Theme: theme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range doc.Cells {
+		want := termbox.ColorDefault
+		if c.Ch >= 'a' && c.Ch <= 'c' {
+			want = termbox.AttrCursive
+		}
+		if c.Ch != 0 && c.Fg != want {
+			t.Errorf("Expected %q: %v, but got: %v\n", c.Ch, want, c.Fg)
+		}
+	}
+}
+
+func TestStyleRGB(t *testing.T) {
+	red := termbox.RGBToAttribute(255, 0, 0)
+	tests := []struct {
+		s, want Style
+	}{
+		{Style{}, Style{}},
+		{Style{Fg: termbox.ColorRed, Bg: red}, Style{Fg: termbox.RGBToAttribute(205, 0, 0), Bg: red}},
+		{Style{Fg: 197 | termbox.AttrBold}, Style{Fg: red | termbox.AttrBold}},
+		{Style{Fg: termbox.AttrBold}, Style{Fg: termbox.RGBToAttribute(229, 229, 229) | termbox.AttrBold}},
+	}
+	for _, tt := range tests {
+		if got := tt.s.RGB(); got != tt.want {
+			t.Errorf("Expected: %v, but got: %v\n", tt.want, got)
+		}
+	}
+
+	// 24-bit colors replace the colors under them.
+	s := Style{Bg: red}.Over(Style{Fg: termbox.ColorWhite, Bg: termbox.ColorBlack})
+	if want := (Style{Fg: termbox.ColorWhite, Bg: red}); s != want {
+		t.Errorf("Expected: %v, but got: %v\n", want, s)
+	}
+}
@@ -0,0 +1,72 @@
+package parse
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/wormggmm/goreader/epub"
+	"golang.org/x/net/html/atom"
+)
+
+// verticalModes are the values of the CSS writing-mode property that lay out
+// text in columns from right to left.
+var verticalModes = map[string]bool{
+	"vertical-rl": true,
+	"tb-rl":       true,
+	"tb":          true,
+}
+
+// ParseText parses an HTML document into a cell buffer document. With
+// opt.Vertical, documents whose text is written vertically are laid out a
+// second time in vertical mode, once their style sheets are known.
+func ParseText(r io.Reader, items []epub.Item, opt Option) (Cellbuf, error) {
+	if !opt.Vertical {
+		return parseText(r, items, opt, false)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Cellbuf{}, err
+	}
+	doc, err := parseText(bytes.NewReader(data), items, opt, false)
+	if err != nil || !doc.verticalText {
+		return doc, err
+	}
+	return parseText(bytes.NewReader(data), items, opt, true)
+}
+
+// glyphs splits text into glyphs. In vertical mode glyphs are stacked, so
+// each takes a single cell.
+func (c *Cellbuf) glyphs(str string) []glyph {
+	gs := glyphs(str)
+	if c.Vertical {
+		for i := range gs {
+			gs[i].width = 1
+		}
+	}
+	return gs
+}
+
+// writingMode records whether the text being laid out is written vertically.
+func (p *parser) writingMode() {
+	mode := p.inherited(func(s *cssStyle) string { return s.writingMode })
+	if verticalModes[mode] {
+		p.doc.verticalText = true
+	}
+}
+
+// rootWritingMode sets the writing mode of the html element from the style
+// sheets of the document, which are read after it starts.
+func (p *parser) rootWritingMode() {
+	if len(p.stack) == 0 || p.stack[0].atom != atom.Html {
+		return
+	}
+	root := p.stack[0]
+	for _, r := range p.css.matching(root, nil) {
+		for _, d := range r.decls {
+			if strings.HasSuffix(d.property, "writing-mode") {
+				root.css.set(d, p.doc.Width)
+			}
+		}
+	}
+}
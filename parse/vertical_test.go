@@ -0,0 +1,39 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVertical(t *testing.T) {
+	const vertical = `<html><head><style>html { writing-mode: vertical-rl }</style></head>
+<body><p>日本語の文章です。</p></body></html>`
+	const horizontal = `<html><body><p>日本語</p></body></html>`
+
+	tests := []struct {
+		text     string
+		opt      Option
+		vertical bool
+		want     string
+	}{
+		{vertical, Option{Width: 40, Height: 6, Vertical: true}, true, "   日本語\nの文章です。"},
+		{vertical, Option{Width: 40, Height: 6}, false, "   日本語の文章です。"},
+		{horizontal, Option{Width: 40, Height: 6, Vertical: true}, false, "  日本語"},
+	}
+	for _, test := range tests {
+		doc, err := ParseText(strings.NewReader(test.text), nil, test.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Vertical != test.vertical {
+			t.Errorf("Expected: %v, but got: %v\n", test.vertical, doc.Vertical)
+		}
+		if got := layout(&doc); got != test.want {
+			t.Errorf("Expected:\n%s\nbut got:\n%s\n", test.want, got)
+		}
+		// Stacked characters take a single cell, even if they are wide.
+		if test.vertical && doc.TextCell(1) != doc.TextCell(0)+1 {
+			t.Errorf("Expected: %v, but got: %v\n", doc.TextCell(0)+1, doc.TextCell(1))
+		}
+	}
+}
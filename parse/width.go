@@ -0,0 +1,112 @@
+package parse
+
+import (
+	"github.com/mattn/go-runewidth"
+	termbox "github.com/nsf/termbox-go"
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Continuation is the rune of the cell covered by the right half of a
+// double-width character, such as a CJK ideograph. The terminal draws the
+// character over both cells, so continuation cells are not drawn themselves.
+const Continuation rune = -1
+
+// glyph is a grapheme cluster: a character with the combining marks that
+// follow it. Cells hold a single rune, so glyphs are displayed as the first
+// rune of their composed form, such as é for e and U+0301, but each of their
+// runes has a text offset, and Text returns them whole.
+type glyph struct {
+	runes []rune
+	width int  // the number of cells taken, 1 or 2
+	brk   bool // whether a line may be broken after the glyph, per UAX #14
+}
+
+// glyphs splits text into glyphs.
+func glyphs(str string) []glyph {
+	var gs []glyph
+	state := -1
+	for len(str) > 0 {
+		var cluster string
+		var boundaries int
+		cluster, str, boundaries, state = uniseg.StepString(str, state)
+		runes := []rune(cluster)
+		gs = append(gs, glyph{
+			runes: runes,
+			width: RuneWidth(runes[0]),
+			brk:   boundaries&uniseg.MaskLine != uniseg.LineDontBreak,
+		})
+	}
+	return gs
+}
+
+// RuneWidth returns the number of cells a rune takes on the terminal. Like
+// termbox, it gives characters of ambiguous width a single cell.
+func RuneWidth(r rune) int {
+	if runewidth.RuneWidth(r) == 2 && !runewidth.IsAmbiguousWidth(r) {
+		return 2
+	}
+	return 1
+}
+
+// glyphsWidth returns the number of cells taken by glyphs.
+func glyphsWidth(gs []glyph) int {
+	w := 0
+	for _, g := range gs {
+		w += g.width
+	}
+	return w
+}
+
+// composed returns the runes of a grapheme cluster in Unicode normalization
+// form C, which replaces a character and its combining marks with a single
+// character where there is one.
+func composed(runes []rune) []rune {
+	if len(runes) == 1 {
+		return runes
+	}
+	return []rune(norm.NFC.String(string(runes)))
+}
+
+// putGlyph writes a glyph at the given position, filling the cell after a
+// double-width glyph with Continuation.
+func (c *Cellbuf) putGlyph(x, y int, g glyph, fg, bg termbox.Attribute) {
+	c.setCell(x, y, composed(g.runes)[0], fg, bg)
+	if g.width == 2 {
+		c.setCell(x+1, y, Continuation, fg, bg)
+	}
+}
+
+// cellEnd returns the index past the cell at index i, and past the
+// continuation cell after it if it holds a double-width character.
+func (c *Cellbuf) cellEnd(i int) int {
+	if i+1 < len(c.Cells) && c.Cells[i+1].Ch == Continuation {
+		return i + 2
+	}
+	return i + 1
+}
+
+// setCluster records the text of a grapheme cluster with combining marks,
+// whose first character is at the given offset, for Text to return in place
+// of the rune displayed.
+func (c *Cellbuf) setCluster(offset int, runes []rune) {
+	if len(runes) < 2 || c.uncounted {
+		return
+	}
+	if c.clusters == nil {
+		c.clusters = make(map[int]string)
+	}
+	c.clusters[offset] = string(composed(runes))
+}
+
+// addMark adds a combining mark to the grapheme cluster at offset, displayed
+// in the cell at index i.
+func (c *Cellbuf) addMark(offset, i int, mark rune) {
+	runes := []rune(c.clusters[offset])
+	if len(runes) == 0 {
+		runes = []rune{c.Cells[i].Ch}
+	}
+	runes = append(runes, mark)
+	c.setCluster(offset, runes)
+	c.Cells[i].Ch = composed(runes)[0]
+}
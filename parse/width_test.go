@@ -0,0 +1,127 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWideText(t *testing.T) {
+	const text = `<p>日本語の文章は、単語の間に空白を置かない。</p>`
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := layout(&doc)
+	want := "  日本語の文章\n" +
+		"は、単語の間に空\n" +
+		"白を置かない。"
+	if got != want {
+		t.Errorf("Expected:\n%s\nbut got:\n%s\n", want, got)
+	}
+
+	// Each character takes two cells, the second holding Continuation.
+	start := doc.TextCell(0)
+	if i := doc.TextCell(1); i != start+2 || doc.Cells[i+1].Ch != Continuation {
+		t.Errorf("Expected: %v, but got: %v\n", start+2, i)
+	}
+	if got, want := doc.Text(0, 6), "日本語の文章"; got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+	if got, want := doc.TextRegions(0, 1), (Region{start, start + 2}); len(got) != 1 || got[0] != want {
+		t.Errorf("Expected: %v, but got: %v\n", want, got)
+	}
+}
+
+func TestCombiningMarks(t *testing.T) {
+	const text = "<p>Cafe\u0301 noe\u0308l</p>"
+
+	doc, err := ParseText(strings.NewReader(text), nil, Option{Width: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Marks are displayed composed with the character before them, but
+	// still have offsets of their own.
+	if got, want := layout(&doc), "  Café noël"; got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+	if got, want := doc.TextLen(), 10; got != want {
+		t.Errorf("Expected: %v, but got: %v\n", want, got)
+	}
+	if got, want := doc.TextCell(4), doc.TextCell(3); got != want {
+		t.Errorf("Expected: %v, but got: %v\n", want, got)
+	}
+	if got, want := doc.TextCell(5), doc.TextCell(4)+2; got != want {
+		t.Errorf("Expected: %v, but got: %v\n", want, got)
+	}
+	if got, want := doc.Text(0, doc.TextLen()), "Café noël"; got != want {
+		t.Errorf("Expected: %q, but got: %q\n", want, got)
+	}
+}
+
+func TestCombiningMarksText(t *testing.T) {
+	testCases := []struct {
+		html string
+	}{
+		{"<p>e\u0301te\u0301</p>"},
+		{"<pre>e\u0301te\u0301</pre>"},
+		{"<table><tr><td>e\u0301te\u0301</td></tr></table>"},
+	}
+
+	for _, tc := range testCases {
+		doc, err := ParseText(strings.NewReader(tc.html), nil, Option{Width: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := layout(&doc), "été"; !strings.Contains(got, want) {
+			t.Errorf("Expected: %q, but got: %q\n", want, got)
+		}
+		if got, want := doc.Text(0, doc.TextLen()), "été"; got != want {
+			t.Errorf("Expected: %q, but got: %q\n", want, got)
+		}
+		// The pattern matches whether its marks are composed or not.
+		for _, pattern := range []string{"été", "e\u0301te\u0301"} {
+			re, err := CompileSearch(pattern, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := doc.Find(re); len(got) != 1 {
+				t.Errorf("Expected: %v, but got: %v\n", 1, len(got))
+			}
+		}
+	}
+}
+
+func TestWideGlyphInNarrowRow(t *testing.T) {
+	testCases := []struct {
+		html  string
+		width int
+		text  string
+	}{
+		{"<p>日</p>", 1, "日"},
+		{"<ul><li><ul><li>日本語</li></ul></li></ul>", 2, "日本語"},
+	}
+
+	for _, tc := range testCases {
+		done := make(chan Cellbuf)
+		go func() {
+			doc, err := ParseText(strings.NewReader(tc.html), nil, Option{Width: tc.width})
+			if err != nil {
+				t.Error(err)
+			}
+			done <- doc
+		}()
+		select {
+		case doc := <-done:
+			// The glyphs are squeezed into a cell each, keeping their text.
+			if got, want := doc.Text(0, doc.TextLen()), tc.text; got != want {
+				t.Errorf("Expected: %q, but got: %q\n", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %q to be laid out at width %d\n", tc.html, tc.width)
+		}
+	}
+}
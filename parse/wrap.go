@@ -0,0 +1,149 @@
+package parse
+
+import (
+	"unicode"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// wrapRow moves to the left margin of the next row because the text went
+// past the right edge, justifying the row if justify is set.
+func (c *Cellbuf) wrapRow() {
+	if c.justify {
+		c.justifyRow(c.row)
+	}
+	c.row++
+	c.col = c.lmargin
+}
+
+// breakWord returns how many glyphs of a word that does not fit in the rest
+// of the row go in it, and whether they are followed by a hyphen. Words are
+// broken where UAX #14 allows, such as after hyphens or between CJK
+// characters, or hyphenated if the Cellbuf has a hyphenator. A word wider
+// than a whole row is broken at the right edge if nothing else can be done,
+// and then word is changed to fit its first glyph in a row narrower than it.
+func (c *Cellbuf) breakWord(word []glyph) (n int, hyphen bool) {
+	room := c.Width - c.col
+	w := 0
+	for i, g := range word[:len(word)-1] {
+		if w += g.width; w > room {
+			break
+		}
+		if g.brk && (i > 0 || unicode.IsLetter(g.runes[0])) {
+			n = i + 1
+		}
+	}
+	if c.hyphenator != nil {
+		letters := make([]rune, len(word))
+		for i, g := range word {
+			letters[i] = g.runes[0]
+		}
+		// Hyphenate the letters between leading and trailing punctuation.
+		lo, hi := 0, len(letters)
+		for lo < hi && !unicode.IsLetter(letters[lo]) {
+			lo++
+		}
+		for hi > lo && !unicode.IsLetter(letters[hi-1]) {
+			hi--
+		}
+		for _, p := range c.hyphenator.points(letters[lo:hi]) {
+			if glyphsWidth(word[:lo+p])+1 <= room && lo+p > n {
+				n, hyphen = lo+p, true
+			}
+		}
+	}
+	if n > 0 {
+		return n, hyphen
+	}
+	start := c.row*c.Width + c.lmargin
+	if glyphsWidth(word) > c.Width-c.lmargin && c.blank(start, c.row*c.Width+c.col) {
+		for w = 0; w+word[n].width <= room; n++ {
+			w += word[n].width
+		}
+		if n == 0 && word[0].width > c.Width-c.lmargin {
+			// A double-width glyph wider than the whole row is squeezed
+			// into a single cell, so that the text still moves on.
+			word[0].width = 1
+			n = 1
+		}
+		return n, false
+	}
+	return 0, false
+}
+
+// putWord writes the glyphs of a word from the current position.
+func (c *Cellbuf) putWord(word []glyph) {
+	for _, g := range word {
+		if !c.uncounted {
+			c.markOffset(c.row)
+			c.setCluster(c.offset, g.runes)
+			for range g.runes {
+				c.textCells = append(c.textCells, c.row*c.Width+c.col)
+				c.offset++
+			}
+		}
+		c.putGlyph(c.col, c.row, g, c.fg, c.bg)
+		c.col += g.width
+	}
+}
+
+// breakWordAt records that the text from offset continues a word broken at
+// the end of the row before it, so that Text does not join them with a space.
+func (c *Cellbuf) breakWordAt(offset int) {
+	if c.uncounted {
+		return
+	}
+	if c.wordBreaks == nil {
+		c.wordBreaks = make(map[int]bool)
+	}
+	c.wordBreaks[offset] = true
+}
+
+// justifyRow widens the spaces between the words of a row right of the left
+// margin, so that its text ends at the right edge.
+func (c *Cellbuf) justifyRow(row int) {
+	start := row * c.Width
+	var words [][2]int // start and end column of each word
+	for x := c.lmargin; x < c.Width && start+x < len(c.Cells); {
+		if c.Cells[start+x].Ch == 0 {
+			x++
+			continue
+		}
+		lo := x
+		for x < c.Width && start+x < len(c.Cells) && c.Cells[start+x].Ch != 0 {
+			x++
+		}
+		words = append(words, [2]int{lo, x})
+	}
+	if len(words) < 2 {
+		return
+	}
+	extra := c.Width - words[len(words)-1][1]
+	gaps := len(words) - 1
+
+	// The words move right, into cells the buffer may not have yet.
+	for start+c.Width > len(c.Cells) {
+		c.Cells = append(c.Cells, make([]termbox.Cell, 1024)...)
+	}
+
+	// Move the words from the right, so that they are not overwritten.
+	shifts := make([]int, c.Width)
+	for i := len(words) - 1; i > 0; i-- {
+		shift := extra * i / gaps
+		lo, hi := words[i][0], words[i][1]
+		for x := hi - 1; x >= lo; x-- {
+			shifts[x] = shift
+			c.Cells[start+x+shift] = c.Cells[start+x]
+			if shift > 0 {
+				c.Cells[start+x] = termbox.Cell{}
+			}
+		}
+	}
+	// The row is the last one with text, so its characters are the last
+	// ones laid out.
+	for j := len(c.textCells) - 1; j >= 0 && c.textCells[j] >= start; j-- {
+		if x := c.textCells[j] - start; x < c.Width {
+			c.textCells[j] += shifts[x]
+		}
+	}
+}